@@ -0,0 +1,63 @@
+package helper
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// no 0/o, 1/l/i so codes survive being read off paper
+const backupCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+func BackupCodeCount() int {
+	if count := viper.GetInt("tfa.backup_codes.count"); count > 0 {
+		return count
+	}
+	return 10
+}
+
+// BackupCodeLowThreshold is how many unused codes are left when the user gets
+// told to generate new ones.
+func BackupCodeLowThreshold() int {
+	if threshold := viper.GetInt("tfa.backup_codes.low_threshold"); threshold > 0 {
+		return threshold
+	}
+	return 3
+}
+
+// GenerateBackupCodes returns codes formatted like "x7k2m-9pq4r".
+func GenerateBackupCodes(count int) ([]string, error) {
+	codes := make([]string, 0, count)
+	max := big.NewInt(int64(len(backupCodeAlphabet)))
+
+	for i := 0; i < count; i++ {
+		b := make([]byte, 10)
+		for j := range b {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, err
+			}
+			b[j] = backupCodeAlphabet[n.Int64()]
+		}
+		codes = append(codes, string(b[:5])+"-"+string(b[5:]))
+	}
+
+	return codes, nil
+}
+
+// NormalizeBackupCode lets users type a code with or without the dash, in any
+// case.
+func NormalizeBackupCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+}
+
+func HashBackupCode(code string) string {
+	return HashToken(NormalizeBackupCode(code))
+}
@@ -0,0 +1,9 @@
+package helper
+
+const (
+	GrantTypePassword          = "password"
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+)
+
+var SupportedGrantTypes = []string{GrantTypePassword, GrantTypeAuthorizationCode, GrantTypeRefreshToken}
@@ -3,19 +3,15 @@ package helper
 import (
 	"encoding/base64"
 	"encoding/json"
-	"errors"
-	"github.com/ardiantirta/go-user-management/models"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"github.com/skip2/go-qrcode"
 	"github.com/spf13/viper"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
-
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
 var FormatRFC8601 = "2006-01-02T15:04:05Z"
@@ -32,6 +28,23 @@ func ErrorMessage(code int, message string) map[string]interface{} {
 	}
 }
 
+// PublicURL joins path onto server.public_url, the address users reach the api
+// at. Links sent to users must never point at the listen address.
+func PublicURL(path string) string {
+	base := viper.GetString("server.public_url")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+
+	return strings.TrimRight(base, "/") + path
+}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(?:[-_][A-Za-z0-9]{2,8})?$`)
+
+func IsValidLocale(locale string) bool {
+	return localePattern.MatchString(locale)
+}
+
 func GenerateRandomCode() string {
 	code := uuid.New().String()
 	return code
@@ -40,8 +53,6 @@ func GenerateRandomCode() string {
 const charset = "abcdefghijklmnopqrstuvwxyz" +
 	"ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
-const numbers = "1234567890"
-
 var seededRand *rand.Rand = rand.New(
 	rand.NewSource(time.Now().UnixNano()))
 
@@ -53,36 +64,12 @@ func GenerateSecretCode(length int) string {
 	return string(b)
 }
 
-func GenerateTFACode(length int) string {
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = numbers[seededRand.Intn(len(numbers))]
-	}
-	return string(b)
-}
-
 func GenerateQRCode(content string) string {
 	var png []byte
 	png, _ = qrcode.Encode(content, qrcode.Medium, 256)
 	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
 }
 
-func SendVerificationByEmail(sgEmail *models.SendGridEmail) error {
-	from := sgEmail.From
-	subject := sgEmail.Subject
-	to := sgEmail.To
-	plainContent := sgEmail.PlainContent
-	htmlContent := sgEmail.HtmlContent
-	message := mail.NewSingleEmail(from, subject, to, plainContent, htmlContent)
-	client := sendgrid.NewSendClient(viper.GetString("sendgrid.api"))
-	_, err := client.Send(message)
-	if err != nil {
-		return errors.New("error when send email verification")
-	}
-
-	return nil
-}
-
 func UploadImageToImgur(r *http.Request) (string, error) {
 	url := "https://api.imgur.com/3/image"
 	clientID := viper.GetString("imgur.client_key")
@@ -113,32 +100,3 @@ func UploadImageToImgur(r *http.Request) (string, error) {
 
 	return m["data"].(map[string]interface{})["link"].(string), nil
 }
-
-func RefreshToken(currentUser *models.User) *models.UserToken {
-	userToken := new(models.UserToken)
-
-	isTfa := false
-	if currentUser.IsTFA == 1 {
-		isTfa = true
-	}
-	createdAt := time.Now().UTC()
-	expiredAt := createdAt.AddDate(0, 0, 7)
-	signKey := []byte(viper.GetString("jwt.signkey"))
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, models.CustomClaims{
-		ID:    int(currentUser.ID),
-		Email: currentUser.Email,
-		IsTFA: isTfa,
-		Code: currentUser.TFACode,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expiredAt.Unix(),
-		},
-	})
-
-	tokenString, _ := token.SignedString(signKey)
-
-	userToken.Token = tokenString
-	userToken.Type = "Bearer"
-	userToken.DeletedAt = &expiredAt
-
-	return userToken
-}
\ No newline at end of file
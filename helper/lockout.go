@@ -0,0 +1,75 @@
+package helper
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	AuthFailureLogin        = "login"
+	AuthFailureTFA          = "tfa"
+	AuthFailureVerification = "verification"
+
+	AuthFailureScopeAccount = "account"
+	AuthFailureScopeIP      = "ip"
+)
+
+func LockoutThreshold(kind, scope string) int {
+	if threshold := viper.GetInt("lockout." + kind + "." + scope + "_threshold"); threshold > 0 {
+		return threshold
+	}
+
+	if scope == AuthFailureScopeIP {
+		return 20
+	}
+	return 5
+}
+
+func LockoutBaseDuration() time.Duration {
+	if duration := viper.GetDuration("lockout.base_duration"); duration > 0 {
+		return duration
+	}
+	return time.Minute
+}
+
+func LockoutMaxDuration() time.Duration {
+	if duration := viper.GetDuration("lockout.max_duration"); duration > 0 {
+		return duration
+	}
+	return time.Hour
+}
+
+// LockoutResetAfter is how long an account or ip has to stay quiet before its
+// failure counter starts from zero again.
+func LockoutResetAfter() time.Duration {
+	if duration := viper.GetDuration("lockout.reset_after"); duration > 0 {
+		return duration
+	}
+	return 24 * time.Hour
+}
+
+// LockoutDuration doubles the lock for every failure past the threshold,
+// capped at lockout.max_duration.
+func LockoutDuration(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	duration := LockoutBaseDuration()
+	for i := threshold; i < failures; i++ {
+		duration *= 2
+		if duration >= LockoutMaxDuration() {
+			return LockoutMaxDuration()
+		}
+	}
+
+	return duration
+}
+
+func UnlockTokenTTL() time.Duration {
+	if ttl := viper.GetDuration("lockout.unlock_token_ttl"); ttl > 0 {
+		return ttl
+	}
+	return time.Hour
+}
@@ -0,0 +1,202 @@
+package helper
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/ardiantirta/go-user-management/models"
+)
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+type userAgentPattern struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// order matters, most user agents also claim to be the browsers listed after
+// them (Edge and Opera contain "Chrome", Chrome contains "Safari")
+var browserPatterns = []userAgentPattern{
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/([\d.]+)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|Opera)/([\d.]+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/([\d.]+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/([\d.]+)`)},
+	{"Safari", regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+	{"Internet Explorer", regexp.MustCompile(`(?:MSIE |Trident/.*rv:)([\d.]+)`)},
+}
+
+var osPatterns = []userAgentPattern{
+	{"iOS", regexp.MustCompile(`(?:iPhone|iPad|iPod).*OS (\d+)`)},
+	{"Android", regexp.MustCompile(`Android (\d+)`)},
+	{"Chrome OS", regexp.MustCompile(`CrOS`)},
+	{"Windows", regexp.MustCompile(`Windows NT ([\d.]+)`)},
+	{"macOS", regexp.MustCompile(`Mac OS X (\d+)`)},
+	{"Linux", regexp.MustCompile(`Linux`)},
+}
+
+var botPattern = regexp.MustCompile(`(?i)bot|crawler|spider|curl|wget|python-requests|go-http-client`)
+
+// RequestMetadata collects what we record about the device behind a request.
+// X-Forwarded-For is only trusted when server.behind_proxy is set.
+func RequestMetadata(r *http.Request) *models.RequestMetadata {
+	meta := new(models.RequestMetadata)
+	meta.UserAgent = r.UserAgent()
+	if len(meta.UserAgent) > 512 {
+		meta.UserAgent = meta.UserAgent[:512]
+	}
+	meta.IPAddress = ClientIP(r)
+
+	return meta
+}
+
+func ClientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remote = host
+	}
+
+	if viper.GetBool("server.behind_proxy") {
+		if ip := forwardedIP(r, remote); ip != "" {
+			return ip
+		}
+	}
+
+	return remote
+}
+
+// forwardedIP walks X-Forwarded-For from the right, because only the entries
+// appended by our own proxies can be trusted and the client controls the
+// rest. With server.trusted_proxies set, the first address that isn't one of
+// those proxies is the client. Otherwise server.proxy_hops tells how many
+// proxies append to the header, and the client is that many entries from the
+// right.
+func forwardedIP(r *http.Request, remote string) string {
+	entries := make([]string, 0)
+	for _, header := range r.Header["X-Forwarded-For"] {
+		for _, entry := range strings.Split(header, ",") {
+			entries = append(entries, strings.TrimSpace(entry))
+		}
+	}
+
+	if trusted := trustedProxies(); len(trusted) > 0 {
+		if !isTrustedProxy(net.ParseIP(remote), trusted) {
+			return ""
+		}
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			ip := net.ParseIP(entries[i])
+			if ip == nil {
+				return ""
+			}
+			if !isTrustedProxy(ip, trusted) {
+				return ip.String()
+			}
+		}
+
+		return ""
+	}
+
+	hops := viper.GetInt("server.proxy_hops")
+	if hops < 1 {
+		hops = 1
+	}
+
+	if len(entries) < hops {
+		return ""
+	}
+
+	ip := net.ParseIP(entries[len(entries)-hops])
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
+
+// trustedProxies parses server.trusted_proxies, which takes both CIDRs and
+// single addresses. Invalid entries are skipped.
+func trustedProxies() []*net.IPNet {
+	networks := make([]*net.IPNet, 0)
+	for _, entry := range viper.GetStringSlice("server.trusted_proxies") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				continue
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	return networks
+}
+
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ParseUserAgent(userAgent string) (browser, os, device string) {
+	browser = matchUserAgent(browserPatterns, userAgent)
+	os = matchUserAgent(osPatterns, userAgent)
+
+	switch {
+	case userAgent == "":
+		device = DeviceUnknown
+	case botPattern.MatchString(userAgent):
+		device = DeviceBot
+	case strings.Contains(userAgent, "iPad") || strings.Contains(userAgent, "Tablet") ||
+		(strings.Contains(userAgent, "Android") && !strings.Contains(userAgent, "Mobile")):
+		device = DeviceTablet
+	case strings.Contains(userAgent, "Mobi") || strings.Contains(userAgent, "iPhone"):
+		device = DeviceMobile
+	default:
+		device = DeviceDesktop
+	}
+
+	return browser, os, device
+}
+
+func matchUserAgent(patterns []userAgentPattern, userAgent string) string {
+	for _, p := range patterns {
+		match := p.pattern.FindStringSubmatch(userAgent)
+		if match == nil {
+			continue
+		}
+
+		if len(match) > 1 && match[1] != "" {
+			return p.name + " " + strings.Split(match[1], ".")[0]
+		}
+		return p.name
+	}
+
+	return ""
+}
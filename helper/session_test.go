@@ -0,0 +1,72 @@
+package helper
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func withProxyConfig(behindProxy bool, trustedProxies []string, hops int) func() {
+	previous := map[string]interface{}{
+		"server.behind_proxy":    viper.Get("server.behind_proxy"),
+		"server.trusted_proxies": viper.Get("server.trusted_proxies"),
+		"server.proxy_hops":      viper.Get("server.proxy_hops"),
+	}
+
+	viper.Set("server.behind_proxy", behindProxy)
+	viper.Set("server.trusted_proxies", trustedProxies)
+	viper.Set("server.proxy_hops", hops)
+
+	return func() {
+		for key, value := range previous {
+			viper.Set(key, value)
+		}
+	}
+}
+
+func requestFrom(remoteAddr string, forwardedFor ...string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	for _, header := range forwardedFor {
+		r.Header.Add("X-Forwarded-For", header)
+	}
+	return r
+}
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name         string
+		behindProxy  bool
+		trusted      []string
+		hops         int
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"no proxy ignores the header", false, nil, 0, "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"remote addr without port", false, nil, 0, "203.0.113.7", nil, "203.0.113.7"},
+		{"rightmost entry by default", true, nil, 0, "10.0.0.2:5000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"hop count", true, nil, 2, "10.0.0.2:5000", []string{"1.2.3.4, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"fewer entries than hops", true, nil, 3, "10.0.0.2:5000", []string{"198.51.100.1, 10.0.0.3"}, "10.0.0.2"},
+		{"invalid entry", true, nil, 0, "10.0.0.2:5000", []string{"1.2.3.4, unknown"}, "10.0.0.2"},
+		{"no header", true, nil, 0, "10.0.0.2:5000", nil, "10.0.0.2"},
+		{"repeated headers", true, nil, 0, "10.0.0.2:5000", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"skips trusted proxies", true, []string{"10.0.0.0/8"}, 0, "10.0.0.2:5000", []string{"1.2.3.4, 198.51.100.1, 10.0.0.3, 10.1.1.1"}, "198.51.100.1"},
+		{"single trusted address", true, []string{"10.0.0.2", "10.0.0.3"}, 0, "10.0.0.2:5000", []string{"1.2.3.4, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"untrusted remote addr", true, []string{"10.0.0.0/8"}, 0, "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"only trusted entries", true, []string{"10.0.0.0/8"}, 0, "10.0.0.2:5000", []string{"10.0.0.3"}, "10.0.0.2"},
+		{"invalid entry before the proxies", true, []string{"10.0.0.0/8"}, 0, "10.0.0.2:5000", []string{"1.2.3.4, garbage, 10.0.0.3"}, "10.0.0.2"},
+		{"ipv6", true, []string{"fd00::/8"}, 0, "[fd00::2]:5000", []string{"2001:db8::1, fd00::3"}, "2001:db8::1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer withProxyConfig(c.behindProxy, c.trusted, c.hops)()
+
+			if got := ClientIP(requestFrom(c.remoteAddr, c.forwardedFor...)); got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
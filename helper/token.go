@@ -0,0 +1,50 @@
+package helper
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	TokenTypeBearer  = "Bearer"
+	TokenTypeRefresh = "Refresh"
+)
+
+func AccessTokenTTL() time.Duration {
+	if ttl := viper.GetDuration("jwt.access_token_ttl"); ttl > 0 {
+		return ttl
+	}
+	return 15 * time.Minute
+}
+
+func RefreshTokenTTL() time.Duration {
+	if ttl := viper.GetDuration("jwt.refresh_token_ttl"); ttl > 0 {
+		return ttl
+	}
+	return 30 * 24 * time.Hour
+}
+
+func GenerateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func PasswordResetTokenTTL() time.Duration {
+	if ttl := viper.GetDuration("password_reset.token_ttl"); ttl > 0 {
+		return ttl
+	}
+	return 30 * time.Minute
+}
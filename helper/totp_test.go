@@ -0,0 +1,165 @@
+package helper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// base32 of the ASCII seed "12345678901234567890" from RFC 6238 Appendix B
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateTOTPCodeRFC6238(t *testing.T) {
+	// The SHA-1 vectors from RFC 6238 Appendix B, truncated to the last
+	// TOTPDigits digits.
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, c := range cases {
+		code, err := GenerateTOTPCode(rfc6238Secret, TOTPStep(time.Unix(c.unix, 0)))
+		if err != nil {
+			t.Fatalf("t=%d: %v", c.unix, err)
+		}
+		if code != c.code {
+			t.Errorf("t=%d: got %s, want %s", c.unix, code, c.code)
+		}
+	}
+}
+
+func TestGenerateTOTPCodeLowercaseSecret(t *testing.T) {
+	upper, err := GenerateTOTPCode(rfc6238Secret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, err := GenerateTOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if upper != lower {
+		t.Errorf("got %s for the lowercase secret, want %s", lower, upper)
+	}
+}
+
+func TestGenerateTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := GenerateTOTPCode("not base32!", 1); err == nil {
+		t.Error("expected an error for an invalid secret")
+	}
+}
+
+func withSkew(skew int) func() {
+	previous := viper.Get("tfa.skew")
+	viper.Set("tfa.skew", skew)
+	return func() { viper.Set("tfa.skew", previous) }
+}
+
+func codeAt(t *testing.T, step int64) string {
+	code, err := GenerateTOTPCode(rfc6238Secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestValidateTOTPCodeSkewWindow(t *testing.T) {
+	defer withSkew(1)()
+
+	now := time.Unix(1234567890, 0)
+	current := TOTPStep(now)
+
+	for _, offset := range []int64{-1, 0, 1} {
+		step, ok := ValidateTOTPCode(rfc6238Secret, codeAt(t, current+offset), now, 0)
+		if !ok {
+			t.Errorf("offset %d: code rejected", offset)
+			continue
+		}
+		if step != current+offset {
+			t.Errorf("offset %d: got step %d, want %d", offset, step, current+offset)
+		}
+	}
+
+	for _, offset := range []int64{-2, 2} {
+		if _, ok := ValidateTOTPCode(rfc6238Secret, codeAt(t, current+offset), now, 0); ok {
+			t.Errorf("offset %d: code outside the window accepted", offset)
+		}
+	}
+}
+
+func TestValidateTOTPCodeNoSkew(t *testing.T) {
+	defer withSkew(0)()
+
+	now := time.Unix(1234567890, 0)
+	current := TOTPStep(now)
+
+	if _, ok := ValidateTOTPCode(rfc6238Secret, codeAt(t, current), now, 0); !ok {
+		t.Error("current code rejected")
+	}
+	if _, ok := ValidateTOTPCode(rfc6238Secret, codeAt(t, current-1), now, 0); ok {
+		t.Error("previous code accepted without skew")
+	}
+}
+
+func TestValidateTOTPCodeNegativeSkew(t *testing.T) {
+	defer withSkew(-3)()
+
+	now := time.Unix(1234567890, 0)
+	current := TOTPStep(now)
+
+	if _, ok := ValidateTOTPCode(rfc6238Secret, codeAt(t, current), now, 0); !ok {
+		t.Error("current code rejected")
+	}
+	if _, ok := ValidateTOTPCode(rfc6238Secret, codeAt(t, current+1), now, 0); ok {
+		t.Error("negative skew widened the window")
+	}
+}
+
+func TestValidateTOTPCodeReplay(t *testing.T) {
+	defer withSkew(1)()
+
+	now := time.Unix(1234567890, 0)
+	current := TOTPStep(now)
+	code := codeAt(t, current)
+
+	step, ok := ValidateTOTPCode(rfc6238Secret, code, now, 0)
+	if !ok {
+		t.Fatal("first use rejected")
+	}
+
+	if _, ok := ValidateTOTPCode(rfc6238Secret, code, now, step); ok {
+		t.Error("code accepted again at the consumed step")
+	}
+
+	// an older code from the window is no good once a newer step is used
+	if _, ok := ValidateTOTPCode(rfc6238Secret, codeAt(t, current-1), now, step); ok {
+		t.Error("code from before the consumed step accepted")
+	}
+
+	// the next step is still accepted
+	if next, ok := ValidateTOTPCode(rfc6238Secret, codeAt(t, current+1), now, step); !ok || next != current+1 {
+		t.Errorf("next step: got %d, %v", next, ok)
+	}
+}
+
+func TestValidateTOTPCodeMalformed(t *testing.T) {
+	defer withSkew(1)()
+
+	now := time.Unix(1234567890, 0)
+	code := codeAt(t, TOTPStep(now))
+
+	for _, c := range []string{"", code[:TOTPDigits-1], code + "0", "abcdef"} {
+		if _, ok := ValidateTOTPCode(rfc6238Secret, c, now, 0); ok {
+			t.Errorf("%q accepted", c)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package helper
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+
+	"github.com/ardiantirta/go-user-management/models"
+)
+
+const (
+	VerificationPurposeSignup       = "signup"
+	VerificationPurposeEmailChange  = "email_change"
+	VerificationPurposeReactivation = "reactivation"
+	// invitation codes are only accepted through the invitation endpoints,
+	// that's why it isn't listed in VerificationPurposes
+	VerificationPurposeInvitation = "invitation"
+)
+
+var VerificationPurposes = []string{
+	VerificationPurposeSignup,
+	VerificationPurposeEmailChange,
+	VerificationPurposeReactivation,
+}
+
+func IsVerificationPurpose(purpose string) bool {
+	for _, p := range VerificationPurposes {
+		if p == purpose {
+			return true
+		}
+	}
+	return false
+}
+
+// VerificationCodeTTL reads verification.ttl.<purpose>. An email change is
+// confirmed right away so its code lives much shorter than a signup one,
+// an invitation may sit in an inbox for days.
+func VerificationCodeTTL(purpose string) time.Duration {
+	if ttl := viper.GetDuration("verification.ttl." + purpose); ttl > 0 {
+		return ttl
+	}
+
+	switch purpose {
+	case VerificationPurposeEmailChange:
+		return time.Hour
+	case VerificationPurposeInvitation:
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func VerificationMaxAttempts() int {
+	if attempts := viper.GetInt("verification.max_attempts"); attempts > 0 {
+		return attempts
+	}
+	return 5
+}
+
+// VerificationURL is the link mailed for a code, the purpose travels with it
+// so the endpoint can tell a code used for the wrong flow from a bad one.
+func VerificationURL(verificationCode *models.UserVerificationCode) string {
+	return PublicURL("/auth/verification/" + verificationCode.Code + "?purpose=" + verificationCode.Purpose)
+}
+
+func InvitationURL(verificationCode *models.UserVerificationCode) string {
+	return PublicURL("/auth/invitations/" + verificationCode.Code)
+}
+
+// CreateVerificationCode issues a code inside tx and revokes the user's codes
+// still outstanding for the same purpose, only the latest email sent can be
+// used. Invitation codes don't belong to a user yet and are created by the
+// organization repository instead.
+func CreateVerificationCode(tx *gorm.DB, userID int, purpose string) (*models.UserVerificationCode, error) {
+	now := time.Now().UTC()
+	expiredAt := now.Add(VerificationCodeTTL(purpose))
+
+	verificationCode := new(models.UserVerificationCode)
+	verificationCode.UserID = userID
+	verificationCode.Code = GenerateRandomCode()
+	verificationCode.Purpose = purpose
+	verificationCode.IsUsed = 0
+	verificationCode.ExpiredAt = &expiredAt
+
+	// codes issued before purposes existed were all signup codes
+	purposes := []string{purpose}
+	if purpose == VerificationPurposeSignup {
+		purposes = append(purposes, "")
+	}
+
+	if err := tx.Table("user_verification_codes").
+		Where("user_id = ?", userID).
+		Where("purpose in (?)", purposes).
+		Where("is_used = ?", 0).
+		Where("revoked_at is null").
+		Update("revoked_at", now).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(&verificationCode).Error; err != nil {
+		return nil, err
+	}
+
+	return verificationCode, nil
+}
@@ -0,0 +1,25 @@
+package helper
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/ardiantirta/go-user-management/webauthn"
+)
+
+const WebAuthnSessionTTL = 5 * time.Minute
+
+const (
+	WebAuthnSessionRegistration = "registration"
+	WebAuthnSessionLogin        = "login"
+	WebAuthnSessionTFA          = "tfa"
+)
+
+func WebAuthnConfig() webauthn.Config {
+	return webauthn.Config{
+		RPID:   viper.GetString("webauthn.rp_id"),
+		RPName: viper.GetString("webauthn.rp_name"),
+		Origin: viper.GetString("webauthn.origin"),
+	}
+}
@@ -0,0 +1,217 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	DriverSendGrid = "sendgrid"
+	DriverSMTP     = "smtp"
+	DriverFile     = "file"
+	DriverMemory   = "memory"
+)
+
+type Address struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (a Address) String() string {
+	return (&mail.Address{Name: a.Name, Address: a.Email}).String()
+}
+
+type Message struct {
+	From         *Address `json:"from"`
+	To           Address  `json:"to"`
+	Subject      string   `json:"subject"`
+	PlainContent string   `json:"plain_content"`
+	HtmlContent  string   `json:"html_content"`
+	Template     string   `json:"template"`
+}
+
+type Mailer interface {
+	Send(message *Message) error
+}
+
+var (
+	mu            sync.RWMutex
+	defaultMailer Mailer
+)
+
+// Init picks the driver from mail.driver, sendgrid is kept as the default so
+// existing deployments keep sending the way they used to. It also loads the
+// email templates from mail.templates_dir.
+func Init() error {
+	m, err := New(viper.GetString("mail.driver"))
+	if err != nil {
+		return err
+	}
+
+	SetDefault(m)
+	return LoadTemplates(templatesDir())
+}
+
+func New(driver string) (Mailer, error) {
+	switch driver {
+	case "", DriverSendGrid:
+		return NewSendGridMailer(viper.GetString("sendgrid.api")), nil
+	case DriverSMTP:
+		return NewSMTPMailer(
+			viper.GetString("mail.smtp.host"),
+			viper.GetInt("mail.smtp.port"),
+			viper.GetString("mail.smtp.username"),
+			viper.GetString("mail.smtp.password"),
+		), nil
+	case DriverFile:
+		dir := viper.GetString("mail.file.dir")
+		if dir == "" {
+			dir = "mail"
+		}
+		return NewFileMailer(dir), nil
+	case DriverMemory:
+		return NewMemoryMailer(), nil
+	}
+
+	return nil, fmt.Errorf("unknown mail driver %q", driver)
+}
+
+func SetDefault(m Mailer) {
+	mu.Lock()
+	defaultMailer = m
+	mu.Unlock()
+}
+
+func Default() Mailer {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultMailer
+}
+
+func DefaultSender() *Address {
+	sender := &Address{
+		Name:  viper.GetString("mail.from.name"),
+		Email: viper.GetString("mail.from.email"),
+	}
+	if sender.Name == "" {
+		sender.Name = "go-user-management"
+	}
+	if sender.Email == "" {
+		sender.Email = "no-reply@localhost"
+	}
+
+	return sender
+}
+
+// Send hands the message to the queue when one is set and delivers it right
+// away otherwise. Driver errors are logged and replaced by a generic one, they
+// tend to carry provider details that have no business in an api response.
+func Send(message *Message) error {
+	if message.From == nil {
+		message.From = DefaultSender()
+	}
+
+	if q := currentQueue(); q != nil {
+		if err := q.Enqueue(message); err != nil {
+			logrus.WithField("to", message.To.Email).Error(err)
+			return errors.New("error when sending email")
+		}
+		return nil
+	}
+
+	if err := Deliver(message); err != nil {
+		logrus.WithField("to", message.To.Email).Error(err)
+		return errors.New("error when sending email")
+	}
+
+	return nil
+}
+
+// Deliver sends through the configured driver, bypassing the queue.
+func Deliver(message *Message) error {
+	m := Default()
+	if m == nil {
+		return errors.New("mailer is not initialized")
+	}
+
+	if message.From == nil {
+		message.From = DefaultSender()
+	}
+
+	return m.Send(message)
+}
+
+// Bytes renders the message as a multipart/alternative RFC 5322 message.
+func (m *Message) Bytes() ([]byte, error) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", m.PlainContent},
+		{"text/html; charset=utf-8", m.HtmlContent},
+	}
+	for _, p := range parts {
+		if p.content == "" {
+			continue
+		}
+
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write([]byte(p.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	from := DefaultSender()
+	if m.From != nil {
+		from = m.From
+	}
+
+	header := new(bytes.Buffer)
+	fmt.Fprintf(header, "From: %s\r\n", from)
+	fmt.Fprintf(header, "To: %s\r\n", m.To)
+	fmt.Fprintf(header, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(header, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(header, "Message-ID: <%s@%s>\r\n", messageID(), domain(from.Email))
+	fmt.Fprintf(header, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(header, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	return append(header.Bytes(), body.Bytes()...), nil
+}
+
+func messageID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func domain(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		return email[i+1:]
+	}
+	return "localhost"
+}
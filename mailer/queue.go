@@ -0,0 +1,98 @@
+package mailer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	JobPending = "pending"
+	JobSending = "sending"
+	JobSent    = "sent"
+	JobDead    = "dead"
+)
+
+var JobStatuses = []string{JobPending, JobSending, JobSent, JobDead}
+
+// Queue stores messages for the workers to deliver later, see
+// services/email/service for the postgres backed one.
+type Queue interface {
+	Enqueue(message *Message) error
+}
+
+var (
+	queueMu sync.RWMutex
+	queue   Queue
+)
+
+func SetQueue(q Queue) {
+	queueMu.Lock()
+	queue = q
+	queueMu.Unlock()
+}
+
+func currentQueue() Queue {
+	queueMu.RLock()
+	defer queueMu.RUnlock()
+	return queue
+}
+
+func QueueDisabled() bool {
+	return viper.GetBool("mail.queue.disabled")
+}
+
+func QueueWorkers() int {
+	if workers := viper.GetInt("mail.queue.workers"); workers > 0 {
+		return workers
+	}
+	return 2
+}
+
+func QueuePollInterval() time.Duration {
+	if interval := viper.GetDuration("mail.queue.poll_interval"); interval > 0 {
+		return interval
+	}
+	return 5 * time.Second
+}
+
+// QueueLease is how long a worker owns a claimed job, a job still sending
+// after that is assumed to belong to a dead worker and is claimed again.
+func QueueLease() time.Duration {
+	if lease := viper.GetDuration("mail.queue.lease"); lease > 0 {
+		return lease
+	}
+	return 2 * time.Minute
+}
+
+func QueueMaxAttempts() int {
+	if attempts := viper.GetInt("mail.queue.max_attempts"); attempts > 0 {
+		return attempts
+	}
+	return 8
+}
+
+// RetryDelay doubles from mail.queue.retry_base with every attempt, capped at
+// mail.queue.retry_max.
+func RetryDelay(attempts int) time.Duration {
+	base := viper.GetDuration("mail.queue.retry_base")
+	if base <= 0 {
+		base = 30 * time.Second
+	}
+
+	max := viper.GetDuration("mail.queue.retry_max")
+	if max <= 0 {
+		max = time.Hour
+	}
+
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		return max
+	}
+	return delay
+}
@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"fmt"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+type SendGridMailer struct {
+	APIKey string
+}
+
+func NewSendGridMailer(apiKey string) *SendGridMailer {
+	return &SendGridMailer{
+		APIKey: apiKey,
+	}
+}
+
+func (s *SendGridMailer) Send(message *Message) error {
+	from := mail.NewEmail(message.From.Name, message.From.Email)
+	to := mail.NewEmail(message.To.Name, message.To.Email)
+
+	client := sendgrid.NewSendClient(s.APIKey)
+	response, err := client.Send(mail.NewSingleEmail(from, message.Subject, to, message.PlainContent, message.HtmlContent))
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("sendgrid responded with %d: %s", response.StatusCode, response.Body)
+	}
+
+	return nil
+}
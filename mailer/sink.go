@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMailer writes every message as an .eml file so flows can be followed
+// locally without an email provider.
+type FileMailer struct {
+	Dir string
+}
+
+func NewFileMailer(dir string) *FileMailer {
+	return &FileMailer{
+		Dir: dir,
+	}
+}
+
+func (f *FileMailer) Send(message *Message) error {
+	body, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.Dir, 0700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), messageID()[:8])
+	return ioutil.WriteFile(filepath.Join(f.Dir, name), body, 0600)
+}
+
+// MemoryMailer keeps sent messages around for tests.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return new(MemoryMailer)
+}
+
+func (m *MemoryMailer) Send(message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent := *message
+	m.messages = append(m.messages, &sent)
+	return nil
+}
+
+func (m *MemoryMailer) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]*Message, len(m.messages))
+	copy(messages, m.messages)
+	return messages
+}
+
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	m.messages = nil
+	m.mu.Unlock()
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPMailer upgrades to STARTTLS whenever the server offers it, credentials
+// are only sent over an encrypted connection or to localhost.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func NewSMTPMailer(host string, port int, username, password string) *SMTPMailer {
+	if port == 0 {
+		port = 587
+	}
+
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+	}
+}
+
+func (s *SMTPMailer) Send(message *Message) error {
+	body, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	address := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	return smtp.SendMail(address, auth, message.From.Email, []string{message.To.Email}, body)
+}
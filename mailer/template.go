@@ -0,0 +1,297 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	textTemplate "text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/models"
+)
+
+const (
+	TemplateVerification           = "verification"
+	TemplateEmailChange            = "email_change"
+	TemplateEmailChangeNotice      = "email_change_notice"
+	TemplatePasswordReset          = "password_reset"
+	TemplateNewLogin               = "new_login"
+	TemplateTFAEnabled             = "tfa_enabled"
+	TemplateTFADisabled            = "tfa_disabled"
+	TemplateBackupCodesLow         = "backup_codes_low"
+	TemplateAccountLocked          = "account_locked"
+	TemplateOrganizationInvitation = "organization_invitation"
+)
+
+var TemplateNames = []string{
+	TemplateVerification,
+	TemplateEmailChange,
+	TemplateEmailChangeNotice,
+	TemplatePasswordReset,
+	TemplateNewLogin,
+	TemplateTFAEnabled,
+	TemplateTFADisabled,
+	TemplateBackupCodesLow,
+	TemplateAccountLocked,
+	TemplateOrganizationInvitation,
+}
+
+// every template is a pair of files in <templates_dir>/<locale>/, <name>.txt
+// holds the plain text body and defines the "subject" block, <name>.html holds
+// the html body.
+type emailTemplate struct {
+	text *textTemplate.Template
+	html *htmlTemplate.Template
+}
+
+type Rendered struct {
+	Subject      string `json:"subject"`
+	PlainContent string `json:"plain_content"`
+	HtmlContent  string `json:"html_content"`
+}
+
+var (
+	templatesMu sync.RWMutex
+	templates   = map[string]map[string]*emailTemplate{}
+)
+
+func DefaultLocale() string {
+	if locale := viper.GetString("mail.default_locale"); locale != "" {
+		return locale
+	}
+	return "en"
+}
+
+func templatesDir() string {
+	if dir := viper.GetString("mail.templates_dir"); dir != "" {
+		return dir
+	}
+	return filepath.Join("templates", "email")
+}
+
+// LoadTemplates parses every locale found in dir. The default locale has to
+// provide all templates since every other locale falls back to it.
+func LoadTemplates(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]map[string]*emailTemplate{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		locale := entry.Name()
+		for _, name := range TemplateNames {
+			t, err := parseTemplate(filepath.Join(dir, locale), name)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("template %s/%s: %v", locale, name, err)
+			}
+
+			if loaded[locale] == nil {
+				loaded[locale] = map[string]*emailTemplate{}
+			}
+			loaded[locale][name] = t
+		}
+	}
+
+	for _, name := range TemplateNames {
+		if loaded[DefaultLocale()][name] == nil {
+			return fmt.Errorf("template %s/%s is missing", DefaultLocale(), name)
+		}
+	}
+
+	templatesMu.Lock()
+	templates = loaded
+	templatesMu.Unlock()
+
+	return nil
+}
+
+func parseTemplate(dir, name string) (*emailTemplate, error) {
+	text, err := ioutil.ReadFile(filepath.Join(dir, name+".txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := ioutil.ReadFile(filepath.Join(dir, name+".html"))
+	if err != nil {
+		return nil, err
+	}
+
+	t := new(emailTemplate)
+	if t.text, err = textTemplate.New(name).Option("missingkey=zero").Parse(string(text)); err != nil {
+		return nil, err
+	}
+	if t.text.Lookup("subject") == nil {
+		return nil, errors.New("subject block is not defined")
+	}
+	if t.html, err = htmlTemplate.New(name).Option("missingkey=zero").Parse(string(html)); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Locales lists the loaded locales, the default one first.
+func Locales() []string {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+
+	locales := make([]string, 0, len(templates))
+	for locale := range templates {
+		if locale != DefaultLocale() {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales)
+
+	return append([]string{DefaultLocale()}, locales...)
+}
+
+// lookup tries the exact locale, then its language ("pt-BR" -> "pt") and
+// finally the default locale.
+func lookup(name, locale string) (*emailTemplate, string, error) {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+
+	candidates := []string{locale}
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		candidates = append(candidates, locale[:i])
+	}
+	candidates = append(candidates, DefaultLocale())
+
+	for _, candidate := range candidates {
+		if t, ok := templates[candidate][name]; ok {
+			return t, candidate, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("template %s not found", name)
+}
+
+func Render(name, locale string, data map[string]interface{}) (*Rendered, error) {
+	t, _, err := lookup(name, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{
+		"AppName": DefaultSender().Name,
+		"BaseURL": helper.PublicURL(""),
+	}
+	for key, value := range data {
+		values[key] = value
+	}
+
+	subject, text, html := new(bytes.Buffer), new(bytes.Buffer), new(bytes.Buffer)
+	if err := t.text.ExecuteTemplate(subject, "subject", values); err != nil {
+		return nil, err
+	}
+	if err := t.text.Execute(text, values); err != nil {
+		return nil, err
+	}
+	if err := t.html.Execute(html, values); err != nil {
+		return nil, err
+	}
+
+	return &Rendered{
+		Subject:      strings.TrimSpace(subject.String()),
+		PlainContent: strings.TrimSpace(text.String()) + "\n",
+		HtmlContent:  html.String(),
+	}, nil
+}
+
+// SendTemplate renders name in the recipient's locale and sends it.
+func SendTemplate(to Address, locale, name string, data map[string]interface{}) error {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if _, ok := data["Name"]; !ok {
+		data["Name"] = to.Name
+	}
+
+	rendered, err := Render(name, locale, data)
+	if err != nil {
+		return err
+	}
+
+	message := new(Message)
+	message.To = to
+	message.Subject = rendered.Subject
+	message.PlainContent = rendered.PlainContent
+	message.HtmlContent = rendered.HtmlContent
+	message.Template = name
+
+	return Send(message)
+}
+
+// SendSecurityNotice tells the user about a change they may not have made
+// themselves, the change already happened so a failed send is only logged.
+func SendSecurityNotice(user *models.User, name string, meta *models.RequestMetadata) {
+	if err := SendTemplate(Address{Name: user.FullName, Email: user.Email}, user.Locale, name, map[string]interface{}{
+		"Time":      time.Now().UTC().Format(helper.FormatRFC8601),
+		"IPAddress": meta.IPAddress,
+	}); err != nil {
+		logrus.WithField("user_id", user.ID).Error(err)
+	}
+}
+
+// PreviewData is the sample data admins see templates rendered with.
+func PreviewData(name string) map[string]interface{} {
+	data := map[string]interface{}{
+		"Name": "Jane Doe",
+	}
+
+	switch name {
+	case TemplateVerification:
+		data["Link"] = helper.PublicURL("/auth/verification/preview-code?purpose=" + helper.VerificationPurposeSignup)
+	case TemplateEmailChange:
+		data["Link"] = helper.PublicURL("/auth/verification/preview-code?purpose=" + helper.VerificationPurposeEmailChange)
+		data["NewEmail"] = "jane.doe@example.com"
+	case TemplateEmailChangeNotice:
+		data["Link"] = helper.PublicURL("/auth/email/cancel/preview-token")
+		data["NewEmail"] = "jane.doe@example.com"
+	case TemplatePasswordReset:
+		data["Token"] = "preview-token"
+		data["ExpiredAt"] = "2020-01-01T00:00:00Z"
+	case TemplateNewLogin:
+		data["Time"] = "2020-01-01T00:00:00Z"
+		data["IPAddress"] = "203.0.113.7"
+		data["Browser"] = "Firefox 120"
+		data["OS"] = "Linux"
+		data["Device"] = "desktop"
+	case TemplateTFAEnabled, TemplateTFADisabled:
+		data["Time"] = "2020-01-01T00:00:00Z"
+		data["IPAddress"] = "203.0.113.7"
+	case TemplateBackupCodesLow:
+		data["Remaining"] = 2
+	case TemplateAccountLocked:
+		data["Link"] = helper.PublicURL("/auth/unlock/preview-token")
+		data["LockedUntil"] = "2020-01-01T00:00:00Z"
+	case TemplateOrganizationInvitation:
+		data["Link"] = helper.PublicURL("/auth/invitations/preview-code")
+		data["OrganizationName"] = "Acme"
+		data["InviterName"] = "John Doe"
+		data["Role"] = "member"
+		data["ExpiredAt"] = "2020-01-01T00:00:00Z"
+	}
+
+	return data
+}
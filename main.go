@@ -5,6 +5,7 @@ import (
 	"github.com/ardiantirta/go-user-management/models"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -12,12 +13,45 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/mailer"
+	"github.com/ardiantirta/go-user-management/passwordpolicy"
+	"github.com/ardiantirta/go-user-management/services/client"
 	"github.com/ardiantirta/go-user-management/setup"
+	"github.com/ardiantirta/go-user-management/signing"
+
+	adminHttp "github.com/ardiantirta/go-user-management/services/admin/delivery/http"
+	_adminService "github.com/ardiantirta/go-user-management/services/admin/service"
 
 	authHttp "github.com/ardiantirta/go-user-management/services/auth/delivery/http"
 	_authRepository "github.com/ardiantirta/go-user-management/services/auth/repository"
 	_authService "github.com/ardiantirta/go-user-management/services/auth/service"
 
+	clientHttp "github.com/ardiantirta/go-user-management/services/client/delivery/http"
+	_clientRepository "github.com/ardiantirta/go-user-management/services/client/repository"
+	_clientService "github.com/ardiantirta/go-user-management/services/client/service"
+
+	emailHttp "github.com/ardiantirta/go-user-management/services/email/delivery/http"
+	_emailRepository "github.com/ardiantirta/go-user-management/services/email/repository"
+	_emailService "github.com/ardiantirta/go-user-management/services/email/service"
+
+	_eventRepository "github.com/ardiantirta/go-user-management/services/event/repository"
+
+	groupHttp "github.com/ardiantirta/go-user-management/services/group/delivery/http"
+	_groupRepository "github.com/ardiantirta/go-user-management/services/group/repository"
+	_groupService "github.com/ardiantirta/go-user-management/services/group/service"
+
+	oidcHttp "github.com/ardiantirta/go-user-management/services/oidc/delivery/http"
+	_oidcRepository "github.com/ardiantirta/go-user-management/services/oidc/repository"
+	_oidcService "github.com/ardiantirta/go-user-management/services/oidc/service"
+
+	rbacHttp "github.com/ardiantirta/go-user-management/services/rbac/delivery/http"
+	_rbacRepository "github.com/ardiantirta/go-user-management/services/rbac/repository"
+	_rbacService "github.com/ardiantirta/go-user-management/services/rbac/service"
+
+	organizationHttp "github.com/ardiantirta/go-user-management/services/organization/delivery/http"
+	_organizationRepository "github.com/ardiantirta/go-user-management/services/organization/repository"
+	_organizationService "github.com/ardiantirta/go-user-management/services/organization/service"
+
 	userHttp "github.com/ardiantirta/go-user-management/services/user/delivery/http"
 	_userRepository "github.com/ardiantirta/go-user-management/services/user/repository"
 	_userService "github.com/ardiantirta/go-user-management/services/user/service"
@@ -34,6 +68,29 @@ func init() {
 	}
 }
 
+// seedDefaultClient keeps deployments that still configure a single client.key
+// working by registering it as a first-party client on startup.
+func seedDefaultClient(clientRepository client.Repository) {
+	clientKey := viper.GetString("client.key")
+	if len(clientKey) == 0 {
+		return
+	}
+
+	if _, err := clientRepository.FetchClientByClientID(clientKey); err == nil {
+		return
+	}
+
+	defaultClient := new(models.Client)
+	defaultClient.ClientID = clientKey
+	defaultClient.Name = "default"
+	defaultClient.GrantTypes = strings.Join([]string{helper.GrantTypePassword, helper.GrantTypeRefreshToken}, " ")
+	defaultClient.AllowedOrigins = strings.Join(viper.GetStringSlice("client.origins"), " ")
+	defaultClient.IsActive = 1
+	if err := clientRepository.CreateClient(defaultClient); err != nil {
+		logrus.Error(err)
+	}
+}
+
 func main() {
 	dbConn := setup.DBConnection()
 	defer func() {
@@ -46,10 +103,48 @@ func main() {
 	dbConn.Debug().AutoMigrate(
 		&models.User{},
 		&models.UserVerificationCode{},
+		&models.EmailChange{},
+		&models.PasswordResetToken{},
+		&models.PasswordHistory{},
 		&models.UserToken{},
 		&models.BackUpCode{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnSession{},
+		&models.SigningKey{},
+		&models.OauthAuthorizationCode{},
+		&models.OauthConsent{},
+		&models.Client{},
+		&models.UserEvent{},
+		&models.AuthFailure{},
+		&models.EmailJob{},
+		&models.Role{},
+		&models.Permission{},
+		&models.RolePermission{},
+		&models.UserRole{},
+		&models.Organization{},
+		&models.OrganizationMember{},
+		&models.OrganizationInvitation{},
+		&models.Group{},
+		&models.GroupMember{},
+		&models.GroupRole{},
 	)
 
+	if err := setup.MigrateTFA(dbConn); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := signing.Init(dbConn); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := mailer.Init(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := passwordpolicy.Init(); err != nil {
+		logrus.Fatal(err)
+	}
+
 	r := mux.NewRouter()
 
 	r.Handle("/", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -61,16 +156,57 @@ func main() {
 		return
 	}))).Methods(http.MethodGet)
 
+	clientRepository := _clientRepository.NewClientRepository(dbConn)
+	seedDefaultClient(clientRepository)
+	clientService := _clientService.NewClientService(clientRepository)
+	clientHttp.NewClientHandler(r, clientService)
+
+	emailRepository := _emailRepository.NewEmailRepository(dbConn)
+	if !mailer.QueueDisabled() {
+		mailer.SetQueue(_emailService.NewEmailQueue(emailRepository))
+		_emailService.NewEmailWorker(emailRepository).Start(mailer.QueueWorkers(), mailer.QueuePollInterval())
+	}
+	emailService := _emailService.NewEmailService(emailRepository)
+	emailHttp.NewEmailHandler(r, emailService)
+
+	eventRepository := _eventRepository.NewEventRepository(dbConn)
+
+	rbacRepository := _rbacRepository.NewRbacRepository(dbConn)
+	rbacService := _rbacService.NewRbacService(rbacRepository, eventRepository)
+	if err := rbacService.Seed(); err != nil {
+		logrus.Fatal(err)
+	}
+	rbacHttp.NewRbacHandler(r, rbacService)
+
+	organizationRepository := _organizationRepository.NewOrganizationRepository(dbConn)
+
 	authRepository := _authRepository.NewPgsqlAuthRepository(dbConn)
-	authService := _authService.NewAuthService(authRepository)
+	authService := _authService.NewAuthService(authRepository, organizationRepository, eventRepository)
+	if interval := passwordpolicy.BreachRescreenInterval(); interval > 0 {
+		_authService.NewPasswordScreening(authRepository).Start(interval)
+	}
 	authHttp.NewAuthHandler(r, authService)
 
 	userRepository := _userRepository.NewUserRepository(dbConn)
-	userService := _userService.NewUserService(userRepository)
+	userService := _userService.NewUserService(userRepository, eventRepository)
 	userHttp.NewUserHandler(r, userService)
 
+	adminService := _adminService.NewAdminService(userRepository, authRepository, rbacRepository, eventRepository)
+	adminHttp.NewAdminHandler(r, adminService)
+
+	organizationService := _organizationService.NewOrganizationService(organizationRepository, authRepository, eventRepository)
+	organizationHttp.NewOrganizationHandler(r, organizationService)
+
+	groupRepository := _groupRepository.NewGroupRepository(dbConn)
+	groupService := _groupService.NewGroupService(groupRepository, eventRepository)
+	groupHttp.NewGroupHandler(r, groupService)
+
+	oidcRepository := _oidcRepository.NewPgsqlOIDCRepository(dbConn)
+	oidcService := _oidcService.NewOIDCService(oidcRepository, authRepository)
+	oidcHttp.NewOIDCHandler(r, oidcService)
+
 	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", "X-API-ClientID"})
-	originsOk := handlers.AllowedOrigins([]string{"*"})
+	originsOk := handlers.AllowedOriginValidator(clientRepository.IsOriginAllowed)
 	methodsOk := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE"})
 
 	logrus.Fatal(http.ListenAndServe(viper.GetString("server.address"), handlers.CORS(headersOk, originsOk, methodsOk)(r)))
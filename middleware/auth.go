@@ -7,27 +7,36 @@ import (
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/spf13/viper"
+	"github.com/sirupsen/logrus"
 
 	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/signing"
 
+	_clientRepository "github.com/ardiantirta/go-user-management/services/client/repository"
 	_userRepository "github.com/ardiantirta/go-user-management/services/user/repository"
 )
 
 func VerifyToken(tokenString string) (jwt.Claims, error) {
-	signKey := []byte(viper.GetString("jwt.signkey"))
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (i interface{}, err error) {
-		return signKey, err
-	})
-
+	claims, err := signing.Parse(tokenString)
 	if err != nil {
 		return nil, err
 	}
 
-	return token.Claims, nil
+	return claims, nil
 }
 
 func JwtAuthentication(next http.Handler) http.Handler {
+	return jwtAuthentication(next, false)
+}
+
+// JwtAuthenticationAllowExpiredPassword also accepts the restricted tokens
+// issued while the password is expired. It's only used for the routes needed
+// to change it: two factor authentication and the password change itself.
+func JwtAuthenticationAllowExpiredPassword(next http.Handler) http.Handler {
+	return jwtAuthentication(next, true)
+}
+
+func jwtAuthentication(next http.Handler, allowExpiredPassword bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		response := make(map[string]interface{})
 		tokenString := r.Header.Get("Authorization")
@@ -47,10 +56,30 @@ func JwtAuthentication(next http.Handler) http.Handler {
 			return
 		}
 
+		if aud, _ := claims.(jwt.MapClaims)["aud"].(string); aud != "" {
+			w.WriteHeader(http.StatusForbidden)
+			helper.Response(w, helper.ErrorMessage(0, "token is not valid for this api"))
+			return
+		}
+
 		id := claims.(jwt.MapClaims)["id"].(float64)
 		email := claims.(jwt.MapClaims)["email"].(string)
 		isTFA := claims.(jwt.MapClaims)["is_tfa"].(bool)
-		code := claims.(jwt.MapClaims)["code"].(string)
+		tfaVerified, _ := claims.(jwt.MapClaims)["tfa_verified"].(bool)
+		roles := claimStrings(claims.(jwt.MapClaims)["roles"])
+		permissions := claimStrings(claims.(jwt.MapClaims)["permissions"])
+		groups := claimStrings(claims.(jwt.MapClaims)["groups"])
+		organizationID, _ := claims.(jwt.MapClaims)["org"].(float64)
+		organizationRole, _ := claims.(jwt.MapClaims)["org_role"].(string)
+		passwordExpired, _ := claims.(jwt.MapClaims)["password_expired"].(bool)
+
+		if passwordExpired && !allowExpiredPassword {
+			response = helper.ErrorMessage(0, "password has expired, change it and log in again")
+			response["password_expired"] = true
+			w.WriteHeader(http.StatusForbidden)
+			helper.Response(w, response)
+			return
+		}
 
 		gClient := setup.DBConnection()
 		u := _userRepository.NewUserRepository(gClient)
@@ -60,18 +89,41 @@ func JwtAuthentication(next http.Handler) http.Handler {
 			return
 		}
 
+		if err := u.TouchUserToken(int(id), tokenString, helper.ClientIP(r)); err != nil {
+			logrus.Error(err)
+		}
+
 		r.Header.Set("id", strconv.Itoa(int(id)))
 		r.Header.Set("email", email)
 		r.Header.Set("is_tfa", strconv.FormatBool(isTFA))
-		r.Header.Set("code", code)
+		r.Header.Set("tfa_verified", strconv.FormatBool(tfaVerified))
+		r.Header.Set("roles", strings.Join(roles, " "))
+		r.Header.Set("permissions", strings.Join(permissions, " "))
+		r.Header.Set("groups", strings.Join(groups, " "))
+		r.Header.Set("organization_id", "")
+		if organizationID > 0 {
+			r.Header.Set("organization_id", strconv.Itoa(int(organizationID)))
+		}
+		r.Header.Set("organization_role", organizationRole)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+func claimStrings(claim interface{}) []string {
+	values, _ := claim.([]interface{})
+
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
 func CheckClientID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		clientKey := viper.GetString("client.key")
 		apiClientID := r.Header.Get("X-API-ClientID")
 
 		if len(apiClientID) < 1 {
@@ -80,12 +132,25 @@ func CheckClientID(next http.Handler) http.Handler {
 			return
 		}
 
-		if apiClientID != clientKey {
+		gClient := setup.DBConnection()
+		defer gClient.Close()
+
+		c := _clientRepository.NewClientRepository(gClient)
+		client, err := c.FetchActiveClient(apiClientID)
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			helper.Response(w, helper.ErrorMessage(0, "wrong X-API-ClientID"))
 			return
 		}
 
+		if !client.AllowsGrantType(helper.GrantTypePassword) {
+			w.WriteHeader(http.StatusForbidden)
+			helper.Response(w, helper.ErrorMessage(0, "client is not allowed to use this api"))
+			return
+		}
+
+		r.Header.Set("client_id", client.ClientID)
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -99,10 +164,10 @@ func TwoFactorAuthentication(next http.Handler) http.Handler {
 			return
 		}
 
-		code := r.Header.Get("code")
+		tfaVerified, _ := strconv.ParseBool(r.Header.Get("tfa_verified"))
 
 		canAccess := false
-		if isTfa == true && len(code) == 6 {
+		if isTfa == true && tfaVerified == true {
 			canAccess = true
 		} else if isTfa == false {
 			canAccess = true
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ardiantirta/go-user-management/helper"
+)
+
+// HasPermission reads the permissions JwtAuthentication copied from the
+// token claims.
+func HasPermission(r *http.Request, permission string) bool {
+	for _, granted := range strings.Fields(r.Header.Get("permissions")) {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission lets the request through only when the token carries
+// every one of permissions. It has to run after JwtAuthentication, which is
+// what overwrites the permissions header a caller could otherwise send.
+func RequirePermission(permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("id") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				helper.Response(w, helper.ErrorMessage(0, "missing auth token"))
+				return
+			}
+
+			for _, permission := range permissions {
+				if !HasPermission(r, permission) {
+					w.WriteHeader(http.StatusForbidden)
+					helper.Response(w, helper.ErrorMessage(0, "you are not allowed to access this resource"))
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ardiantirta/go-user-management/helper"
+)
+
+const (
+	RateLimitKeyIP     = "ip"
+	RateLimitKeyClient = "client"
+	RateLimitKeyUser   = "user"
+	// RateLimitKeyRecipient keys on the "recipient" field of the json body,
+	// so one address can't be flooded from many IPs
+	RateLimitKeyRecipient = "recipient"
+)
+
+// maxRateLimitBody caps how much of the body is read to find the recipient.
+const maxRateLimitBody = 1 << 16
+
+type RateLimitRule struct {
+	Limit  int
+	Window time.Duration
+	Key    string
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimitStore takes one token from the bucket identified by key. A bucket
+// holds rule.Limit tokens and refills completely over rule.Window.
+type RateLimitStore interface {
+	Take(key string, rule RateLimitRule) (*RateLimitResult, error)
+}
+
+var defaultRateLimitRules = map[string]RateLimitRule{
+	"register":                    {Limit: 5, Window: time.Hour, Key: RateLimitKeyIP},
+	"login":                       {Limit: 10, Window: time.Minute, Key: RateLimitKeyIP},
+	"password_forgot":             {Limit: 5, Window: time.Hour, Key: RateLimitKeyIP},
+	"verification_send":           {Limit: 3, Window: time.Hour, Key: RateLimitKeyIP},
+	"verification_send_recipient": {Limit: 3, Window: time.Hour, Key: RateLimitKeyRecipient},
+	"me":                          {Limit: 120, Window: time.Minute, Key: RateLimitKeyUser},
+}
+
+var (
+	rateLimitStoreMu sync.RWMutex
+	rateLimitStore   RateLimitStore = NewMemoryRateLimitStore()
+)
+
+func SetRateLimitStore(store RateLimitStore) {
+	rateLimitStoreMu.Lock()
+	rateLimitStore = store
+	rateLimitStoreMu.Unlock()
+}
+
+func currentRateLimitStore() RateLimitStore {
+	rateLimitStoreMu.RLock()
+	defer rateLimitStoreMu.RUnlock()
+	return rateLimitStore
+}
+
+// RateLimitRuleFor reads ratelimit.rules.<name> and falls back to the built in
+// rule for that group.
+func RateLimitRuleFor(name string) RateLimitRule {
+	rule := defaultRateLimitRules[name]
+
+	prefix := "ratelimit.rules." + name + "."
+	if limit := viper.GetInt(prefix + "limit"); limit > 0 {
+		rule.Limit = limit
+	}
+	if window := viper.GetDuration(prefix + "window"); window > 0 {
+		rule.Window = window
+	}
+	if key := viper.GetString(prefix + "key"); key != "" {
+		rule.Key = key
+	}
+
+	return rule
+}
+
+// rateLimitKey identifies the bucket for a request. The client and user keys
+// read headers set by CheckClientID and JwtAuthentication, so RateLimit has to
+// run after them.
+func rateLimitKey(r *http.Request, key string) string {
+	switch key {
+	case RateLimitKeyClient:
+		return r.Header.Get("client_id")
+	case RateLimitKeyUser:
+		return r.Header.Get("id")
+	case RateLimitKeyRecipient:
+		return recipientKey(r)
+	}
+
+	return helper.ClientIP(r)
+}
+
+// recipientKey reads the recipient from the json body and puts the body back
+// for the handler. Addresses are hashed so the store doesn't keep them.
+func recipientKey(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRateLimitBody))
+	if err != nil {
+		return ""
+	}
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+
+	form := struct {
+		Recipient string `json:"recipient"`
+	}{}
+	if err := json.Unmarshal(body, &form); err != nil {
+		return ""
+	}
+
+	recipient := strings.ToLower(strings.TrimSpace(form.Recipient))
+	if recipient == "" {
+		return ""
+	}
+
+	return helper.HashToken(recipient)
+}
+
+func RateLimit(name string) func(http.Handler) http.Handler {
+	rule := RateLimitRuleFor(name)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if viper.GetBool("ratelimit.disabled") || rule.Limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := rateLimitKey(r, rule.Key)
+			if key == "" {
+				key = helper.ClientIP(r)
+			}
+
+			result, err := currentRateLimitStore().Take(name+":"+rule.Key+":"+key, rule)
+			if err != nil {
+				// a broken backend must not take the api down with it
+				logrus.WithField("rule", name).Error(err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				w.WriteHeader(http.StatusTooManyRequests)
+				helper.Response(w, helper.ErrorMessage(0, "too many requests, please try again later"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type MemoryRateLimitStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*tokenBucket
+	prunedAt time.Time
+	now      func() time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:  make(map[string]*tokenBucket),
+		prunedAt: time.Now(),
+		now:      time.Now,
+	}
+}
+
+func (m *MemoryRateLimitStore) Take(key string, rule RateLimitRule) (*RateLimitResult, error) {
+	if rule.Limit <= 0 || rule.Window <= 0 {
+		return nil, errors.New("invalid rate limit rule")
+	}
+
+	capacity := float64(rule.Limit)
+	rate := capacity / rule.Window.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.prune(now)
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updatedAt: now}
+		m.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*rate)
+	bucket.updatedAt = now
+
+	return takeToken(bucket, capacity, rate, rule.Limit), nil
+}
+
+func takeToken(bucket *tokenBucket, capacity, rate float64, limit int) *RateLimitResult {
+	result := &RateLimitResult{Limit: limit}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+
+	result.Remaining = int(math.Floor(bucket.tokens))
+	result.Reset = time.Duration((capacity - bucket.tokens) / rate * float64(time.Second))
+
+	return result
+}
+
+// prune drops buckets that have been idle long enough to be full again, they
+// are indistinguishable from a missing bucket.
+func (m *MemoryRateLimitStore) prune(now time.Time) {
+	if now.Sub(m.prunedAt) < time.Minute {
+		return
+	}
+
+	for key, bucket := range m.buckets {
+		if now.Sub(bucket.updatedAt) > time.Hour {
+			delete(m.buckets, key)
+		}
+	}
+
+	m.prunedAt = now
+}
+
+// RedisClient is the subset of a redis client the rate limiter needs, any
+// client library can be adapted to it.
+type RedisClient interface {
+	Eval(script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// the bucket is kept in a hash so every instance behind a load balancer
+// shares it, the script makes refill and take atomic
+const redisTokenBucketScript = `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated_at")
+local tokens = tonumber(bucket[1]) or capacity
+local updated_at = tonumber(bucket[2]) or now
+
+tokens = math.min(capacity, tokens + (now - updated_at) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tokens, "updated_at", now)
+redis.call("PEXPIRE", KEYS[1], ttl)
+
+return {allowed, tostring(tokens)}
+`
+
+type RedisRateLimitStore struct {
+	Client RedisClient
+	Prefix string
+}
+
+func NewRedisRateLimitStore(client RedisClient, prefix string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{
+		Client: client,
+		Prefix: prefix,
+	}
+}
+
+func (s *RedisRateLimitStore) Take(key string, rule RateLimitRule) (*RateLimitResult, error) {
+	if rule.Limit <= 0 || rule.Window <= 0 {
+		return nil, errors.New("invalid rate limit rule")
+	}
+
+	capacity := float64(rule.Limit)
+	rate := capacity / rule.Window.Seconds()
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+
+	reply, err := s.Client.Eval(redisTokenBucketScript, []string{s.Prefix + key},
+		rule.Limit, strconv.FormatFloat(rate, 'f', -1, 64), strconv.FormatFloat(now, 'f', 6, 64), rule.Window.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, errors.New("unexpected rate limit reply")
+	}
+
+	allowed, _ := values[0].(int64)
+	tokensString, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensString, 64)
+	if err != nil {
+		return nil, errors.New("unexpected rate limit reply")
+	}
+
+	result := &RateLimitResult{
+		Allowed:   allowed == 1,
+		Limit:     rule.Limit,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((capacity - tokens) / rate * float64(time.Second)),
+	}
+
+	if !result.Allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+
+	return result, nil
+}
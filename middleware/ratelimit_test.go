@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestMemoryStore() (*MemoryRateLimitStore, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	store := NewMemoryRateLimitStore()
+	store.now = clock.Now
+	store.prunedAt = clock.now
+	return store, clock
+}
+
+func TestMemoryRateLimitStoreExhaustionAndRefill(t *testing.T) {
+	store, clock := newTestMemoryStore()
+
+	// 4 tokens over 8 seconds refill one every 2 seconds
+	rule := RateLimitRule{Limit: 4, Window: 8 * time.Second, Key: RateLimitKeyIP}
+
+	steps := []struct {
+		name       string
+		advance    time.Duration
+		allowed    bool
+		remaining  int
+		reset      time.Duration
+		retryAfter time.Duration
+	}{
+		{"first request", 0, true, 3, 2 * time.Second, 0},
+		{"second request", 0, true, 2, 4 * time.Second, 0},
+		{"third request", 0, true, 1, 6 * time.Second, 0},
+		{"last token", 0, true, 0, 8 * time.Second, 0},
+		{"exhausted", 0, false, 0, 8 * time.Second, 2 * time.Second},
+		{"half a token back", time.Second, false, 0, 7 * time.Second, time.Second},
+		{"one token back", time.Second, true, 0, 8 * time.Second, 0},
+		{"exhausted again", 0, false, 0, 8 * time.Second, 2 * time.Second},
+		{"refilled past capacity", time.Minute, true, 3, 2 * time.Second, 0},
+	}
+
+	for _, s := range steps {
+		clock.Advance(s.advance)
+
+		result, err := store.Take("login:ip:192.0.2.1", rule)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+
+		if result.Allowed != s.allowed {
+			t.Errorf("%s: allowed = %v, want %v", s.name, result.Allowed, s.allowed)
+		}
+		if result.Limit != rule.Limit {
+			t.Errorf("%s: limit = %d, want %d", s.name, result.Limit, rule.Limit)
+		}
+		if result.Remaining != s.remaining {
+			t.Errorf("%s: remaining = %d, want %d", s.name, result.Remaining, s.remaining)
+		}
+		if result.Reset != s.reset {
+			t.Errorf("%s: reset = %s, want %s", s.name, result.Reset, s.reset)
+		}
+		if result.RetryAfter != s.retryAfter {
+			t.Errorf("%s: retry after = %s, want %s", s.name, result.RetryAfter, s.retryAfter)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreSeparateKeys(t *testing.T) {
+	store, _ := newTestMemoryStore()
+	rule := RateLimitRule{Limit: 1, Window: time.Minute, Key: RateLimitKeyIP}
+
+	if result, _ := store.Take("login:ip:192.0.2.1", rule); !result.Allowed {
+		t.Fatal("first request from 192.0.2.1 was refused")
+	}
+	if result, _ := store.Take("login:ip:192.0.2.1", rule); result.Allowed {
+		t.Error("second request from 192.0.2.1 was allowed")
+	}
+	if result, _ := store.Take("login:ip:192.0.2.2", rule); !result.Allowed {
+		t.Error("192.0.2.2 shared the bucket of 192.0.2.1")
+	}
+}
+
+func TestMemoryRateLimitStoreInvalidRule(t *testing.T) {
+	store, _ := newTestMemoryStore()
+
+	for _, rule := range []RateLimitRule{
+		{Limit: 0, Window: time.Minute},
+		{Limit: 1, Window: 0},
+	} {
+		if _, err := store.Take("key", rule); err == nil {
+			t.Errorf("expected an error for %+v", rule)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	store, clock := newTestMemoryStore()
+
+	previousStore := currentRateLimitStore()
+	SetRateLimitStore(store)
+	defer SetRateLimitStore(previousStore)
+
+	settings := map[string]interface{}{
+		"ratelimit.disabled":          false,
+		"ratelimit.rules.test.limit":  2,
+		"ratelimit.rules.test.window": "4s",
+		"ratelimit.rules.test.key":    RateLimitKeyIP,
+	}
+	for key, value := range settings {
+		previous := viper.Get(key)
+		viper.Set(key, value)
+		defer viper.Set(key, previous)
+	}
+
+	handler := RateLimit("test")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	steps := []struct {
+		name       string
+		advance    time.Duration
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{"first request", 0, http.StatusOK, "1", "2", ""},
+		{"second request", 0, http.StatusOK, "0", "4", ""},
+		{"exhausted", 0, http.StatusTooManyRequests, "0", "4", "2"},
+		// fractions of a second are rounded up so clients don't retry early
+		{"partly refilled", 1500 * time.Millisecond, http.StatusTooManyRequests, "0", "3", "1"},
+		{"refilled", 500 * time.Millisecond, http.StatusOK, "0", "4", ""},
+	}
+
+	for _, s := range steps {
+		clock.Advance(s.advance)
+
+		r := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != s.status {
+			t.Errorf("%s: status = %d, want %d", s.name, w.Code, s.status)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("%s: RateLimit-Limit = %q, want %q", s.name, got, "2")
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != s.remaining {
+			t.Errorf("%s: RateLimit-Remaining = %q, want %q", s.name, got, s.remaining)
+		}
+		if got := w.Header().Get("RateLimit-Reset"); got != s.reset {
+			t.Errorf("%s: RateLimit-Reset = %q, want %q", s.name, got, s.reset)
+		}
+		if got := w.Header().Get("Retry-After"); got != s.retryAfter {
+			t.Errorf("%s: Retry-After = %q, want %q", s.name, got, s.retryAfter)
+		}
+	}
+}
+
+func TestCeilSeconds(t *testing.T) {
+	cases := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{time.Nanosecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{time.Minute, 60},
+	}
+
+	for _, c := range cases {
+		if got := ceilSeconds(c.d); got != c.want {
+			t.Errorf("ceilSeconds(%s) = %d, want %d", c.d, got, c.want)
+		}
+	}
+}
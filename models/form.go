@@ -5,6 +5,12 @@ type RegisterForm struct {
 	Email           string `json:"email"`
 	Password        string `json:"password"`
 	PasswordConfirm string `json:"password_confirm"`
+	Locale          string `json:"locale"`
+	// at most one of these, to create an organization, join an existing one
+	// or accept an invitation sent to Email
+	OrganizationName string `json:"organization_name"`
+	OrganizationSlug string `json:"organization_slug"`
+	InvitationCode   string `json:"invitation_code"`
 }
 
 type ForgotPasswordForm struct {
@@ -22,6 +28,7 @@ type UpdateUserInfoForm struct {
 	Location string `json:"location"`
 	Bio      string `json:"bio"`
 	Web      string `json:"web"`
+	Locale   string `json:"locale"`
 }
 
 type ChangePasswordForm struct {
@@ -44,6 +51,10 @@ type RemoveTFAForm struct {
 	Password string `json:"password"`
 }
 
+type RegenerateBackupCodesForm struct {
+	Password string `json:"password"`
+}
+
 type AuthenticationForm struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -52,3 +63,109 @@ type AuthenticationForm struct {
 type VerifyTFAForm struct {
 	Code string `json:"code"`
 }
+
+type RefreshTokenForm struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type WebAuthnResponseForm struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	AuthenticatorData string   `json:"authenticatorData"`
+	Signature         string   `json:"signature"`
+	UserHandle        string   `json:"userHandle"`
+	Transports        []string `json:"transports"`
+}
+
+type WebAuthnCredentialForm struct {
+	ID       string               `json:"id"`
+	RawID    string               `json:"rawId"`
+	Type     string               `json:"type"`
+	Name     string               `json:"name"`
+	Response WebAuthnResponseForm `json:"response"`
+}
+
+type WebAuthnLoginForm struct {
+	Email string `json:"email"`
+}
+
+type AuthorizeForm struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Prompt              string `json:"prompt"`
+	Approve             *bool  `json:"approve"`
+}
+
+type TokenForm struct {
+	GrantType    string `json:"grant_type"`
+	Code         string `json:"code"`
+	RedirectURI  string `json:"redirect_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	CodeVerifier string `json:"code_verifier"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ClientForm struct {
+	Name           string   `json:"name"`
+	Confidential   bool     `json:"confidential"`
+	RedirectURIs   []string `json:"redirect_uris"`
+	GrantTypes     []string `json:"grant_types"`
+	AllowedOrigins []string `json:"allowed_origins"`
+	IsActive       *bool    `json:"is_active"`
+}
+
+type RoleForm struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleAssignmentForm struct {
+	UserID int `json:"user_id"`
+}
+
+type GroupForm struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	ParentID    uint     `json:"parent_id"`
+	Roles       []string `json:"roles"`
+}
+
+type AdminCreateUserForm struct {
+	FullName string `json:"full_name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Locale   string `json:"locale"`
+	Verified bool   `json:"verified"`
+}
+
+type AdminUpdateUserForm struct {
+	FullName string `json:"full_name"`
+	Email    string `json:"email"`
+	Location string `json:"location"`
+	Bio      string `json:"bio"`
+	Web      string `json:"web"`
+	Locale   string `json:"locale"`
+}
+
+type OrganizationForm struct {
+	Name       string `json:"name"`
+	Slug       string `json:"slug"`
+	JoinDomain string `json:"join_domain"`
+}
+
+type OrganizationMemberForm struct {
+	Role string `json:"role"`
+}
+
+type OrganizationInvitationForm struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
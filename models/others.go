@@ -1,22 +1,75 @@
 package models
 
 import (
+	"time"
+
 	"github.com/dgrijalva/jwt-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
 type CustomClaims struct {
 	ID    int    `json:"id"`
 	Email string `json:"email"`
 	IsTFA bool `json:"is_tfa"`
-	Code string `json:"code"`
+	TFAVerified bool `json:"tfa_verified"`
+	Scope string `json:"scope,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Organization uint `json:"org,omitempty"`
+	OrganizationRole string `json:"org_role,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	PasswordExpired bool `json:"password_expired,omitempty"`
 	jwt.StandardClaims
 }
 
-type SendGridEmail struct {
-	From         *mail.Email `json:"from"`
-	To           *mail.Email `json:"to"`
-	Subject      string      `json:"subject"`
-	PlainContent string      `json:"plain_content"`
-	HtmlContent  string      `json:"html_content"`
+type IssuedToken struct {
+	FamilyID              string
+	AccessToken           string
+	AccessTokenExpiredAt  time.Time
+	RefreshToken          string
+	RefreshTokenExpiredAt time.Time
+}
+
+type RequestMetadata struct {
+	UserAgent      string
+	IPAddress      string
+	StartedAt      *time.Time
+	OrganizationID uint
+}
+
+type EmailJobFilter struct {
+	Status  string
+	Page    int
+	PerPage int
+}
+
+type EventFilter struct {
+	Types   []string
+	From    *time.Time
+	To      *time.Time
+	Page    int
+	PerPage int
+}
+
+type UserFilter struct {
+	Query          string
+	IsVerified     *bool
+	IsActive       *bool
+	Role           string
+	OrganizationID uint
+	Page           int
+	PerPage        int
+}
+
+// OrganizationMembership is an organization along with the role one member
+// holds in it.
+type OrganizationMembership struct {
+	Organization
+	Role     string
+	JoinedAt time.Time
+}
+
+type OrganizationMemberUser struct {
+	OrganizationMember
+	Email    string
+	FullName string
 }
@@ -2,6 +2,7 @@ package models
 
 import (
 	"github.com/jinzhu/gorm"
+	"strings"
 	"time"
 )
 
@@ -19,25 +20,317 @@ type User struct {
 	IsTFA         int       `json:"is_tfa"`
 	TFAActivation *time.Time `json:"tfa_activation"`
 	SecretCode string `json:"secret_code" gorm:"type:varchar(255)"`
-	TFACode string `json:"tfa_code" gorm:"type:varchar(255)"`
+	TFALastStep int64 `json:"tfa_last_step" gorm:"not null;default:0"`
+	Locale string `json:"locale" gorm:"type:varchar(16)"`
+	PasswordChangedAt *time.Time `json:"password_changed_at"`
+	PasswordCompromised int `json:"password_compromised"`
+	PasswordCheckedAt *time.Time `json:"password_checked_at"`
+	PasswordResetRequired int `json:"password_reset_required"`
+	DeactivatedAt *time.Time `json:"deactivated_at"`
+	OrganizationID uint `json:"organization_id"`
 }
 
 type UserVerificationCode struct {
 	gorm.Model
-	UserID int    `json:"user_id"`
-	Code   string `json:"code" gorm:"type:varchar(255)"`
-	IsUsed int    `json:"is_used"`
+	UserID    int        `json:"user_id" gorm:"index"`
+	Code      string     `json:"code" gorm:"type:varchar(255);index"`
+	Purpose   string     `json:"purpose" gorm:"type:varchar(32)"`
+	IsUsed    int        `json:"is_used"`
+	Attempts  int        `json:"attempts"`
+	ExpiredAt *time.Time `json:"expired_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// EmailChange is confirmed through a verification code sent to NewEmail, the
+// user's email only changes once that happens. The old address gets a link
+// to cancel it.
+type EmailChange struct {
+	gorm.Model
+	UserID             int        `json:"user_id" gorm:"index"`
+	OldEmail           string     `json:"old_email" gorm:"type:varchar(255)"`
+	NewEmail           string     `json:"new_email" gorm:"type:varchar(255)"`
+	VerificationCodeID uint       `json:"-" gorm:"index"`
+	CancelToken        string     `json:"-" gorm:"type:varchar(64);index"`
+	ExpiredAt          time.Time  `json:"expired_at"`
+	ConfirmedAt        *time.Time `json:"confirmed_at"`
+	CancelledAt        *time.Time `json:"cancelled_at"`
+}
+
+// PasswordResetToken only keeps the sha256 of the token mailed to the user.
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    int        `json:"user_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"type:varchar(64);unique_index"`
+	ExpiredAt time.Time  `json:"expired_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// PasswordHistory keeps the hashes of a user's previous passwords so they
+// can't be reused.
+type PasswordHistory struct {
+	gorm.Model
+	UserID   int    `json:"user_id" gorm:"index"`
+	Password string `json:"-" gorm:"type:varchar(255)"`
 }
 
 type UserToken struct {
 	gorm.Model
-	UserID int    `json:"user_id"`
-	Token  string `json:"token" gorm:"type:text"`
-	Type   string `json:"type" gorm:"type:varchar(255)"`
+	UserID           int        `json:"user_id"`
+	Token            string     `json:"token" gorm:"type:text"`
+	Type             string     `json:"type" gorm:"type:varchar(255)"`
+	FamilyID         string     `json:"family_id" gorm:"type:varchar(36);index"`
+	ClientID         string     `json:"client_id" gorm:"type:varchar(255)"`
+	Scope            string     `json:"scope" gorm:"type:varchar(255)"`
+	ExpiredAt        *time.Time `json:"expired_at"`
+	RotatedAt        *time.Time `json:"rotated_at"`
+	UserAgent        string     `json:"user_agent" gorm:"type:varchar(512)"`
+	Browser          string     `json:"browser" gorm:"type:varchar(255)"`
+	OS               string     `json:"os" gorm:"column:os;type:varchar(255)"`
+	Device           string     `json:"device" gorm:"type:varchar(32)"`
+	IPAddress        string     `json:"ip_address" gorm:"type:varchar(64)"`
+	SessionStartedAt *time.Time `json:"session_started_at"`
+	LastSeenAt       *time.Time `json:"last_seen_at"`
+	OrganizationID   uint       `json:"organization_id"`
 }
 
+// BackUpCode keeps the sha256 of the code, rows created before codes were
+// hashed still hold the plain code.
 type BackUpCode struct {
 	gorm.Model
-	UserID int `json:"user_id"`
-	Code string `json:"code" gorm:"type:varchar(255)"`
+	UserID int `json:"user_id" gorm:"index"`
+	Code string `json:"-" gorm:"type:varchar(255)"`
+	UsedAt *time.Time `json:"used_at"`
+}
+
+type WebAuthnCredential struct {
+	gorm.Model
+	UserID       int        `json:"user_id"`
+	Name         string     `json:"name" gorm:"type:varchar(255)"`
+	CredentialID string     `json:"credential_id" gorm:"type:varchar(1024);unique_index"`
+	PublicKey    string     `json:"-" gorm:"type:text"`
+	SignCount    int64      `json:"sign_count"`
+	AAGUID       string     `json:"aaguid" gorm:"column:aaguid;type:varchar(64)"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+type WebAuthnSession struct {
+	gorm.Model
+	UserID    int       `json:"user_id"`
+	Challenge string    `json:"challenge" gorm:"type:varchar(255);unique_index"`
+	Type      string    `json:"type" gorm:"type:varchar(255)"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+type SigningKey struct {
+	gorm.Model
+	KeyID       string    `json:"kid" gorm:"type:varchar(64);unique_index"`
+	Algorithm   string    `json:"alg" gorm:"type:varchar(16)"`
+	PrivateKey  string    `json:"-" gorm:"type:text"`
+	ActivatedAt time.Time `json:"activated_at"`
+	RetiredAt   time.Time `json:"retired_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type OauthAuthorizationCode struct {
+	gorm.Model
+	Code                string     `json:"-" gorm:"type:varchar(64);unique_index"`
+	ClientID            string     `json:"client_id" gorm:"type:varchar(255)"`
+	UserID              int        `json:"user_id"`
+	RedirectURI         string     `json:"redirect_uri" gorm:"type:text"`
+	Scope               string     `json:"scope" gorm:"type:varchar(255)"`
+	Nonce               string     `json:"nonce" gorm:"type:varchar(255)"`
+	CodeChallenge       string     `json:"code_challenge" gorm:"type:varchar(128)"`
+	CodeChallengeMethod string     `json:"code_challenge_method" gorm:"type:varchar(16)"`
+	FamilyID            string     `json:"family_id" gorm:"type:varchar(36)"`
+	UserAgent           string     `json:"user_agent" gorm:"type:varchar(512)"`
+	IPAddress           string     `json:"ip_address" gorm:"type:varchar(64)"`
+	AuthTime            time.Time  `json:"auth_time"`
+	ExpiredAt           time.Time  `json:"expired_at"`
+	UsedAt              *time.Time `json:"used_at"`
+}
+
+type OauthConsent struct {
+	gorm.Model
+	UserID   int    `json:"user_id" gorm:"unique_index:idx_oauth_consent_user_client"`
+	ClientID string `json:"client_id" gorm:"type:varchar(255);unique_index:idx_oauth_consent_user_client"`
+	Scope    string `json:"scope" gorm:"type:varchar(255)"`
+}
+
+type Client struct {
+	gorm.Model
+	ClientID       string `json:"client_id" gorm:"type:varchar(255);unique_index"`
+	SecretHash     string `json:"-" gorm:"type:varchar(255)"`
+	Name           string `json:"name" gorm:"type:varchar(255)"`
+	RedirectURIs   string `json:"-" gorm:"column:redirect_uris;type:text"`
+	GrantTypes     string `json:"-" gorm:"type:varchar(255)"`
+	AllowedOrigins string `json:"-" gorm:"type:text"`
+	IsActive       int    `json:"is_active"`
+}
+
+func (c *Client) IsConfidential() bool {
+	return c.SecretHash != ""
+}
+
+func (c *Client) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+func (c *Client) GrantTypeList() []string {
+	return strings.Fields(c.GrantTypes)
+}
+
+func (c *Client) AllowedOriginList() []string {
+	return strings.Fields(c.AllowedOrigins)
+}
+
+func (c *Client) AllowsRedirectURI(uri string) bool {
+	return containsField(c.RedirectURIs, uri)
+}
+
+func (c *Client) AllowsGrantType(grantType string) bool {
+	return containsField(c.GrantTypes, grantType)
+}
+
+func (c *Client) AllowsOrigin(origin string) bool {
+	return containsField(c.AllowedOrigins, origin)
+}
+
+func containsField(list, value string) bool {
+	for _, v := range strings.Fields(list) {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// UserEvent is append-only, it has no UpdatedAt/DeletedAt on purpose.
+type UserEvent struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	UserID    int       `json:"user_id" gorm:"index"`
+	Type      string    `json:"type" gorm:"type:varchar(64);index"`
+	IPAddress string    `json:"ip_address" gorm:"type:varchar(64)"`
+	UserAgent string    `json:"user_agent" gorm:"type:varchar(512)"`
+	Data      string    `json:"-" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+type AuthFailure struct {
+	gorm.Model
+	Kind            string     `json:"kind" gorm:"type:varchar(16);unique_index:idx_auth_failure_kind_scope_key"`
+	Scope           string     `json:"scope" gorm:"type:varchar(16);unique_index:idx_auth_failure_kind_scope_key"`
+	Key             string     `json:"key" gorm:"type:varchar(255);unique_index:idx_auth_failure_kind_scope_key"`
+	Failures        int        `json:"failures"`
+	LastFailureAt   time.Time  `json:"last_failure_at"`
+	LockedUntil     *time.Time `json:"locked_until"`
+	UnlockToken     string     `json:"-" gorm:"type:varchar(64);index"`
+	UnlockExpiredAt *time.Time `json:"-"`
+}
+
+// EmailJob bodies are cleared once the message is sent, they may carry
+// verification links and reset tokens.
+type EmailJob struct {
+	ID            uint       `json:"id" gorm:"primary_key"`
+	Template      string     `json:"template" gorm:"type:varchar(64)"`
+	FromName      string     `json:"from_name" gorm:"type:varchar(255)"`
+	FromEmail     string     `json:"from_email" gorm:"type:varchar(255)"`
+	ToName        string     `json:"to_name" gorm:"type:varchar(255)"`
+	ToEmail       string     `json:"to_email" gorm:"type:varchar(255)"`
+	Subject       string     `json:"subject" gorm:"type:varchar(255)"`
+	PlainContent  string     `json:"-" gorm:"type:text"`
+	HtmlContent   string     `json:"-" gorm:"type:text"`
+	Status        string     `json:"status" gorm:"type:varchar(16);index"`
+	Attempts      int        `json:"attempts"`
+	MaxAttempts   int        `json:"max_attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	LockedUntil   *time.Time `json:"-"`
+	LastError     string     `json:"last_error" gorm:"type:text"`
+	SentAt        *time.Time `json:"sent_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+type Role struct {
+	gorm.Model
+	Name        string `json:"name" gorm:"type:varchar(64);unique_index"`
+	Description string `json:"description" gorm:"type:varchar(255)"`
+	IsSystem    int    `json:"is_system"`
+}
+
+type Permission struct {
+	gorm.Model
+	Name        string `json:"name" gorm:"type:varchar(64);unique_index"`
+	Description string `json:"description" gorm:"type:varchar(255)"`
+}
+
+type RolePermission struct {
+	ID           uint      `json:"id" gorm:"primary_key"`
+	RoleID       uint      `json:"role_id" gorm:"unique_index:idx_role_permission"`
+	PermissionID uint      `json:"permission_id" gorm:"unique_index:idx_role_permission"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type UserRole struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	UserID    int       `json:"user_id" gorm:"unique_index:idx_user_role"`
+	RoleID    uint      `json:"role_id" gorm:"unique_index:idx_user_role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Organization is a customer company. Users registering with an email at
+// JoinDomain may join it without an invitation.
+type Organization struct {
+	gorm.Model
+	Name       string `json:"name" gorm:"type:varchar(128)"`
+	Slug       string `json:"slug" gorm:"type:varchar(64);unique_index"`
+	JoinDomain string `json:"join_domain" gorm:"type:varchar(255)"`
+}
+
+type OrganizationMember struct {
+	ID             uint      `json:"id" gorm:"primary_key"`
+	OrganizationID uint      `json:"organization_id" gorm:"unique_index:idx_organization_member"`
+	UserID         int       `json:"user_id" gorm:"unique_index:idx_organization_member;index"`
+	Role           string    `json:"role" gorm:"type:varchar(32)"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// OrganizationInvitation is sent to an email that may not have an account
+// yet. Its link carries a verification code with the invitation purpose,
+// resending replaces the code.
+type OrganizationInvitation struct {
+	gorm.Model
+	OrganizationID     uint       `json:"organization_id" gorm:"index"`
+	Email              string     `json:"email" gorm:"type:varchar(255);index"`
+	Role               string     `json:"role" gorm:"type:varchar(32)"`
+	InvitedBy          int        `json:"invited_by"`
+	VerificationCodeID uint       `json:"verification_code_id" gorm:"index"`
+	AcceptedBy         int        `json:"accepted_by"`
+	AcceptedAt         *time.Time `json:"accepted_at"`
+	RevokedAt          *time.Time `json:"revoked_at"`
+	ExpiredAt          *time.Time `json:"expired_at"`
+}
+
+// Group collects users so roles can be granted to all of them at once. A
+// group nested under ParentID passes its members on to the parent, they get
+// the roles granted anywhere up the chain.
+type Group struct {
+	gorm.Model
+	Name        string `json:"name" gorm:"type:varchar(64);unique_index"`
+	Description string `json:"description" gorm:"type:varchar(255)"`
+	ParentID    uint   `json:"parent_id" gorm:"index"`
+}
+
+type GroupMember struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	GroupID   uint      `json:"group_id" gorm:"unique_index:idx_group_member"`
+	UserID    int       `json:"user_id" gorm:"unique_index:idx_group_member;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type GroupRole struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	GroupID   uint      `json:"group_id" gorm:"unique_index:idx_group_role"`
+	RoleID    uint      `json:"role_id" gorm:"unique_index:idx_group_role"`
+	CreatedAt time.Time `json:"created_at"`
 }
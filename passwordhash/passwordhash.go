@@ -0,0 +1,187 @@
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Params are the settings new hashes are made with. Every hash carries its own
+// parameters so older hashes keep verifying after these change.
+type Params struct {
+	Algorithm   string
+	BcryptCost  int
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// CurrentParams reads password_hash from the config, argon2id with the
+// parameters OWASP recommends is the default.
+func CurrentParams() Params {
+	params := Params{
+		Algorithm:   AlgorithmArgon2id,
+		BcryptCost:  bcrypt.DefaultCost,
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+
+	if algorithm := viper.GetString("password_hash.algorithm"); algorithm != "" {
+		params.Algorithm = algorithm
+	}
+	if cost := viper.GetInt("password_hash.bcrypt_cost"); cost > 0 {
+		params.BcryptCost = cost
+	}
+	if memory := viper.GetInt("password_hash.argon2.memory"); memory > 0 {
+		params.Memory = uint32(memory)
+	}
+	if iterations := viper.GetInt("password_hash.argon2.iterations"); iterations > 0 {
+		params.Iterations = uint32(iterations)
+	}
+	if parallelism := viper.GetInt("password_hash.argon2.parallelism"); parallelism > 0 {
+		params.Parallelism = uint8(parallelism)
+	}
+	if saltLength := viper.GetInt("password_hash.argon2.salt_length"); saltLength > 0 {
+		params.SaltLength = uint32(saltLength)
+	}
+	if keyLength := viper.GetInt("password_hash.argon2.key_length"); keyLength > 0 {
+		params.KeyLength = uint32(keyLength)
+	}
+
+	return params
+}
+
+func Hash(password string) (string, error) {
+	return HashWithParams(password, CurrentParams())
+}
+
+func HashWithParams(password string, params Params) (string, error) {
+	switch params.Algorithm {
+	case AlgorithmArgon2id:
+		return hashArgon2id(password, params)
+	case AlgorithmBcrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hashed), nil
+	}
+
+	return "", fmt.Errorf("unsupported password hash algorithm %q", params.Algorithm)
+}
+
+// Verify reports whether password matches hash. An error means the hash
+// itself couldn't be read, not that the password was wrong.
+func Verify(hash, password string) (bool, error) {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	decoded, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), decoded.salt, decoded.params.Iterations, decoded.params.Memory, decoded.params.Parallelism, uint32(len(decoded.key)))
+	return subtle.ConstantTimeCompare(key, decoded.key) == 1, nil
+}
+
+// NeedsRehash is true when hash wasn't made with the current params, it
+// should be replaced the next time the password is known.
+func NeedsRehash(hash string) bool {
+	params := CurrentParams()
+
+	if isBcrypt(hash) {
+		if params.Algorithm != AlgorithmBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < params.BcryptCost
+	}
+
+	decoded, err := decodeArgon2id(hash)
+	if err != nil || params.Algorithm != AlgorithmArgon2id {
+		return true
+	}
+
+	return decoded.params.Memory < params.Memory ||
+		decoded.params.Iterations < params.Iterations ||
+		decoded.params.Parallelism != params.Parallelism ||
+		uint32(len(decoded.key)) < params.KeyLength
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// hashArgon2id encodes the hash the way the reference implementation does:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func hashArgon2id(password string, params Params) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		AlgorithmArgon2id, argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+type argon2idHash struct {
+	params Params
+	salt   []byte
+	key    []byte
+}
+
+func decodeArgon2id(hash string) (*argon2idHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnknownHash
+	}
+
+	decoded := &argon2idHash{params: Params{Algorithm: AlgorithmArgon2id}}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &decoded.params.Memory, &decoded.params.Iterations, &decoded.params.Parallelism); err != nil ||
+		decoded.params.Iterations == 0 || decoded.params.Parallelism == 0 {
+		return nil, ErrUnknownHash
+	}
+
+	var err error
+	if decoded.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if decoded.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(decoded.key) == 0 {
+		return nil, ErrUnknownHash
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,167 @@
+package passwordhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap settings so the tests don't spend seconds in argon2 and bcrypt
+var testSettings = map[string]interface{}{
+	"password_hash.algorithm":          AlgorithmArgon2id,
+	"password_hash.bcrypt_cost":        bcrypt.MinCost,
+	"password_hash.argon2.memory":      1024,
+	"password_hash.argon2.iterations":  1,
+	"password_hash.argon2.parallelism": 1,
+}
+
+func withSettings(settings map[string]interface{}) func() {
+	previous := make(map[string]interface{})
+	for key, value := range settings {
+		previous[key] = viper.Get(key)
+		viper.Set(key, value)
+	}
+	return func() {
+		for key, value := range previous {
+			viper.Set(key, value)
+		}
+	}
+}
+
+func TestHashVerifyRoundTrip(t *testing.T) {
+	defer withSettings(testSettings)()
+
+	cases := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{AlgorithmArgon2id, "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{AlgorithmBcrypt, "$2a$04$"},
+	}
+
+	for _, c := range cases {
+		restore := withSettings(map[string]interface{}{"password_hash.algorithm": c.algorithm})
+
+		hash, err := Hash("correct horse battery staple")
+		if err != nil {
+			t.Fatalf("%s: %v", c.algorithm, err)
+		}
+		if !strings.HasPrefix(hash, c.prefix) {
+			t.Errorf("%s: got %s, want prefix %s", c.algorithm, hash, c.prefix)
+		}
+
+		if ok, err := Verify(hash, "correct horse battery staple"); !ok || err != nil {
+			t.Errorf("%s: got %v, %v for the right password", c.algorithm, ok, err)
+		}
+		if ok, err := Verify(hash, "correct horse battery stapler"); ok || err != nil {
+			t.Errorf("%s: got %v, %v for a wrong password", c.algorithm, ok, err)
+		}
+		if ok, err := Verify(hash, ""); ok || err != nil {
+			t.Errorf("%s: got %v, %v for an empty password", c.algorithm, ok, err)
+		}
+
+		restore()
+	}
+}
+
+func TestHashSaltsEveryHash(t *testing.T) {
+	defer withSettings(testSettings)()
+
+	first, err := Hash("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Hash("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second {
+		t.Error("the same password hashed twice gave the same hash")
+	}
+}
+
+func TestHashUnsupportedAlgorithm(t *testing.T) {
+	defer withSettings(map[string]interface{}{"password_hash.algorithm": "md5"})()
+
+	if _, err := Hash("password123"); err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	cases := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"plain text", "password123"},
+		{"argon2i", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"missing key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ"},
+		{"extra part", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5$"},
+		{"old version", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"bad params", "$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"zero iterations", "$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"zero parallelism", "$argon2id$v=19$m=1024,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5"},
+		{"bad salt", "$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5a2V5"},
+		{"padded key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2U="},
+		{"empty key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$"},
+	}
+
+	for _, c := range cases {
+		ok, err := Verify(c.hash, "password123")
+		if ok {
+			t.Errorf("%s: verified a malformed hash", c.name)
+		}
+		if err != ErrUnknownHash {
+			t.Errorf("%s: got error %v, want %v", c.name, err, ErrUnknownHash)
+		}
+	}
+
+	if ok, err := Verify("$2a$04$tooshort", "password123"); ok || err == nil {
+		t.Errorf("truncated bcrypt: got %v, %v, want an error", ok, err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	defer withSettings(testSettings)()
+
+	argon2Hash, err := Hash("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptHash, err := HashWithParams("password123", Params{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		hash     string
+		settings map[string]interface{}
+		want     bool
+	}{
+		{"argon2id with the current params", argon2Hash, nil, false},
+		{"bcrypt while argon2id is configured", bcryptHash, nil, true},
+		{"argon2id with lower memory", argon2Hash, map[string]interface{}{"password_hash.argon2.memory": 2048}, true},
+		{"argon2id with fewer iterations", argon2Hash, map[string]interface{}{"password_hash.argon2.iterations": 2}, true},
+		{"argon2id with other parallelism", argon2Hash, map[string]interface{}{"password_hash.argon2.parallelism": 2}, true},
+		{"argon2id with a shorter key", argon2Hash, map[string]interface{}{"password_hash.argon2.key_length": 64}, true},
+		{"argon2id once memory is lowered", argon2Hash, map[string]interface{}{"password_hash.argon2.memory": 512}, false},
+		{"argon2id while bcrypt is configured", argon2Hash, map[string]interface{}{"password_hash.algorithm": AlgorithmBcrypt}, true},
+		{"bcrypt with the current cost", bcryptHash, map[string]interface{}{"password_hash.algorithm": AlgorithmBcrypt}, false},
+		{"bcrypt with a lower cost", bcryptHash, map[string]interface{}{"password_hash.algorithm": AlgorithmBcrypt, "password_hash.bcrypt_cost": bcrypt.MinCost + 1}, true},
+		{"malformed", "password123", nil, true},
+	}
+
+	for _, c := range cases {
+		restore := withSettings(c.settings)
+		if got := NeedsRehash(c.hash); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+		restore()
+	}
+}
@@ -0,0 +1,188 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Corpus answers how often a password shows up in known breaches. Lookups
+// read straight from disk, the full corpus is far too big to keep in memory.
+type Corpus interface {
+	Count(password string) (int, error)
+	UpdatedAt() (time.Time, error)
+}
+
+// OpenCorpus accepts either the single file of "HASH:COUNT" lines sorted by
+// hash, or a directory of range files named after the 5 character hash prefix
+// holding "SUFFIX:COUNT" lines, the layout the HIBP downloader produces.
+func OpenCorpus(path string) (Corpus, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.New("failed to open breached password corpus: " + err.Error())
+	}
+
+	if info.IsDir() {
+		return &RangeCorpus{Dir: path}, nil
+	}
+	return &FileCorpus{Path: path}, nil
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// parseCorpusLine splits "HASH:COUNT", lines without a count are counted once.
+func parseCorpusLine(line string) (string, int) {
+	line = strings.TrimSpace(line)
+
+	separator := strings.IndexByte(line, ':')
+	if separator < 0 {
+		return strings.ToUpper(line), 1
+	}
+
+	count, err := strconv.Atoi(line[separator+1:])
+	if err != nil {
+		count = 1
+	}
+	return strings.ToUpper(line[:separator]), count
+}
+
+type FileCorpus struct {
+	Path string
+}
+
+// Count binary searches the sorted file by byte offset, a lookup reads a few
+// dozen lines no matter how large the file is.
+func (c *FileCorpus) Count(password string) (int, error) {
+	target := sha1Hex(password)
+
+	file, err := os.Open(c.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// every line that could still match starts inside [low, high)
+	low, high := int64(0), info.Size()
+	for low < high {
+		mid := low + (high-low)/2
+
+		line, start, err := lineFrom(file, mid, info.Size())
+		if err != nil {
+			return 0, err
+		}
+
+		if start >= high {
+			high = mid
+			continue
+		}
+
+		hash, count := parseCorpusLine(line)
+		switch {
+		case hash == target:
+			return count, nil
+		case hash < target:
+			low = start + int64(len(line))
+		default:
+			high = start
+		}
+	}
+
+	return 0, nil
+}
+
+// lineFrom returns the first line starting at or after offset, with its
+// newline, and where it starts. An empty line means the end of the file.
+func lineFrom(file *os.File, offset, size int64) (string, int64, error) {
+	start := offset
+	if offset > 0 {
+		start = offset - 1
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(file, start, size-start))
+
+	if offset > 0 {
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return "", size, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		start += int64(len(skipped))
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	if line == "" {
+		return "", size, nil
+	}
+
+	return line, start, nil
+}
+
+func (c *FileCorpus) UpdatedAt() (time.Time, error) {
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+type RangeCorpus struct {
+	Dir string
+}
+
+func (c *RangeCorpus) Count(password string) (int, error) {
+	hash := sha1Hex(password)
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(c.Dir, prefix))
+	if os.IsNotExist(err) {
+		file, err = os.Open(filepath.Join(c.Dir, prefix+".txt"))
+	}
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count := parseCorpusLine(scanner.Text())
+		if lineSuffix == suffix {
+			return count, nil
+		}
+	}
+
+	return 0, scanner.Err()
+}
+
+// UpdatedAt uses the directory's own modification time, which only moves when
+// files are added or renamed in it. Refresh range files by writing them next
+// to the old ones and renaming them into place.
+func (c *RangeCorpus) UpdatedAt() (time.Time, error) {
+	info, err := os.Stat(c.Dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
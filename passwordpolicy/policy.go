@@ -0,0 +1,495 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/passwordhash"
+)
+
+const (
+	ViolationTooShort      = "too_short"
+	ViolationTooLong       = "too_long"
+	ViolationMissingUpper  = "missing_upper"
+	ViolationMissingLower  = "missing_lower"
+	ViolationMissingDigit  = "missing_digit"
+	ViolationMissingSymbol = "missing_symbol"
+	ViolationTooFewClasses = "too_few_classes"
+	ViolationBanned        = "banned"
+	ViolationUserInfo      = "similar_to_user_info"
+	ViolationBreached      = "breached"
+	ViolationReused        = "reused"
+)
+
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Violations is returned as an error so repositories can hand it back through
+// their usual error return and services can still tell it apart.
+type Violations []Violation
+
+func (v Violations) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, violation := range v {
+		messages = append(messages, violation.Message)
+	}
+	return strings.Join(messages, ", ")
+}
+
+// UserInfo is what a password must not resemble.
+type UserInfo struct {
+	Email    string
+	FullName string
+}
+
+type Policy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	MinClasses    int
+	Banned        map[string]bool
+	CheckUserInfo bool
+	MaxSimilarity float64
+	History       int
+	MaxAge        time.Duration
+
+	// Corpus is nil unless password_policy.breached.file is set.
+	Corpus           Corpus
+	BreachedMinCount int
+}
+
+// the handful of passwords every credential stuffing list starts with, the
+// rest should come from password_policy.banned_file
+var defaultBannedPasswords = []string{
+	"123456", "12345678", "123456789", "1234567890", "111111", "000000",
+	"password", "passw0rd", "qwerty", "qwertyuiop", "abc123", "letmein",
+	"welcome", "iloveyou", "admin", "monkey", "dragon", "football",
+	"baseball", "sunshine", "princess", "master", "login", "starwars",
+	"trustno1", "changeme", "secret",
+}
+
+var (
+	mu            sync.RWMutex
+	currentPolicy *Policy
+)
+
+// Init loads the policy from password_policy, including the banned password
+// file when one is configured.
+func Init() error {
+	policy, err := Load()
+	if err != nil {
+		return err
+	}
+
+	Set(policy)
+	return nil
+}
+
+func Set(policy *Policy) {
+	mu.Lock()
+	currentPolicy = policy
+	mu.Unlock()
+}
+
+// Current returns the loaded policy, or the one built from the config as it
+// is now when Init hasn't run.
+func Current() *Policy {
+	mu.RLock()
+	policy := currentPolicy
+	mu.RUnlock()
+
+	if policy == nil {
+		policy, _ = Load()
+	}
+	return policy
+}
+
+func Load() (*Policy, error) {
+	policy := &Policy{
+		MinLength:     8,
+		MaxLength:     128,
+		CheckUserInfo: true,
+		MaxSimilarity: 0.7,
+		History:       5,
+		Banned:        make(map[string]bool),
+
+		BreachedMinCount: 1,
+	}
+
+	if viper.IsSet("password_policy.min_length") {
+		policy.MinLength = viper.GetInt("password_policy.min_length")
+	}
+	if viper.IsSet("password_policy.max_length") {
+		policy.MaxLength = viper.GetInt("password_policy.max_length")
+	}
+	if viper.IsSet("password_policy.check_user_info") {
+		policy.CheckUserInfo = viper.GetBool("password_policy.check_user_info")
+	}
+	if viper.IsSet("password_policy.max_similarity") {
+		policy.MaxSimilarity = viper.GetFloat64("password_policy.max_similarity")
+	}
+	if viper.IsSet("password_policy.history") {
+		policy.History = viper.GetInt("password_policy.history")
+	}
+
+	policy.RequireUpper = viper.GetBool("password_policy.require_upper")
+	policy.RequireLower = viper.GetBool("password_policy.require_lower")
+	policy.RequireDigit = viper.GetBool("password_policy.require_digit")
+	policy.RequireSymbol = viper.GetBool("password_policy.require_symbol")
+	policy.MinClasses = viper.GetInt("password_policy.min_classes")
+	policy.MaxAge = viper.GetDuration("password_policy.max_age")
+
+	for _, password := range defaultBannedPasswords {
+		policy.Banned[password] = true
+	}
+	for _, password := range viper.GetStringSlice("password_policy.banned_passwords") {
+		policy.Banned[strings.ToLower(password)] = true
+	}
+
+	if path := viper.GetString("password_policy.banned_file"); path != "" {
+		if err := policy.loadBannedFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if path := viper.GetString("password_policy.breached.file"); path != "" {
+		corpus, err := OpenCorpus(path)
+		if err != nil {
+			return nil, err
+		}
+		policy.Corpus = corpus
+	}
+	if minCount := viper.GetInt("password_policy.breached.min_count"); minCount > 0 {
+		policy.BreachedMinCount = minCount
+	}
+
+	return policy, nil
+}
+
+// loadBannedFile reads one password per line, blank lines and lines starting
+// with # are skipped.
+func (p *Policy) loadBannedFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open banned password file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.Banned[strings.ToLower(line)] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read banned password file: %v", err)
+	}
+
+	return nil
+}
+
+// Check runs every rule that doesn't need the user's previous passwords and
+// returns all the violations at once, so the user can fix them in one go.
+func (p *Policy) Check(password string, info UserInfo) Violations {
+	violations := make(Violations, 0)
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooLong,
+			Message: fmt.Sprintf("password must be at most %d characters", p.MaxLength),
+		})
+	}
+
+	violations = append(violations, p.checkClasses(password)...)
+
+	if p.isBanned(password) {
+		violations = append(violations, Violation{
+			Code:    ViolationBanned,
+			Message: "password is too common, choose a less predictable one",
+		})
+	}
+
+	if p.CheckUserInfo && p.isSimilarToUserInfo(password, info) {
+		violations = append(violations, Violation{
+			Code:    ViolationUserInfo,
+			Message: "password must not be similar to your email or name",
+		})
+	}
+
+	if p.IsBreached(password) {
+		violations = append(violations, Violation{
+			Code:    ViolationBreached,
+			Message: "password has appeared in a data breach, choose a different one",
+		})
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return violations
+}
+
+func (p *Policy) checkClasses(password string) Violations {
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	violations := make(Violations, 0)
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, Violation{Code: ViolationMissingUpper, Message: "password must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, Violation{Code: ViolationMissingLower, Message: "password must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{Code: ViolationMissingDigit, Message: "password must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{Code: ViolationMissingSymbol, Message: "password must contain a symbol"})
+	}
+
+	classes := 0
+	for _, has := range []bool{hasUpper, hasLower, hasDigit, hasSymbol} {
+		if has {
+			classes++
+		}
+	}
+	if classes < p.MinClasses {
+		violations = append(violations, Violation{
+			Code:    ViolationTooFewClasses,
+			Message: fmt.Sprintf("password must mix at least %d of uppercase letters, lowercase letters, digits and symbols", p.MinClasses),
+		})
+	}
+
+	return violations
+}
+
+// isBanned also catches the usual decorations, "Password1!" is as guessable
+// as "password".
+func (p *Policy) isBanned(password string) bool {
+	lower := strings.ToLower(password)
+	if p.Banned[lower] {
+		return true
+	}
+
+	stripped := strings.TrimRightFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	return stripped != "" && p.Banned[stripped]
+}
+
+func (p *Policy) isSimilarToUserInfo(password string, info UserInfo) bool {
+	normalized := normalize(password)
+	if normalized == "" {
+		return false
+	}
+
+	candidates := make([]string, 0)
+	if at := strings.LastIndex(info.Email, "@"); at > 0 {
+		candidates = append(candidates, info.Email[:at], strings.Split(info.Email[at+1:], ".")[0])
+	}
+	candidates = append(candidates, info.Email, info.FullName)
+	candidates = append(candidates, strings.Fields(info.FullName)...)
+
+	for _, candidate := range candidates {
+		candidate = normalize(candidate)
+		// short fragments like initials would reject half of all passwords
+		if utf8.RuneCountInString(candidate) < 3 {
+			continue
+		}
+
+		if strings.Contains(normalized, candidate) || strings.Contains(candidate, normalized) {
+			return true
+		}
+		if p.MaxSimilarity > 0 && similarity(normalized, candidate) >= p.MaxSimilarity {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsBreached fails open, an unreadable corpus is logged rather than stopping
+// everyone from setting a password.
+func (p *Policy) IsBreached(password string) bool {
+	if p.Corpus == nil {
+		return false
+	}
+
+	count, err := p.Corpus.Count(password)
+	if err != nil {
+		logrus.WithField("corpus", "breached").Error(err)
+		return false
+	}
+
+	return count >= p.BreachedMinCount
+}
+
+// ScreenedAt is the password_checked_at to store for a password that just
+// passed Check, nil when there is no corpus to screen against.
+func (p *Policy) ScreenedAt() *time.Time {
+	if p.Corpus == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	return &now
+}
+
+// BreachRescreenInterval is how often the corpus is checked for updates, 0
+// turns the rescreen job off.
+func BreachRescreenInterval() time.Duration {
+	return viper.GetDuration("password_policy.breached.rescreen_interval")
+}
+
+// CheckHistory compares the password with the hashes of the previous ones,
+// newest first. Only the last History of them are looked at.
+func (p *Policy) CheckHistory(password string, hashes []string) Violations {
+	if p.History <= 0 {
+		return nil
+	}
+
+	if len(hashes) > p.History {
+		hashes = hashes[:p.History]
+	}
+
+	for _, hash := range hashes {
+		if ok, _ := passwordhash.Verify(hash, password); ok {
+			return Violations{{
+				Code:    ViolationReused,
+				Message: fmt.Sprintf("password must not be one of your last %d passwords", p.History),
+			}}
+		}
+	}
+
+	return nil
+}
+
+// ExpiresAt is nil when passwords never expire.
+func (p *Policy) ExpiresAt(changedAt time.Time) *time.Time {
+	if p.MaxAge <= 0 {
+		return nil
+	}
+
+	expiresAt := changedAt.Add(p.MaxAge)
+	return &expiresAt
+}
+
+func (p *Policy) Expired(changedAt time.Time) bool {
+	expiresAt := p.ExpiresAt(changedAt)
+	return expiresAt != nil && time.Now().UTC().After(*expiresAt)
+}
+
+// ChangedAt falls back to the account creation for passwords set before
+// password_changed_at was recorded.
+func ChangedAt(user *models.User) time.Time {
+	if user.PasswordChangedAt != nil {
+		return *user.PasswordChangedAt
+	}
+	return user.CreatedAt
+}
+
+func (p *Policy) UserExpired(user *models.User) bool {
+	return p.Expired(ChangedAt(user))
+}
+
+// ErrorMessage keeps the usual code and message keys and lists every
+// violation under violations when err came from the policy.
+func ErrorMessage(err error) map[string]interface{} {
+	violations, ok := err.(Violations)
+	if !ok {
+		return helper.ErrorMessage(0, err.Error())
+	}
+
+	response := helper.ErrorMessage(0, "password does not meet the password policy")
+	response["violations"] = violations
+	return response
+}
+
+func normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// similarity is 1 minus the levenshtein distance over the longer length, 1
+// means equal.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return 1 - float64(previous[len(rb)])/float64(longest)
+}
+
+func min(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
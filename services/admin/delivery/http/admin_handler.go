@@ -0,0 +1,218 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/middleware"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/admin"
+	"github.com/ardiantirta/go-user-management/services/rbac"
+)
+
+type AdminHandler struct {
+	AdminService admin.Service
+}
+
+func NewAdminHandler(r *mux.Router, adminService admin.Service) {
+	handler := AdminHandler{
+		AdminService: adminService,
+	}
+
+	v1 := r.PathPrefix("/admin/users").Subrouter()
+
+	v1.Use(middleware.JwtAuthentication)
+	v1.Use(middleware.TwoFactorAuthentication)
+
+	read := middleware.RequirePermission(rbac.PermissionUsersRead)
+	manage := middleware.RequirePermission(rbac.PermissionUsersManage)
+	remove := middleware.RequirePermission(rbac.PermissionUsersDelete)
+
+	v1.Handle("", handlers.LoggingHandler(os.Stdout, read(http.HandlerFunc(handler.Users)))).Methods(http.MethodGet)
+	v1.Handle("", handlers.LoggingHandler(os.Stdout, manage(http.HandlerFunc(handler.CreateUser)))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, read(http.HandlerFunc(handler.User)))).Methods(http.MethodGet)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, manage(http.HandlerFunc(handler.UpdateUser)))).Methods(http.MethodPut)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, remove(http.HandlerFunc(handler.DeleteUser)))).Methods(http.MethodDelete)
+	v1.Handle("/{id:[0-9]+}/verify", handlers.LoggingHandler(os.Stdout, manage(http.HandlerFunc(handler.VerifyUser)))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}/deactivate", handlers.LoggingHandler(os.Stdout, manage(http.HandlerFunc(handler.DeactivateUser)))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}/reactivate", handlers.LoggingHandler(os.Stdout, manage(http.HandlerFunc(handler.ReactivateUser)))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}/password/reset", handlers.LoggingHandler(os.Stdout, manage(http.HandlerFunc(handler.ForcePasswordReset)))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}/tfa", handlers.LoggingHandler(os.Stdout, manage(http.HandlerFunc(handler.ResetTwoFactorAuthentication)))).Methods(http.MethodDelete)
+}
+
+// parseBoolFilter leaves the filter unset for anything that isn't a bool, so
+// ?verified= lists everyone.
+func parseBoolFilter(value string) *bool {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+func (h *AdminHandler) Users(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := new(models.UserFilter)
+	filter.Query = query.Get("q")
+	filter.Role = strings.TrimSpace(query.Get("role"))
+	filter.IsVerified = parseBoolFilter(query.Get("verified"))
+	filter.IsActive = parseBoolFilter(query.Get("active"))
+	if organizationID, err := strconv.Atoi(query.Get("organization")); err == nil && organizationID > 0 {
+		filter.OrganizationID = uint(organizationID)
+	}
+
+	filter.Page, _ = strconv.Atoi(query.Get("page"))
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+
+	filter.PerPage, _ = strconv.Atoi(query.Get("per_page"))
+	if filter.PerPage < 1 || filter.PerPage > 100 {
+		filter.PerPage = 20
+	}
+
+	response, err := h.AdminService.Users(filter)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *AdminHandler) User(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.AdminService.User(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := strconv.Atoi(r.Header.Get("id"))
+
+	formData := new(models.AdminCreateUserForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := h.AdminService.CreateUser(adminID, formData, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *AdminHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	formData := new(models.AdminUpdateUserForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := h.AdminService.UpdateUser(adminID, id, formData, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *AdminHandler) VerifyUser(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.AdminService.VerifyUser(adminID, id, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *AdminHandler) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.AdminService.DeactivateUser(adminID, id, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *AdminHandler) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.AdminService.ReactivateUser(adminID, id, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *AdminHandler) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.AdminService.ForcePasswordReset(adminID, id, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *AdminHandler) ResetTwoFactorAuthentication(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.AdminService.ResetTwoFactorAuthentication(adminID, id, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.AdminService.DeleteUser(adminID, id, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
@@ -0,0 +1,16 @@
+package admin
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Service interface {
+	Users(filter *models.UserFilter) (map[string]interface{}, error)
+	User(id int) (map[string]interface{}, error)
+	CreateUser(adminID int, form *models.AdminCreateUserForm, meta *models.RequestMetadata) (map[string]interface{}, error)
+	UpdateUser(adminID, id int, form *models.AdminUpdateUserForm, meta *models.RequestMetadata) (map[string]interface{}, error)
+	VerifyUser(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	DeactivateUser(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	ReactivateUser(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	ForcePasswordReset(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	ResetTwoFactorAuthentication(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	DeleteUser(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error)
+}
@@ -0,0 +1,446 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/mailer"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/passwordpolicy"
+	"github.com/ardiantirta/go-user-management/services/admin"
+	"github.com/ardiantirta/go-user-management/services/auth"
+	"github.com/ardiantirta/go-user-management/services/event"
+	"github.com/ardiantirta/go-user-management/services/rbac"
+	"github.com/ardiantirta/go-user-management/services/user"
+)
+
+// AdminService acts on any account on behalf of support staff. Every change
+// lands in the account's own event log along with the id of the admin who
+// made it.
+type AdminService struct {
+	UserRepository  user.Repository
+	AuthRepository  auth.Repository
+	RbacRepository  rbac.Repository
+	EventRepository event.Repository
+}
+
+func userResponse(u *models.User) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":                      u.ID,
+		"email":                   u.Email,
+		"full_name":               u.FullName,
+		"location":                u.Location,
+		"bio":                     u.Bio,
+		"web":                     u.Web,
+		"picture":                 u.Picture,
+		"locale":                  u.Locale,
+		"is_verified":             u.IsVerified == 1,
+		"is_active":               u.IsActive == 1,
+		"is_tfa":                  u.IsTFA == 1,
+		"password_reset_required": u.PasswordResetRequired == 1,
+		"password_compromised":    u.PasswordCompromised == 1,
+		"deactivated_at":          nil,
+		"created_at":              u.CreatedAt.Format(helper.FormatRFC8601),
+		"updated_at":              u.UpdatedAt.Format(helper.FormatRFC8601),
+	}
+
+	if u.DeactivatedAt != nil {
+		response["deactivated_at"] = u.DeactivatedAt.Format(helper.FormatRFC8601)
+	}
+
+	return response
+}
+
+func auditData(adminID int) map[string]interface{} {
+	return map[string]interface{}{"admin_id": adminID}
+}
+
+// fetchTarget refuses to let admins lock themselves out, they have to ask
+// someone else.
+func (s *AdminService) fetchTarget(adminID, id int) (*models.User, error) {
+	if adminID == id {
+		return nil, errors.New("you can't do this to your own account")
+	}
+
+	return s.UserRepository.FetchUserByID(id)
+}
+
+// isLastAdmin is true when removing the user would leave nobody holding the
+// admin role.
+func (s *AdminService) isLastAdmin(id int) (bool, error) {
+	roles, err := s.RbacRepository.FetchUserRoles(id)
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		if role.Name != rbac.RoleAdmin {
+			continue
+		}
+
+		count, err := s.RbacRepository.CountRoleUsers(role.ID)
+		if err != nil {
+			return false, err
+		}
+		return count <= 1, nil
+	}
+
+	return false, nil
+}
+
+func (s *AdminService) Users(filter *models.UserFilter) (map[string]interface{}, error) {
+	users, total, err := s.UserRepository.FetchUsers(filter)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, u := range users {
+		list = append(list, userResponse(u))
+	}
+
+	return map[string]interface{}{
+		"users":    list,
+		"page":     filter.Page,
+		"per_page": filter.PerPage,
+		"total":    total,
+	}, nil
+}
+
+func (s *AdminService) User(id int) (map[string]interface{}, error) {
+	currentUser, err := s.UserRepository.FetchUserByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	roles, err := s.RbacRepository.FetchUserRoles(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	roleNames := make([]string, 0)
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+
+	response := userResponse(currentUser)
+	response["roles"] = roleNames
+
+	return map[string]interface{}{
+		"user": response,
+	}, nil
+}
+
+// CreateUser goes through the same checks as a sign up. Unless the account is
+// created verified, the user gets the usual verification email.
+func (s *AdminService) CreateUser(adminID int, form *models.AdminCreateUserForm, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	newUser, verificationCode, err := s.AuthRepository.Register(&models.RegisterForm{
+		FullName:        form.FullName,
+		Email:           form.Email,
+		Password:        form.Password,
+		PasswordConfirm: form.Password,
+		Locale:          form.Locale,
+	})
+	if err != nil {
+		return passwordpolicy.ErrorMessage(err), err
+	}
+
+	if form.Verified {
+		newUser.IsVerified = 1
+		newUser.IsActive = 1
+		if err := s.UserRepository.SaveUser(newUser); err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	} else if err := mailer.SendTemplate(mailer.Address{Name: newUser.FullName, Email: newUser.Email}, newUser.Locale, mailer.TemplateVerification, map[string]interface{}{
+		"Link": helper.VerificationURL(verificationCode),
+	}); err != nil {
+		// the account exists either way, the email can be resent
+		logrus.WithField("user_id", newUser.ID).Error(err)
+	}
+
+	event.Record(s.EventRepository, int(newUser.ID), event.TypeAccountCreated, meta, auditData(adminID))
+
+	return s.User(int(newUser.ID))
+}
+
+// UpdateUser changes the email right away, unlike the user's own email change
+// it isn't confirmed through the new address.
+func (s *AdminService) UpdateUser(adminID, id int, form *models.AdminUpdateUserForm, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	currentUser, err := s.UserRepository.FetchUserByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	validate := validator.New()
+
+	if err := validate.Var(form.FullName, "required,min=1,max=128"); err != nil {
+		err := errors.New("full_name is required and must between 1 and 128 chars")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(form.Email))
+	if err := validate.Var(email, "required,email,max=128"); err != nil {
+		err := errors.New("email must be a valid email and not longer than 128 chars")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if form.Locale != "" && !helper.IsValidLocale(form.Locale) {
+		err := errors.New("locale must be a language tag like en or pt-BR")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	data := auditData(adminID)
+
+	if email != strings.ToLower(currentUser.Email) {
+		taken, err := s.UserRepository.IsEmailTaken(email)
+		if err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+
+		if taken {
+			err := errors.New("email already exist")
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+
+		// a pending change would overwrite this one once confirmed
+		if err := s.UserRepository.DeleteEmailChanges(id); err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+
+		data["old_email"] = currentUser.Email
+		data["email"] = email
+		currentUser.Email = email
+	}
+
+	currentUser.FullName = form.FullName
+	currentUser.Location = form.Location
+	currentUser.Bio = form.Bio
+	currentUser.Web = form.Web
+	if form.Locale != "" {
+		currentUser.Locale = form.Locale
+	}
+
+	if err := s.UserRepository.SaveUser(currentUser); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, id, event.TypeAccountUpdated, meta, data)
+
+	return s.User(id)
+}
+
+func (s *AdminService) VerifyUser(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	currentUser, err := s.UserRepository.FetchUserByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if currentUser.IsVerified == 1 {
+		err := errors.New("email is already verified")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	currentUser.IsVerified = 1
+	if currentUser.DeactivatedAt == nil {
+		currentUser.IsActive = 1
+	}
+
+	if err := s.UserRepository.SaveUser(currentUser); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, id, event.TypeAccountVerified, meta, auditData(adminID))
+
+	return s.User(id)
+}
+
+// DeactivateUser signs the user out everywhere. Unlike an account that was
+// never verified, the user can't reactivate it through email.
+func (s *AdminService) DeactivateUser(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	currentUser, err := s.fetchTarget(adminID, id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if currentUser.DeactivatedAt != nil {
+		err := errors.New("account is already deactivated")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	lastAdmin, err := s.isLastAdmin(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if lastAdmin {
+		err := errors.New("the last admin can't be deactivated")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	now := time.Now().UTC()
+	currentUser.IsActive = 0
+	currentUser.DeactivatedAt = &now
+
+	if err := s.UserRepository.SaveUser(currentUser); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.UserRepository.DeleteUserToken(id); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, id, event.TypeAccountDeactivated, meta, auditData(adminID))
+
+	return s.User(id)
+}
+
+// ReactivateUser puts the account back the way it was, one that was never
+// verified still has to be.
+func (s *AdminService) ReactivateUser(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	currentUser, err := s.UserRepository.FetchUserByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if currentUser.DeactivatedAt == nil {
+		err := errors.New("account is not deactivated")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	currentUser.DeactivatedAt = nil
+	currentUser.IsActive = currentUser.IsVerified
+
+	if err := s.UserRepository.SaveUser(currentUser); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, id, event.TypeAccountReactivated, meta, auditData(adminID))
+
+	return s.User(id)
+}
+
+// ForcePasswordReset signs the user out and mails a reset link. Logging in
+// with the password is refused until the reset is done.
+func (s *AdminService) ForcePasswordReset(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	currentUser, err := s.fetchTarget(adminID, id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if currentUser.IsVerified != 1 || currentUser.IsActive != 1 {
+		err := errors.New("only active and verified accounts can be sent a password reset")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	currentUser.PasswordResetRequired = 1
+	if err := s.UserRepository.SaveUser(currentUser); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.UserRepository.DeleteUserToken(id); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, id, event.TypePasswordResetRequired, meta, auditData(adminID))
+
+	_, resetToken, token, err := s.AuthRepository.ForgotPassword(currentUser.Email)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := mailer.SendTemplate(mailer.Address{Name: currentUser.FullName, Email: currentUser.Email}, currentUser.Locale, mailer.TemplatePasswordReset, map[string]interface{}{
+		"Token":     token,
+		"ExpiredAt": resetToken.ExpiredAt.Format(helper.FormatRFC8601),
+	}); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+// ResetTwoFactorAuthentication is for users who lost both their authenticator
+// and their backup codes.
+func (s *AdminService) ResetTwoFactorAuthentication(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	currentUser, err := s.UserRepository.FetchUserByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if currentUser.IsTFA != 1 {
+		err := errors.New("two factor authentication is not enabled")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	currentUser.TFAActivation = nil
+	currentUser.IsTFA = 0
+	currentUser.SecretCode = ""
+	currentUser.TFALastStep = 0
+	if err := s.UserRepository.SaveUser(currentUser); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.UserRepository.DeleteBackUpCodes(id); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, id, event.TypeTFAReset, meta, auditData(adminID))
+	mailer.SendSecurityNotice(currentUser, mailer.TemplateTFADisabled, meta)
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func (s *AdminService) DeleteUser(adminID, id int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	currentUser, err := s.fetchTarget(adminID, id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	lastAdmin, err := s.isLastAdmin(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if lastAdmin {
+		err := errors.New("the last admin can't be deleted")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	lastOwner, err := s.UserRepository.IsLastOrganizationOwner(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if lastOwner {
+		err := errors.New("the last owner of an organization can't be deleted, transfer ownership first")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, id, event.TypeAccountDeleted, meta, auditData(adminID))
+
+	if err := s.UserRepository.DeleteUser(currentUser); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func NewAdminService(userRepository user.Repository, authRepository auth.Repository, rbacRepository rbac.Repository, eventRepository event.Repository) admin.Service {
+	return &AdminService{
+		UserRepository:  userRepository,
+		AuthRepository:  authRepository,
+		RbacRepository:  rbacRepository,
+		EventRepository: eventRepository,
+	}
+}
+
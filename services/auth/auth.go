@@ -0,0 +1,7 @@
+package auth
+
+import "errors"
+
+// ErrInvalidCredentials is the only login error that counts as a failed guess,
+// the account status errors come back once the password already matched.
+var ErrInvalidCredentials = errors.New("invalid login credentials, please try again")
@@ -6,12 +6,12 @@ import (
 	"github.com/ardiantirta/go-user-management/middleware"
 	"github.com/ardiantirta/go-user-management/models"
 	"github.com/ardiantirta/go-user-management/services/auth"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type AuthHandler struct {
@@ -23,40 +23,77 @@ func NewAuthHandler(r *mux.Router, authService auth.Service) {
 		AuthService: authService,
 	}
 
+	r.Handle("/.well-known/jwks.json", handlers.LoggingHandler(
+		os.Stdout,
+		http.HandlerFunc(handler.JWKS))).
+		Methods(http.MethodGet)
+
 	v1 := r.PathPrefix("/auth").Subrouter()
 
 	v1.Handle("/register", handlers.LoggingHandler(
 		os.Stdout,
-		middleware.CheckClientID(http.HandlerFunc(handler.Register)))).
+		middleware.CheckClientID(middleware.RateLimit("register")(http.HandlerFunc(handler.Register))))).
 		Methods(http.MethodPost)
 	v1.Handle("/verification/send", handlers.LoggingHandler(
 		os.Stdout,
-		middleware.CheckClientID(http.HandlerFunc(handler.SendVerificationCode)))).
+		middleware.CheckClientID(middleware.RateLimit("verification_send")(middleware.RateLimit("verification_send_recipient")(http.HandlerFunc(handler.SendVerificationCode)))))).
 		Methods(http.MethodPost)
 	v1.Handle("/verification/{code}", handlers.LoggingHandler(
 		os.Stdout,
 		middleware.CheckClientID(http.HandlerFunc(handler.Verification)))).
 		Methods(http.MethodGet)
+	v1.Handle("/invitations/{code}", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.CheckClientID(http.HandlerFunc(handler.Invitation)))).
+		Methods(http.MethodGet)
+	v1.Handle("/unlock/{token}", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.CheckClientID(http.HandlerFunc(handler.Unlock)))).
+		Methods(http.MethodGet)
+	v1.Handle("/email/cancel/{token}", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.CheckClientID(http.HandlerFunc(handler.CancelEmailChange)))).
+		Methods(http.MethodGet)
 	v1.Handle("/login", handlers.LoggingHandler(
 		os.Stdout,
-		middleware.CheckClientID(http.HandlerFunc(handler.Login)))).
+		middleware.CheckClientID(middleware.RateLimit("login")(http.HandlerFunc(handler.Login))))).
 		Methods(http.MethodPost)
 	v1.Handle("/tfa/verify", handlers.LoggingHandler(
 		os.Stdout,
-		middleware.JwtAuthentication(http.HandlerFunc(handler.TwoFactorAuthVerify)))).
+		middleware.JwtAuthenticationAllowExpiredPassword(http.HandlerFunc(handler.TwoFactorAuthVerify)))).
 		Methods(http.MethodPost)
 	v1.Handle("/tfa/bypass", handlers.LoggingHandler(
 		os.Stdout,
-		middleware.JwtAuthentication(http.HandlerFunc(handler.TwoFactorAuthByPass)))).
+		middleware.JwtAuthenticationAllowExpiredPassword(http.HandlerFunc(handler.TwoFactorAuthByPass)))).
+		Methods(http.MethodPost)
+	v1.Handle("/token/refresh", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.CheckClientID(http.HandlerFunc(handler.RefreshToken)))).
 		Methods(http.MethodPost)
 	v1.Handle("/password/forgot", handlers.LoggingHandler(
 		os.Stdout,
-		middleware.CheckClientID(http.HandlerFunc(handler.ForgotPassword)))).
+		middleware.CheckClientID(middleware.RateLimit("password_forgot")(http.HandlerFunc(handler.ForgotPassword))))).
 		Methods(http.MethodPost)
 	v1.Handle("/password/reset", handlers.LoggingHandler(
 		os.Stdout,
 		middleware.CheckClientID(http.HandlerFunc(handler.ResetPassword)))).
 		Methods(http.MethodPost)
+	v1.Handle("/webauthn/login/begin", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.CheckClientID(http.HandlerFunc(handler.WebAuthnLoginBegin)))).
+		Methods(http.MethodPost)
+	v1.Handle("/webauthn/login/finish", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.CheckClientID(http.HandlerFunc(handler.WebAuthnLoginFinish)))).
+		Methods(http.MethodPost)
+	v1.Handle("/webauthn/tfa/begin", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.JwtAuthenticationAllowExpiredPassword(http.HandlerFunc(handler.WebAuthnTFABegin)))).
+		Methods(http.MethodPost)
+	v1.Handle("/webauthn/tfa/finish", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.JwtAuthenticationAllowExpiredPassword(http.HandlerFunc(handler.WebAuthnTFAFinish)))).
+		Methods(http.MethodPost)
 }
 
 func (a *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -68,7 +105,7 @@ func (a *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := a.AuthService.Register(req)
+	response, err := a.AuthService.Register(req, helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -103,11 +140,71 @@ func (a *AuthHandler) SendVerificationCode(w http.ResponseWriter, r *http.Reques
 func (a *AuthHandler) Verification(w http.ResponseWriter, r *http.Request) {
 	code := mux.Vars(r)["code"]
 
+	purpose := r.URL.Query().Get("purpose")
+	if purpose == "" {
+		purpose = helper.VerificationPurposeSignup
+	}
+
+	if !helper.IsVerificationPurpose(purpose) {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "unknown verification purpose"))
+		return
+	}
+
 	params := map[string]interface{}{
 		"verification_code": code,
+		"purpose": purpose,
+	}
+
+	response, err := a.AuthService.Verification(params, helper.RequestMetadata(r))
+	if err != nil {
+		writeErrorStatus(w, response)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+// writeErrorStatus answers lockouts with 429 and Retry-After so clients can
+// tell them apart from a wrong password or code.
+func writeErrorStatus(w http.ResponseWriter, response map[string]interface{}) {
+	if retryAfter, ok := response["retry_after"].(int); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+}
+
+func (a *AuthHandler) Invitation(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+
+	response, err := a.AuthService.Invitation(code)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 	}
 
-	response, err := a.AuthService.Verification(params)
+	helper.Response(w, response)
+	return
+}
+
+func (a *AuthHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	response, err := a.AuthService.Unlock(token, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (a *AuthHandler) CancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	response, err := a.AuthService.CancelEmailChange(token, helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -122,11 +219,12 @@ func (a *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewDecoder(r.Body).Decode(&auth); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
 	}
 
-	response, err := a.AuthService.Login(auth.Email, auth.Password)
+	response, err := a.AuthService.Login(auth.Email, auth.Password, helper.RequestMetadata(r))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeErrorStatus(w, response)
 	}
 
 	helper.Response(w, response)
@@ -155,9 +253,11 @@ func (a *AuthHandler) TwoFactorAuthVerify(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	response, err := a.AuthService.TwoFactorAuthVerify(id, formData.Code)
+	tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
+
+	response, err := a.AuthService.TwoFactorAuthVerify(id, tokenString, formData.Code, helper.RequestMetadata(r))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeErrorStatus(w, response)
 	}
 
 	helper.Response(w, response)
@@ -176,6 +276,7 @@ func (a *AuthHandler) TwoFactorAuthByPass(w http.ResponseWriter, r *http.Request
 	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
 	}
 
 	if len(formData.Code) != 12 {
@@ -184,9 +285,11 @@ func (a *AuthHandler) TwoFactorAuthByPass(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	response, err := a.AuthService.TwoFactorAuthByPass(id, formData.Code)
+	tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
+
+	response, err := a.AuthService.TwoFactorAuthByPass(id, tokenString, formData.Code, helper.RequestMetadata(r))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeErrorStatus(w, response)
 	}
 
 	helper.Response(w, response)
@@ -220,28 +323,148 @@ func (a *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(formData.Password) < 6 || len(formData.Password) > 128 {
+	if formData.Password != formData.PasswordConfirm {
 		w.WriteHeader(http.StatusBadRequest)
-		helper.Response(w, helper.ErrorMessage(0, "password length must between 6 and 128 chars"))
+		helper.Response(w, helper.ErrorMessage(0, "password and password_confirm must be equal"))
 		return
 	}
 
-	if formData.Password != formData.PasswordConfirm {
+	if formData.Token == "" {
 		w.WriteHeader(http.StatusBadRequest)
-		helper.Response(w, helper.ErrorMessage(0, "password and password_confirm must be equal"))
+		helper.Response(w, helper.ErrorMessage(0, "token is required"))
+		return
+	}
+
+	response, err := a.AuthService.ResetPassword(formData.Token, formData.Password, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+
+func (a *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	formData := new(models.RefreshTokenForm)
+
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	if len(formData.RefreshToken) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "refresh_token is required"))
+		return
+	}
+
+	response, err := a.AuthService.RefreshToken(formData.RefreshToken, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (a *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	response, err := a.AuthService.JWKS()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		helper.Response(w, response)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	helper.Response(w, response)
+	return
+}
+
+func (a *AuthHandler) WebAuthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	formData := new(models.WebAuthnLoginForm)
+
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := a.AuthService.WebAuthnLoginBegin(formData.Email)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (a *AuthHandler) WebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
+	formData := new(models.WebAuthnCredentialForm)
+
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	if formData.Type != "public-key" {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "type must be public-key"))
+		return
+	}
+
+	response, err := a.AuthService.WebAuthnLoginFinish(formData, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (a *AuthHandler) WebAuthnTFABegin(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "something is missing, try re-login"))
 		return
 	}
 
-	claims, err := middleware.VerifyToken(formData.Token)
+	response, err := a.AuthService.WebAuthnTFABegin(id)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		helper.Response(w, helper.ErrorMessage(0, "token is invalid"))
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (a *AuthHandler) WebAuthnTFAFinish(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "something is missing, try re-login"))
+		return
+	}
+
+	formData := new(models.WebAuthnCredentialForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	if formData.Type != "public-key" {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "type must be public-key"))
 		return
 	}
 
-	email := claims.(jwt.MapClaims)["email"].(string)
+	tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
 
-	response, err := a.AuthService.ResetPassword(email, formData.Password)
+	response, err := a.AuthService.WebAuthnTFAFinish(id, tokenString, formData, helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -1,17 +1,46 @@
 package auth
 
-import "github.com/ardiantirta/go-user-management/models"
+import (
+	"time"
+
+	"github.com/ardiantirta/go-user-management/models"
+)
 
 type Repository interface {
 	Register(req *models.RegisterForm) (*models.User, *models.UserVerificationCode, error)
-	Verification(params map[string]interface{}) error
-	SendVerificationCode(email string) (*models.User, *models.UserVerificationCode, error)
-	Login(email, password string) (map[string]interface{}, error)
-	ForgotPassword(email string) (*models.User, string,  error)
-	ResetPassword(email, password string) (map[string]interface{}, error)
+	Verification(params map[string]interface{}) (int, error)
+	SendVerificationCode(email, purpose string) (*models.User, *models.UserVerificationCode, error)
+	Login(email, password string, meta *models.RequestMetadata) (map[string]interface{}, error)
+	ForgotPassword(email string) (*models.User, *models.PasswordResetToken, string, error)
+	ResetPassword(token, password string) (*models.User, error)
+	CancelEmailChange(cancelToken string) (*models.EmailChange, error)
+	UpdatePasswordScreening(userID int, compromised bool) error
+	ResetPasswordScreening(corpusUpdatedAt time.Time) (int64, error)
 
 	FetchUserByID(id int) (*models.User, error)
-	FetchUserTokenByUserID(userId int) (*models.UserToken, error)
-	SaveUserToken(token *models.UserToken) error
-	FetchBackUpCodesByUserID(userID int) ([]*models.BackUpCode, error)
+	FetchUserGroupNames(userID int) ([]string, error)
+	FetchUserToken(userID int, token string) (*models.UserToken, error)
+	DeleteUserToken(token *models.UserToken) error
+	ConsumeBackUpCode(userID int, code string) (*models.BackUpCode, error)
+	CountBackUpCodes(userID int) (int, error)
+	ConsumeTFAStep(userID int, step int64) error
+	IssueUserToken(user *models.User, tfaVerified bool, familyID string, meta *models.RequestMetadata) (map[string]interface{}, error)
+	IssueClientToken(user *models.User, familyID, clientID, scope string, withRefresh bool, meta *models.RequestMetadata) (*models.IssuedToken, error)
+	RotateRefreshToken(refreshToken, clientID string) (*models.User, *models.UserToken, error)
+	RevokeTokenFamily(familyID string) error
+	RevokeClientTokens(userID int, clientID string) error
+	IsKnownDevice(userID int, userAgent string) (bool, error)
+
+	FetchAuthFailure(kind, scope, key string) (*models.AuthFailure, error)
+	RegisterAuthFailure(kind, scope, key string, threshold int) (*models.AuthFailure, error)
+	ResetAuthFailures(kind, scope, key string) error
+	SetUnlockToken(id uint, token string, expiredAt time.Time) error
+	FetchAuthFailureByUnlockToken(token string) (*models.AuthFailure, error)
+
+	FetchUserByEmail(email string) (*models.User, error)
+	FetchWebAuthnCredentialsByUserID(userID int) ([]*models.WebAuthnCredential, error)
+	FetchWebAuthnCredentialByCredentialID(credentialID string) (*models.WebAuthnCredential, error)
+	SaveWebAuthnCredential(credential *models.WebAuthnCredential) error
+	CreateWebAuthnSession(session *models.WebAuthnSession) error
+	ConsumeWebAuthnSession(challenge, sessionType string) (*models.WebAuthnSession, error)
 }
@@ -2,18 +2,20 @@ package repository
 
 import (
 	"errors"
-	"fmt"
 	"github.com/ardiantirta/go-user-management/helper"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-playground/validator/v10"
 	"github.com/jinzhu/gorm"
-	"github.com/spf13/viper"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/sirupsen/logrus"
 	"strings"
 	"time"
 
 	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/passwordhash"
+	"github.com/ardiantirta/go-user-management/passwordpolicy"
 	"github.com/ardiantirta/go-user-management/services/auth"
+	"github.com/ardiantirta/go-user-management/services/group"
+	"github.com/ardiantirta/go-user-management/signing"
 )
 
 type AuthRepository struct {
@@ -31,14 +33,14 @@ func (p AuthRepository) Validate(req *models.RegisterForm) (map[string]interface
 		return helper.ErrorMessage(0, "email must be a valid email and not longer than 128 chars"), false
 	}
 
-	if err := validate.Var(req.Password, "required,min=6,max=128"); err != nil {
-		return helper.ErrorMessage(0, "password must between 6 and 128 chars"), false
-	}
-
 	if err := validate.VarWithValue(req.Password, req.PasswordConfirm, "eqfield"); err != nil {
 		return helper.ErrorMessage(0, "password and password_confirm must be equal"), false
 	}
 
+	if req.Locale != "" && !helper.IsValidLocale(req.Locale) {
+		return helper.ErrorMessage(0, "locale must be a language tag like en or pt-BR"), false
+	}
+
 	temp := new(models.User)
 
 	if err := p.Conn.Table("users").
@@ -59,12 +61,27 @@ func (p AuthRepository) Register(req *models.RegisterForm) (*models.User, *model
 		return nil, nil, errors.New(resp["message"].(string))
 	}
 
+	policy := passwordpolicy.Current()
+	if violations := policy.Check(req.Password, passwordpolicy.UserInfo{
+		Email:    req.Email,
+		FullName: req.FullName,
+	}); violations != nil {
+		return nil, nil, violations
+	}
+
 	user := new(models.User)
+	now := time.Now().UTC()
 
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	user.Password = string(hashedPassword)
+	hashedPassword, err := passwordhash.Hash(req.Password)
+	if err != nil {
+		return nil, nil, errors.New("error when create user")
+	}
+	user.Password = hashedPassword
+	user.PasswordChangedAt = &now
+	user.PasswordCheckedAt = policy.ScreenedAt()
 	user.Email = strings.ToLower(req.Email)
 	user.FullName = req.FullName
+	user.Locale = req.Locale
 	user.IsVerified = 0
 	user.IsActive = 0
 	user.IsTFA = 0
@@ -74,129 +91,714 @@ func (p AuthRepository) Register(req *models.RegisterForm) (*models.User, *model
 		return nil, nil, errors.New("error when create user")
 	}
 
-	verificationCode := new(models.UserVerificationCode)
-	verificationCode.UserID = int(user.ID)
-	verificationCode.Code = helper.GenerateRandomCode()
-	verificationCode.IsUsed = 0
+	if err := p.Conn.Create(&models.PasswordHistory{UserID: int(user.ID), Password: user.Password}).Error; err != nil {
+		return nil, nil, errors.New("error when create user")
+	}
 
-	if err := p.Conn.Create(&verificationCode).Error; err != nil {
-		return nil, nil, errors.New("error when create verification code")
+	verificationCode, err := p.createVerificationCode(int(user.ID), helper.VerificationPurposeSignup)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	return user, verificationCode, nil
 }
 
-func (p AuthRepository) Verification(params map[string]interface{}) error {
+func (p AuthRepository) createVerificationCode(userID int, purpose string) (*models.UserVerificationCode, error) {
+	tx := p.Conn.Begin()
+
+	verificationCode, err := helper.CreateVerificationCode(tx, userID, purpose)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("error when create verification code")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, errors.New("error when create verification code")
+	}
+
+	return verificationCode, nil
+}
+
+func (p AuthRepository) Verification(params map[string]interface{}) (int, error) {
 	verificationCode := new(models.UserVerificationCode)
 
 	code := params["verification_code"].(string)
+	purpose := params["purpose"].(string)
 
-	result := p.Conn.Table("user_verification_codes").
+	if err := p.Conn.Table("user_verification_codes").
 		Where("code = ?", code).
-		First(&verificationCode)
+		First(&verificationCode).Error; err != nil {
+		return 0, errors.New("verification code not found")
+	}
+
+	codePurpose := verificationCode.Purpose
+	if codePurpose == "" {
+		codePurpose = helper.VerificationPurposeSignup
+	}
+
+	expiredAt := verificationCode.CreatedAt.Add(helper.VerificationCodeTTL(codePurpose))
+	if verificationCode.ExpiredAt != nil {
+		expiredAt = *verificationCode.ExpiredAt
+	}
 
+	var failure error
+	switch {
+	case verificationCode.IsUsed == 1:
+		failure = errors.New("verification code is already used")
+	case verificationCode.Attempts >= helper.VerificationMaxAttempts():
+		failure = errors.New("too many attempts, request a new verification code")
+	case verificationCode.RevokedAt != nil:
+		failure = errors.New("verification code has been replaced by a newer one")
+	case codePurpose != purpose:
+		failure = errors.New("verification code is not valid for this purpose")
+	case time.Now().After(expiredAt):
+		failure = errors.New("verification code has expired")
+	}
+
+	if failure != nil {
+		if err := p.countVerificationAttempt(verificationCode.ID); err != nil {
+			return 0, err
+		}
+		return 0, failure
+	}
+
+	tx := p.Conn.Begin()
+
+	// claimed with a conditional update so the code can't be spent twice
+	result := tx.Table("user_verification_codes").
+		Where("id = ?", verificationCode.ID).
+		Where("is_used = ?", 0).
+		Where("revoked_at is null").
+		Updates(map[string]interface{}{"is_used": 1, "attempts": gorm.Expr("attempts + 1")})
 	if err := result.Error; err != nil {
-		return errors.New("verification code not found")
+		tx.Rollback()
+		return 0, errors.New("verification failed")
 	}
 
-	if verificationCode.IsUsed == 1 {
-		return errors.New("verification code is already used")
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return 0, errors.New("verification code is already used")
 	}
 
-	if err := result.Update("is_used", 1).Error; err != nil {
+	if codePurpose == helper.VerificationPurposeEmailChange {
+		if err := applyEmailChange(tx, verificationCode); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	} else if err := tx.Table("users").
+		Where("id = ?", verificationCode.UserID).
+		Updates(map[string]interface{}{
+			"is_verified": 1,
+			// accounts an admin deactivated stay inactive
+			"is_active": gorm.Expr("case when deactivated_at is null then 1 else is_active end"),
+		}).Error; err != nil {
+		tx.Rollback()
+		return 0, errors.New("verification failed")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return 0, errors.New("verification failed")
+	}
+
+	return verificationCode.UserID, nil
+}
+
+// countVerificationAttempt records a failed use of a code and revokes it once
+// verification.max_attempts is reached, in one statement so concurrent
+// guesses can't slip past the limit.
+func (p AuthRepository) countVerificationAttempt(id uint) error {
+	if err := p.Conn.Table("user_verification_codes").
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"revoked_at": gorm.Expr("case when attempts + 1 >= ? then coalesce(revoked_at, ?) else revoked_at end", helper.VerificationMaxAttempts(), time.Now().UTC()),
+		}).Error; err != nil {
 		return errors.New("verification failed")
 	}
 
-	if err := p.Conn.Table("users").
-		Where("id = ?", verificationCode.UserID).
-		Updates(map[string]interface{}{"is_verified": 1, "is_active": 1}).Error; err != nil {
+	return nil
+}
+
+// applyEmailChange swaps in the new address, uniqueness is checked again since
+// another account may have taken it while the change was pending.
+func applyEmailChange(tx *gorm.DB, verificationCode *models.UserVerificationCode) error {
+	emailChange := new(models.EmailChange)
+
+	if err := tx.Table("email_changes").
+		Where("verification_code_id = ?", verificationCode.ID).
+		Where("confirmed_at is null").
+		Where("cancelled_at is null").
+		First(&emailChange).Error; err != nil {
+		return errors.New("email change not found or cancelled")
+	}
+
+	count := 0
+	if err := tx.Table("users").
+		Where("lower(email) = ?", strings.ToLower(emailChange.NewEmail)).
+		Where("id <> ?", emailChange.UserID).
+		Count(&count).Error; err != nil {
+		return errors.New("verification failed")
+	}
+
+	if count > 0 {
+		return errors.New("email already exist")
+	}
+
+	if err := tx.Table("users").
+		Where("id = ?", emailChange.UserID).
+		Updates(map[string]interface{}{"email": emailChange.NewEmail, "is_verified": 1}).Error; err != nil {
+		return errors.New("verification failed")
+	}
+
+	if err := tx.Table("email_changes").
+		Where("id = ?", emailChange.ID).
+		Update("confirmed_at", time.Now().UTC()).Error; err != nil {
 		return errors.New("verification failed")
 	}
 
 	return nil
 }
 
-func (p AuthRepository) SendVerificationCode(email string) (*models.User, *models.UserVerificationCode, error) {
+// CancelEmailChange is reached from the link sent to the old address, it
+// revokes the code sent to the new one as well.
+func (p AuthRepository) CancelEmailChange(cancelToken string) (*models.EmailChange, error) {
+	emailChange := new(models.EmailChange)
+
+	if err := p.Conn.Table("email_changes").
+		Where("cancel_token = ?", helper.HashToken(cancelToken)).
+		First(&emailChange).Error; err != nil {
+		return nil, errors.New("cancel link is invalid")
+	}
+
+	if emailChange.ConfirmedAt != nil {
+		return nil, errors.New("email change is already confirmed")
+	}
+
+	if emailChange.CancelledAt != nil {
+		return nil, errors.New("email change is already cancelled")
+	}
+
+	now := time.Now().UTC()
+	tx := p.Conn.Begin()
+
+	if err := tx.Table("email_changes").
+		Where("id = ?", emailChange.ID).
+		Update("cancelled_at", now).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("failed to cancel email change")
+	}
+
+	if err := tx.Table("user_verification_codes").
+		Where("id = ?", emailChange.VerificationCodeID).
+		Where("revoked_at is null").
+		Update("revoked_at", now).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("failed to cancel email change")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, errors.New("failed to cancel email change")
+	}
+
+	emailChange.CancelledAt = &now
+	return emailChange, nil
+}
+
+func (p AuthRepository) SendVerificationCode(email, purpose string) (*models.User, *models.UserVerificationCode, error) {
 	user := new(models.User)
 
 	if err := p.Conn.Table("users").
-		Where("lower(email) = ?", email).
+		Where("lower(email) = ?", strings.ToLower(email)).
 		First(&user).Error; err != nil {
-		return nil, nil, errors.New("")
+		return nil, nil, errors.New("user not found")
 	}
 
-	verificationCode := new(models.UserVerificationCode)
-	verificationCode.UserID = int(user.ID)
-	verificationCode.Code = helper.GenerateRandomCode()
-	verificationCode.IsUsed = 0
+	switch purpose {
+	case helper.VerificationPurposeSignup:
+		if user.IsVerified == 1 {
+			return nil, nil, errors.New("email is already verified")
+		}
+	case helper.VerificationPurposeReactivation:
+		if user.IsActive == 1 {
+			return nil, nil, errors.New("account is already active")
+		}
+		if user.DeactivatedAt != nil {
+			return nil, nil, errors.New("account was deactivated by an administrator")
+		}
+	default:
+		return nil, nil, errors.New("verification code can't be sent for this purpose")
+	}
 
-	if err := p.Conn.Create(&verificationCode).Error; err != nil {
-		return nil, nil, errors.New("error when create verification code")
+	verificationCode, err := p.createVerificationCode(int(user.ID), purpose)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	return user, verificationCode, nil
 }
 
-func (p AuthRepository) Login(email, password string) (map[string]interface{}, error) {
+func (p AuthRepository) Login(email, password string, meta *models.RequestMetadata) (map[string]interface{}, error) {
 	user := new(models.User)
 
 	result := p.Conn.Table("users").
-		Where("lower(email) = ? ", email).
+		Where("lower(email) = ? ", strings.ToLower(email)).
 		First(&user)
 	if err := result.Error; err != nil {
 		return nil, errors.New("user not found")
 	}
 
+	// the password comes first so the account status isn't told to someone
+	// who doesn't know it
+	if ok, err := passwordhash.Verify(user.Password, password); !ok {
+		if err != nil {
+			logrus.WithField("user_id", user.ID).Error(err)
+		}
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	if user.DeactivatedAt != nil {
+		return nil, errors.New("account is deactivated")
+	}
+
 	if err := result.Where("is_active = ?", 1).First(&user).Error; err != nil {
 		return nil, errors.New("please verify your email")
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		return nil, errors.New("invalid login credentials, please try again")
+	if user.PasswordResetRequired == 1 {
+		return nil, errors.New("password reset is required, check your email for the reset link")
+	}
+
+	// the password is only ever known here, so this is where hashes made with
+	// older settings get upgraded
+	if passwordhash.NeedsRehash(user.Password) {
+		p.rehashPassword(user, password)
+	}
+
+	response, err := p.IssueUserToken(user, false, "", meta)
+	if err != nil {
+		return nil, err
+	}
+
+	response["require_tfa"] = user.IsTFA == 1
+
+	return response, nil
+}
+
+// fetchUserGroups returns the ids and names of the groups the user belongs
+// to, either directly or through a subgroup.
+func (p AuthRepository) fetchUserGroups(userID int) ([]uint, []string, error) {
+	direct := make([]uint, 0)
+	if err := p.Conn.Table("group_members").
+		Joins("join groups on groups.id = group_members.group_id").
+		Where("group_members.user_id = ?", userID).
+		Where("groups.deleted_at is null").
+		Pluck("group_members.group_id", &direct).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if len(direct) == 0 {
+		return []uint{}, []string{}, nil
+	}
+
+	groups := make([]*models.Group, 0)
+	if err := p.Conn.Table("groups").
+		Where("deleted_at is null").
+		Find(&groups).Error; err != nil {
+		return nil, nil, err
+	}
+
+	ids := group.Expand(groups, direct)
+	return ids, group.Names(groups, ids), nil
+}
+
+func (p AuthRepository) FetchUserGroupNames(userID int) ([]string, error) {
+	_, names, err := p.fetchUserGroups(userID)
+	if err != nil {
+		return nil, errors.New("failed to get user groups")
+	}
+
+	return names, nil
+}
+
+// fetchUserAccess returns the names of the user's roles, the ones assigned
+// directly and the ones granted to their groups, of every permission those
+// roles grant and of the groups.
+func (p AuthRepository) fetchUserAccess(userID int) ([]string, []string, []string, error) {
+	groupIDs, groups, err := p.fetchUserGroups(userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	roleIDs := make([]uint, 0)
+	if err := p.Conn.Table("user_roles").
+		Where("user_id = ?", userID).
+		Pluck("role_id", &roleIDs).Error; err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(groupIDs) > 0 {
+		groupRoleIDs := make([]uint, 0)
+		if err := p.Conn.Table("group_roles").
+			Where("group_id in (?)", groupIDs).
+			Pluck("role_id", &groupRoleIDs).Error; err != nil {
+			return nil, nil, nil, err
+		}
+		roleIDs = append(roleIDs, groupRoleIDs...)
+	}
+
+	roles := make([]string, 0)
+	permissions := make([]string, 0)
+	if len(roleIDs) == 0 {
+		return roles, permissions, groups, nil
+	}
+
+	if err := p.Conn.Table("roles").
+		Where("id in (?)", roleIDs).
+		Where("deleted_at is null").
+		Order("name").
+		Pluck("distinct name", &roles).Error; err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := p.Conn.Table("permissions").
+		Joins("join role_permissions on role_permissions.permission_id = permissions.id").
+		Joins("join roles on roles.id = role_permissions.role_id").
+		Where("role_permissions.role_id in (?)", roleIDs).
+		Where("roles.deleted_at is null").
+		Where("permissions.deleted_at is null").
+		Order("permissions.name").
+		Pluck("distinct permissions.name", &permissions).Error; err != nil {
+		return nil, nil, nil, err
+	}
+
+	return roles, permissions, groups, nil
+}
+
+// fetchMembership returns nil when the user isn't, or is no longer, a member
+// of the organization.
+func (p AuthRepository) fetchMembership(userID int, organizationID uint) (*models.OrganizationMember, error) {
+	if organizationID == 0 {
+		return nil, nil
+	}
+
+	member := new(models.OrganizationMember)
+	err := p.Conn.Table("organization_members").
+		Select("organization_members.*").
+		Joins("join organizations on organizations.id = organization_members.organization_id").
+		Where("organization_members.organization_id = ?", organizationID).
+		Where("organization_members.user_id = ?", userID).
+		Where("organizations.deleted_at is null").
+		First(&member).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// rehashPassword only logs on failure, the old hash still works and the
+// upgrade is retried on the next login.
+func (p AuthRepository) rehashPassword(user *models.User, password string) {
+	hashedPassword, err := passwordhash.Hash(password)
+	if err != nil {
+		logrus.WithField("user_id", user.ID).Error(err)
+		return
+	}
+
+	if err := p.Conn.Table("users").
+		Where("id = ?", user.ID).
+		Where("password = ?", user.Password).
+		Update("password", hashedPassword).Error; err != nil {
+		logrus.WithField("user_id", user.ID).Error(err)
+		return
+	}
+
+	user.Password = hashedPassword
+}
+
+func (p AuthRepository) IssueUserToken(user *models.User, tfaVerified bool, familyID string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	// a refresh token is only handed out once the session passed every
+	// required factor, so refreshing can never skip two factor authentication.
+	// Sessions with an expired password can only change it, and have to log
+	// in again afterwards.
+	passwordExpired := passwordpolicy.Current().UserExpired(user)
+	withRefresh := (user.IsTFA != 1 || tfaVerified) && !passwordExpired
+
+	issued, err := p.issueTokens(user, tfaVerified, familyID, "", "", withRefresh, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	response := map[string]interface{}{
+		"access_token": map[string]interface{}{
+			"value":      issued.AccessToken,
+			"type":       helper.TokenTypeBearer,
+			"expired_at": issued.AccessTokenExpiredAt.Format(helper.FormatRFC8601),
+		},
+	}
+
+	if withRefresh {
+		response["refresh_token"] = map[string]interface{}{
+			"value":      issued.RefreshToken,
+			"expired_at": issued.RefreshTokenExpiredAt.Format(helper.FormatRFC8601),
+		}
+	}
+
+	if passwordExpired {
+		response["password_expired"] = true
+	}
+
+	return response, nil
+}
 
+func (p AuthRepository) IssueClientToken(user *models.User, familyID, clientID, scope string, withRefresh bool, meta *models.RequestMetadata) (*models.IssuedToken, error) {
+	return p.issueTokens(user, true, familyID, clientID, scope, withRefresh, meta)
+}
+
+func containsScope(scope, value string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (p AuthRepository) issueTokens(user *models.User, tfaVerified bool, familyID, clientID, scope string, withRefresh bool, meta *models.RequestMetadata) (*models.IssuedToken, error) {
 	isTfa := false
 	if user.IsTFA == 1 {
 		isTfa = true
 	}
 
-	expiredAt := time.Now().UTC().AddDate(0, 0, 7)
-	signKey := []byte(viper.GetString("jwt.signkey"))
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, models.CustomClaims{
-		ID:    int(user.ID),
-		Email: user.Email,
-		IsTFA: isTfa,
-		Code: "",
+	if familyID == "" {
+		familyID = helper.GenerateRandomCode()
+	}
+
+	createdAt := time.Now().UTC()
+	expiredAt := createdAt.Add(helper.AccessTokenTTL())
+
+	if meta == nil {
+		meta = new(models.RequestMetadata)
+	}
+
+	startedAt := createdAt
+	if meta.StartedAt != nil {
+		startedAt = *meta.StartedAt
+	}
+
+	// third party clients get scopes, roles, permissions and the organization
+	// are for this api. Groups are also handed to clients granted the groups
+	// scope, downstream services make their own decisions with them.
+	var roles, permissions, groups []string
+	var member *models.OrganizationMember
+	if clientID == "" {
+		var err error
+		if roles, permissions, groups, err = p.fetchUserAccess(int(user.ID)); err != nil {
+			return nil, errors.New("create token failed")
+		}
+
+		organizationID := meta.OrganizationID
+		if organizationID == 0 {
+			organizationID = user.OrganizationID
+		}
+		if member, err = p.fetchMembership(int(user.ID), organizationID); err != nil {
+			return nil, errors.New("create token failed")
+		}
+	} else if containsScope(scope, group.Scope) {
+		var err error
+		if _, groups, err = p.fetchUserGroups(int(user.ID)); err != nil {
+			return nil, errors.New("create token failed")
+		}
+	}
+
+	claims := models.CustomClaims{
+		ID:          int(user.ID),
+		Email:       user.Email,
+		IsTFA:       isTfa,
+		TFAVerified: tfaVerified,
+		Scope:       scope,
+		Roles:       roles,
+		Permissions: permissions,
+		Groups:      groups,
 		StandardClaims: jwt.StandardClaims{
+			Id:        helper.GenerateRandomCode(),
+			Audience:  clientID,
+			IssuedAt:  createdAt.Unix(),
 			ExpiresAt: expiredAt.Unix(),
 		},
-	})
+	}
+	if member != nil {
+		claims.Organization = member.OrganizationID
+		claims.OrganizationRole = member.Role
+	}
+	if clientID == "" {
+		claims.PasswordExpired = passwordpolicy.Current().UserExpired(user)
+	}
 
-	tokenString, _ := token.SignedString(signKey)
+	browser, os, device := helper.ParseUserAgent(meta.UserAgent)
+	session := models.UserToken{
+		UserID:           int(user.ID),
+		FamilyID:         familyID,
+		ClientID:         clientID,
+		Scope:            scope,
+		UserAgent:        meta.UserAgent,
+		Browser:          browser,
+		OS:               os,
+		Device:           device,
+		IPAddress:        meta.IPAddress,
+		SessionStartedAt: &startedAt,
+		LastSeenAt:       &createdAt,
+		OrganizationID:   claims.Organization,
+	}
+	tokenString, err := signing.Sign(claims)
+	if err != nil {
+		return nil, errors.New("create token failed")
+	}
 
-	userToken := new(models.UserToken)
-	userToken.Type = "Bearer"
+	userToken := session
+	userToken.Type = helper.TokenTypeBearer
 	userToken.Token = tokenString
-	userToken.UserID = int(user.ID)
-	if err := p.Conn.Table("user_tokens").
-		Where("user_id = ? and token = ?", userToken.UserID, userToken.Token).
-		FirstOrCreate(&userToken).Error; err != nil {
+	userToken.ExpiredAt = &expiredAt
+	if err := p.Conn.Create(&userToken).Error; err != nil {
 		return nil, errors.New("create token failed")
 	}
 
-	strExpiredAt := expiredAt.Format(helper.FormatRFC8601)
+	issued := new(models.IssuedToken)
+	issued.FamilyID = familyID
+	issued.AccessToken = tokenString
+	issued.AccessTokenExpiredAt = expiredAt
 
-	return map[string]interface{}{
-		"require_tfa": isTfa,
-		"access_token": map[string]interface{}{
-			"value":      userToken.Token,
-			"type":       userToken.Type,
-			"expired_at": strExpiredAt,
-		},
-	}, nil
+	if !withRefresh {
+		return issued, nil
+	}
+
+	refreshToken, err := helper.GenerateOpaqueToken()
+	if err != nil {
+		return nil, errors.New("create token failed")
+	}
+
+	refreshExpiredAt := createdAt.Add(helper.RefreshTokenTTL())
+
+	userRefreshToken := session
+	userRefreshToken.Type = helper.TokenTypeRefresh
+	userRefreshToken.Token = helper.HashToken(refreshToken)
+	userRefreshToken.ExpiredAt = &refreshExpiredAt
+	if err := p.Conn.Create(&userRefreshToken).Error; err != nil {
+		return nil, errors.New("create token failed")
+	}
+
+	issued.RefreshToken = refreshToken
+	issued.RefreshTokenExpiredAt = refreshExpiredAt
+
+	return issued, nil
+}
+
+func (p AuthRepository) RotateRefreshToken(refreshToken, clientID string) (*models.User, *models.UserToken, error) {
+	userToken := new(models.UserToken)
+
+	if err := p.Conn.Table("user_tokens").
+		Where("token = ?", helper.HashToken(refreshToken)).
+		Where("type = ?", helper.TokenTypeRefresh).
+		First(&userToken).Error; err != nil {
+		return nil, nil, errors.New("invalid refresh token")
+	}
+
+	if userToken.ClientID != clientID {
+		return nil, nil, errors.New("invalid refresh token")
+	}
+
+	now := time.Now().UTC()
+
+	if userToken.RotatedAt != nil {
+		p.reuseDetected(userToken)
+		return nil, nil, errors.New("refresh token was already used, please login again")
+	}
+
+	if userToken.ExpiredAt == nil || userToken.ExpiredAt.Before(now) {
+		return nil, nil, errors.New("refresh token expired, please login again")
+	}
+
+	result := p.Conn.Table("user_tokens").
+		Where("id = ?", userToken.ID).
+		Where("rotated_at is null").
+		Update("rotated_at", now)
+	if err := result.Error; err != nil {
+		return nil, nil, errors.New("refresh token failed")
+	}
+
+	if result.RowsAffected == 0 {
+		p.reuseDetected(userToken)
+		return nil, nil, errors.New("refresh token was already used, please login again")
+	}
+
+	if err := p.Conn.Unscoped().Table("user_tokens").
+		Where("family_id = ?", userToken.FamilyID).
+		Where("type = ?", helper.TokenTypeBearer).
+		Delete(models.UserToken{}).Error; err != nil {
+		return nil, nil, errors.New("refresh token failed")
+	}
+
+	user, err := p.FetchUserByID(userToken.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, userToken, nil
+}
+
+func (p AuthRepository) reuseDetected(userToken *models.UserToken) {
+	logrus.WithFields(logrus.Fields{
+		"user_id":   userToken.UserID,
+		"family_id": userToken.FamilyID,
+	}).Warn("refresh token reuse detected, revoking token family")
+
+	if err := p.RevokeTokenFamily(userToken.FamilyID); err != nil {
+		logrus.Error(err)
+	}
+}
+
+func (p AuthRepository) RevokeClientTokens(userID int, clientID string) error {
+	if err := p.Conn.Unscoped().Table("user_tokens").
+		Where("user_id = ?", userID).
+		Where("client_id = ?", clientID).
+		Delete(models.UserToken{}).Error; err != nil {
+		return errors.New("failed to revoke tokens")
+	}
+
+	return nil
 }
 
-func (p AuthRepository) ForgotPassword(email string) (*models.User, string, error) {
+// IsKnownDevice reports whether the user has a live session from the same user
+// agent.
+func (p AuthRepository) IsKnownDevice(userID int, userAgent string) (bool, error) {
+	count := 0
+
+	if err := p.Conn.Table("user_tokens").
+		Where("user_id = ?", userID).
+		Where("user_agent = ?", userAgent).
+		Count(&count).Error; err != nil {
+		return false, errors.New("get sessions failed")
+	}
+
+	return count > 0, nil
+}
+
+func (p AuthRepository) RevokeTokenFamily(familyID string) error {
+	if err := p.Conn.Unscoped().Table("user_tokens").
+		Where("family_id = ?", familyID).
+		Delete(models.UserToken{}).Error; err != nil {
+		return errors.New("failed to revoke tokens")
+	}
+
+	return nil
+}
+
+// ForgotPassword replaces any reset token still outstanding for the user, only
+// the latest email sent works.
+func (p AuthRepository) ForgotPassword(email string) (*models.User, *models.PasswordResetToken, string, error) {
 	user := new(models.User)
 
 	if err := p.Conn.Table("users").
@@ -204,51 +806,180 @@ func (p AuthRepository) ForgotPassword(email string) (*models.User, string, erro
 		Where("is_verified = ?", 1).
 		Where("is_active = ?", 1).
 		First(&user).Error; err != nil {
-		return nil, "", errors.New("user not found")
+		return nil, nil, "", errors.New("user not found")
 	}
 
-	isTfa := false
-	if user.IsTFA == 1 {
-		isTfa = true
+	token, err := helper.GenerateOpaqueToken()
+	if err != nil {
+		return nil, nil, "", errors.New("create token failed")
 	}
 
-	expiredAt := time.Now().UTC().AddDate(0, 0, 7)
-	signKey := []byte(viper.GetString("jwt.signkey"))
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, models.CustomClaims{
-		ID:    int(user.ID),
-		Email: user.Email,
-		IsTFA: isTfa,
-		Code: "",
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expiredAt.Unix(),
-		},
-	})
+	resetToken := new(models.PasswordResetToken)
+	resetToken.UserID = int(user.ID)
+	resetToken.TokenHash = helper.HashToken(token)
+	resetToken.ExpiredAt = time.Now().UTC().Add(helper.PasswordResetTokenTTL())
+
+	tx := p.Conn.Begin()
 
-	tokenString, _ := token.SignedString(signKey)
+	if err := tx.Unscoped().Table("password_reset_tokens").
+		Where("user_id = ?", user.ID).
+		Where("used_at is null").
+		Delete(models.PasswordResetToken{}).Error; err != nil {
+		tx.Rollback()
+		return nil, nil, "", errors.New("create token failed")
+	}
+
+	if err := tx.Create(&resetToken).Error; err != nil {
+		tx.Rollback()
+		return nil, nil, "", errors.New("create token failed")
+	}
 
-	return user, tokenString, nil
+	if err := tx.Commit().Error; err != nil {
+		return nil, nil, "", errors.New("create token failed")
+	}
+
+	return user, resetToken, token, nil
 }
 
-func (p AuthRepository) ResetPassword(email, password string) (map[string]interface{}, error) {
+// ResetPassword spends the token and signs the user out everywhere, whoever
+// knew the old password may still hold a session.
+func (p AuthRepository) ResetPassword(token, password string) (*models.User, error) {
+	now := time.Now().UTC()
+	resetToken := new(models.PasswordResetToken)
+
+	tx := p.Conn.Begin()
+
+	if err := tx.Raw(`
+		update password_reset_tokens set used_at = ?, updated_at = ?
+		where token_hash = ? and used_at is null and expired_at > ? and deleted_at is null
+		returning *`,
+		now, now, helper.HashToken(token), now).
+		Scan(resetToken).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("reset token is invalid or expired")
+	}
+
 	user := new(models.User)
+	if err := tx.Table("users").
+		Where("id = ?", resetToken.UserID).
+		First(&user).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("user not found")
+	}
 
-	fmt.Println(email, password)
+	// rolling back leaves the token unspent so the user can try another
+	// password
+	policy := passwordpolicy.Current()
+	if violations := policy.Check(password, passwordpolicy.UserInfo{Email: user.Email, FullName: user.FullName}); violations != nil {
+		tx.Rollback()
+		return nil, violations
+	}
 
-	result := p.Conn.Table("users").
-		Where("lower(email) = ?", strings.ToLower(email)).
-		First(&user)
+	history, err := fetchPasswordHistory(tx, user, policy.History)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("reset password failed")
+	}
 
-	if err := result.Error; err != nil {
-		return nil, errors.New("user not found")
+	if violations := policy.CheckHistory(password, history); violations != nil {
+		tx.Rollback()
+		return nil, violations
+	}
+
+	hashedPassword, err := passwordhash.Hash(password)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("reset password failed")
+	}
+
+	if err := tx.Table("users").
+		Where("id = ?", user.ID).
+		Updates(map[string]interface{}{
+			"password":             hashedPassword,
+			"password_changed_at":  now,
+			"password_compromised":    0,
+			"password_checked_at":     policy.ScreenedAt(),
+			"password_reset_required": 0,
+		}).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("reset password failed")
+	}
+
+	if err := tx.Create(&models.PasswordHistory{UserID: int(user.ID), Password: hashedPassword}).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("reset password failed")
 	}
 
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := tx.Unscoped().Table("user_tokens").
+		Where("user_id = ?", user.ID).
+		Delete(models.UserToken{}).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("reset password failed")
+	}
 
-	if err := result.Update("password", hashedPassword).Error; err != nil {
+	if err := tx.Commit().Error; err != nil {
 		return nil, errors.New("reset password failed")
 	}
 
-	return map[string]interface{}{"status": true}, nil
+	return user, nil
+}
+
+func (p AuthRepository) UpdatePasswordScreening(userID int, compromised bool) error {
+	isCompromised := 0
+	if compromised {
+		isCompromised = 1
+	}
+
+	if err := p.Conn.Table("users").
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"password_compromised": isCompromised,
+			"password_checked_at":  time.Now().UTC(),
+		}).Error; err != nil {
+		return errors.New("failed to update password screening")
+	}
+
+	return nil
+}
+
+// ResetPasswordScreening queues every password screened before the corpus was
+// last updated to be screened again on its owner's next login.
+func (p AuthRepository) ResetPasswordScreening(corpusUpdatedAt time.Time) (int64, error) {
+	result := p.Conn.Table("users").
+		Where("password_checked_at < ?", corpusUpdatedAt).
+		Update("password_checked_at", nil)
+	if err := result.Error; err != nil {
+		return 0, errors.New("failed to reset password screening")
+	}
+
+	return result.RowsAffected, nil
+}
+
+// fetchPasswordHistory returns the current hash followed by the previous
+// ones, newest first. The current hash is included so accounts created before
+// the history table existed are covered too.
+func fetchPasswordHistory(conn *gorm.DB, user *models.User, limit int) ([]string, error) {
+	hashes := []string{user.Password}
+	if limit <= 0 {
+		return hashes, nil
+	}
+
+	histories := make([]*models.PasswordHistory, 0)
+	if err := conn.Table("password_histories").
+		Where("user_id = ?", user.ID).
+		Order("id desc").
+		Limit(limit).
+		Find(&histories).Error; err != nil {
+		return nil, err
+	}
+
+	for _, history := range histories {
+		if history.Password != user.Password {
+			hashes = append(hashes, history.Password)
+		}
+	}
+
+	return hashes, nil
 }
 
 func (p AuthRepository) FetchUserByID(id int) (*models.User, error) {
@@ -263,38 +994,232 @@ func (p AuthRepository) FetchUserByID(id int) (*models.User, error) {
 	return user, nil
 }
 
-func (p AuthRepository) FetchUserTokenByUserID(userId int) (*models.UserToken, error) {
-	token := new(models.UserToken)
+func (p AuthRepository) FetchUserToken(userID int, token string) (*models.UserToken, error) {
+	userToken := new(models.UserToken)
 
 	if err := p.Conn.Table("user_tokens").
-		Where("user_id = ?", userId).
-		First(&token).Error; err != nil {
+		Where("user_id = ?", userID).
+		Where("token = ?", token).
+		Where("type = ?", helper.TokenTypeBearer).
+		First(&userToken).Error; err != nil {
 			return nil, errors.New("get token failed")
 	}
 
-	return token, nil
+	return userToken, nil
 }
 
-func (p AuthRepository) SaveUserToken(token *models.UserToken) error {
-	if err := p.Conn.Table("user_tokens").
-		Where("user_id = ?", token.UserID).
-		Save(&token).Error; err != nil {
-			return errors.New("save token failed")
+func (p AuthRepository) DeleteUserToken(token *models.UserToken) error {
+	if err := p.Conn.Unscoped().Delete(&token).Error; err != nil {
+		return errors.New("delete token failed")
 	}
 
 	return nil
 }
 
-func (p AuthRepository) FetchBackUpCodesByUserID(userID int) ([]*models.BackUpCode, error) {
-	codes := make([]*models.BackUpCode, 0)
+// ConsumeBackUpCode marks the code used in the same statement that finds it,
+// so two requests racing with the same code can't both pass. Codes stored
+// before hashing are shorter than a sha256 and compared as typed.
+func (p AuthRepository) ConsumeBackUpCode(userID int, code string) (*models.BackUpCode, error) {
+	now := time.Now().UTC()
+	backUpCode := new(models.BackUpCode)
+
+	if err := p.Conn.Raw(`
+		update back_up_codes set used_at = ?, updated_at = ?
+		where id = (
+			select id from back_up_codes
+			where user_id = ? and used_at is null and deleted_at is null
+			and (code = ? or (length(code) < 64 and code = ?))
+			limit 1
+		) and used_at is null
+		returning *`,
+		now, now, userID, helper.HashBackupCode(code), strings.TrimSpace(code)).
+		Scan(backUpCode).Error; err != nil {
+		return nil, errors.New("code not found")
+	}
+
+	return backUpCode, nil
+}
+
+func (p AuthRepository) CountBackUpCodes(userID int) (int, error) {
+	count := 0
 
 	if err := p.Conn.Table("back_up_codes").
 		Where("user_id = ?", userID).
-		Find(&codes).Error; err != nil {
-			return nil, errors.New("code not found")
+		Where("used_at is null").
+		Count(&count).Error; err != nil {
+		return 0, errors.New("failed to count backup codes")
+	}
+
+	return count, nil
+}
+
+func (p AuthRepository) ConsumeTFAStep(userID int, step int64) error {
+	result := p.Conn.Table("users").
+		Where("id = ?", userID).
+		Where("coalesce(tfa_last_step, 0) < ?", step).
+		Update("tfa_last_step", step)
+	if err := result.Error; err != nil {
+		return errors.New("verify code failed")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("code already used, wait for the next one")
+	}
+
+	return nil
+}
+
+func (p AuthRepository) FetchUserByEmail(email string) (*models.User, error) {
+	user := new(models.User)
+
+	if err := p.Conn.Table("users").
+		Where("lower(email) = ?", strings.ToLower(email)).
+		First(&user).Error; err != nil {
+			return nil, errors.New("user not found")
+	}
+
+	return user, nil
+}
+
+func (p AuthRepository) FetchWebAuthnCredentialsByUserID(userID int) ([]*models.WebAuthnCredential, error) {
+	credentials := make([]*models.WebAuthnCredential, 0)
+
+	if err := p.Conn.Table("web_authn_credentials").
+		Where("user_id = ?", userID).
+		Find(&credentials).Error; err != nil {
+			return nil, errors.New("failed to get webauthn credentials")
+	}
+
+	return credentials, nil
+}
+
+func (p AuthRepository) FetchWebAuthnCredentialByCredentialID(credentialID string) (*models.WebAuthnCredential, error) {
+	credential := new(models.WebAuthnCredential)
+
+	if err := p.Conn.Table("web_authn_credentials").
+		Where("credential_id = ?", credentialID).
+		First(&credential).Error; err != nil {
+			return nil, errors.New("webauthn credential not found")
+	}
+
+	return credential, nil
+}
+
+func (p AuthRepository) SaveWebAuthnCredential(credential *models.WebAuthnCredential) error {
+	if err := p.Conn.Save(&credential).Error; err != nil {
+		return errors.New("failed to save webauthn credential")
+	}
+
+	return nil
+}
+
+func (p AuthRepository) CreateWebAuthnSession(session *models.WebAuthnSession) error {
+	if err := p.Conn.Create(&session).Error; err != nil {
+		return errors.New("failed to create webauthn session")
+	}
+
+	return nil
+}
+
+func (p AuthRepository) ConsumeWebAuthnSession(challenge, sessionType string) (*models.WebAuthnSession, error) {
+	session := new(models.WebAuthnSession)
+
+	if err := p.Conn.Table("web_authn_sessions").
+		Where("challenge = ?", challenge).
+		Where("type = ?", sessionType).
+		First(&session).Error; err != nil {
+			return nil, errors.New("webauthn session not found")
+	}
+
+	if result := p.Conn.Unscoped().Delete(&session); result.Error != nil || result.RowsAffected == 0 {
+		return nil, errors.New("webauthn session not found")
+	}
+
+	if session.ExpiredAt.Before(time.Now().UTC()) {
+		return nil, errors.New("webauthn session expired")
+	}
+
+	return session, nil
+}
+
+func (p AuthRepository) FetchAuthFailure(kind, scope, key string) (*models.AuthFailure, error) {
+	authFailure := new(models.AuthFailure)
+
+	if err := p.Conn.Table("auth_failures").
+		Where("kind = ?", kind).
+		Where("scope = ?", scope).
+		Where("key = ?", key).
+		First(&authFailure).Error; err != nil {
+		return nil, errors.New("auth failure not found")
+	}
+
+	return authFailure, nil
+}
+
+func (p AuthRepository) RegisterAuthFailure(kind, scope, key string, threshold int) (*models.AuthFailure, error) {
+	now := time.Now().UTC()
+	authFailure := new(models.AuthFailure)
+
+	// counted in a single statement so concurrent guesses can't share a count
+	if err := p.Conn.Raw(`
+		insert into auth_failures (kind, scope, key, failures, last_failure_at, created_at, updated_at)
+		values (?, ?, ?, 1, ?, ?, ?)
+		on conflict (kind, scope, key) do update set
+			failures = case when auth_failures.last_failure_at < ? then 1 else auth_failures.failures + 1 end,
+			last_failure_at = excluded.last_failure_at,
+			updated_at = excluded.updated_at
+		returning *`,
+		kind, scope, key, now, now, now, now.Add(-helper.LockoutResetAfter())).
+		Scan(authFailure).Error; err != nil {
+		return nil, errors.New("failed to record auth failure")
+	}
+
+	if duration := helper.LockoutDuration(authFailure.Failures, threshold); duration > 0 {
+		lockedUntil := now.Add(duration)
+		if err := p.Conn.Table("auth_failures").
+			Where("id = ?", authFailure.ID).
+			Update("locked_until", lockedUntil).Error; err != nil {
+			return nil, errors.New("failed to record auth failure")
+		}
+		authFailure.LockedUntil = &lockedUntil
+	}
+
+	return authFailure, nil
+}
+
+func (p AuthRepository) ResetAuthFailures(kind, scope, key string) error {
+	if err := p.Conn.Unscoped().Table("auth_failures").
+		Where("kind = ?", kind).
+		Where("scope = ?", scope).
+		Where("key = ?", key).
+		Delete(models.AuthFailure{}).Error; err != nil {
+		return errors.New("failed to reset auth failures")
+	}
+
+	return nil
+}
+
+func (p AuthRepository) SetUnlockToken(id uint, token string, expiredAt time.Time) error {
+	if err := p.Conn.Table("auth_failures").
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"unlock_token": token, "unlock_expired_at": expiredAt}).Error; err != nil {
+		return errors.New("failed to create unlock token")
+	}
+
+	return nil
+}
+
+func (p AuthRepository) FetchAuthFailureByUnlockToken(token string) (*models.AuthFailure, error) {
+	authFailure := new(models.AuthFailure)
+
+	if err := p.Conn.Table("auth_failures").
+		Where("unlock_token = ?", token).
+		Where("unlock_expired_at > ?", time.Now().UTC()).
+		First(&authFailure).Error; err != nil {
+		return nil, errors.New("unlock link is invalid or expired")
 	}
 
-	return codes, nil
+	return authFailure, nil
 }
 
 func NewPgsqlAuthRepository(conn *gorm.DB) auth.Repository {
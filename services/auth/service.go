@@ -3,12 +3,21 @@ package auth
 import "github.com/ardiantirta/go-user-management/models"
 
 type Service interface {
-	Register(req *models.RegisterForm) (map[string]interface{}, error)
-	Verification(params map[string]interface{}) (map[string]interface{}, error)
+	Register(req *models.RegisterForm, meta *models.RequestMetadata) (map[string]interface{}, error)
+	Verification(params map[string]interface{}, meta *models.RequestMetadata) (map[string]interface{}, error)
 	SendVerificationCode(params map[string]interface{}) (map[string]interface{}, error)
-	Login(email, password string) (map[string]interface{}, error)
-	TwoFactorAuthVerify(id int, code string) (map[string]interface{}, error)
-	TwoFactorAuthByPass(id int, code string) (map[string]interface{}, error)
+	Invitation(code string) (map[string]interface{}, error)
+	Login(email, password string, meta *models.RequestMetadata) (map[string]interface{}, error)
+	TwoFactorAuthVerify(id int, currentToken, code string, meta *models.RequestMetadata) (map[string]interface{}, error)
+	TwoFactorAuthByPass(id int, currentToken, code string, meta *models.RequestMetadata) (map[string]interface{}, error)
 	ForgotPassword(email string) (map[string]interface{}, error)
-	ResetPassword(email, password string) (map[string]interface{}, error)
+	ResetPassword(token, password string, meta *models.RequestMetadata) (map[string]interface{}, error)
+	RefreshToken(refreshToken string, meta *models.RequestMetadata) (map[string]interface{}, error)
+	Unlock(token string, meta *models.RequestMetadata) (map[string]interface{}, error)
+	CancelEmailChange(token string, meta *models.RequestMetadata) (map[string]interface{}, error)
+	JWKS() (map[string]interface{}, error)
+	WebAuthnLoginBegin(email string) (map[string]interface{}, error)
+	WebAuthnLoginFinish(form *models.WebAuthnCredentialForm, meta *models.RequestMetadata) (map[string]interface{}, error)
+	WebAuthnTFABegin(id int) (map[string]interface{}, error)
+	WebAuthnTFAFinish(id int, currentToken string, form *models.WebAuthnCredentialForm, meta *models.RequestMetadata) (map[string]interface{}, error)
 }
\ No newline at end of file
@@ -1,241 +1,660 @@
 package service
 
 import (
+	"encoding/base64"
 	"errors"
-	"fmt"
 	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/mailer"
 	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/passwordpolicy"
 	"github.com/ardiantirta/go-user-management/services/auth"
-	"github.com/dgrijalva/jwt-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
-	"github.com/spf13/viper"
+	"github.com/ardiantirta/go-user-management/services/event"
+	"github.com/ardiantirta/go-user-management/services/organization"
+	"github.com/ardiantirta/go-user-management/signing"
+	"github.com/ardiantirta/go-user-management/webauthn"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type AuthService struct {
-	AuthRepository auth.Repository
+	AuthRepository         auth.Repository
+	OrganizationRepository organization.Repository
+	EventRepository        event.Repository
 }
 
-func (a *AuthService) Register(req *models.RegisterForm) (map[string]interface{}, error) {
-	user, verificationCode, err := a.AuthRepository.Register(req)
+func (a *AuthService) Register(req *models.RegisterForm, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	newOrganization, joinOrganization, invitation, err := a.registrationOrganization(req)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	sg := new(models.SendGridEmail)
-	sg.From = mail.NewEmail("User Example 1", "user1@example.com")
-	sg.To = mail.NewEmail(user.FullName, user.Email)
-	sg.Subject = "Email Verification: user-management-go"
-	sg.PlainContent = "Please verify your email"
-	sg.HtmlContent = fmt.Sprintf(`<a href="http://localhost:3000/auth/verification/%s">email verification</a>`, verificationCode.Code)
-	if err := helper.SendVerificationByEmail(sg); err != nil {
-		return helper.ErrorMessage(0, err.Error()), err
+	user, verificationCode, err := a.AuthRepository.Register(req)
+	if err != nil {
+		return passwordpolicy.ErrorMessage(err), err
+	}
+
+	event.Record(a.EventRepository, int(user.ID), event.TypeRegistered, meta, nil)
+
+	// the account exists by now, failing here would only make the user
+	// register again under an email that's already taken
+	if newOrganization != nil {
+		if err := a.OrganizationRepository.CreateOrganization(newOrganization, int(user.ID)); err != nil {
+			logrus.WithField("user_id", user.ID).Error(err)
+		} else {
+			event.Record(a.EventRepository, int(user.ID), event.TypeOrganizationCreated, meta, map[string]interface{}{"organization_id": newOrganization.ID})
+		}
 	}
 
-	//go func() {
-	//	_ = helper.SendVerificationByEmail(user, verificationCode.Code)
-	//}()
+	if joinOrganization != nil {
+		if err := a.OrganizationRepository.AddMember(&models.OrganizationMember{
+			OrganizationID: joinOrganization.ID,
+			UserID:         int(user.ID),
+			Role:           organization.RoleMember,
+		}); err != nil {
+			logrus.WithField("user_id", user.ID).Error(err)
+		} else {
+			event.Record(a.EventRepository, int(user.ID), event.TypeOrganizationJoined, meta, map[string]interface{}{"organization_id": joinOrganization.ID})
+		}
+	}
+
+	// the invitation link reached the inbox, so the email is verified along
+	// with accepting it. If that fails the usual verification email goes out.
+	if invitation != nil {
+		if err := a.OrganizationRepository.AcceptInvitation(invitation, int(user.ID), true); err != nil {
+			logrus.WithField("user_id", user.ID).Error(err)
+		} else {
+			event.Record(a.EventRepository, int(user.ID), event.TypeInvitationAccepted, meta, map[string]interface{}{
+				"organization_id": invitation.OrganizationID,
+				"invitation_id":   invitation.ID,
+				"invited_by":      invitation.InvitedBy,
+			})
+			event.Record(a.EventRepository, int(user.ID), event.TypeEmailVerified, meta, nil)
+
+			return map[string]interface{}{"status": true, "is_verified": true}, nil
+		}
+	}
+
+	if err := mailer.SendTemplate(mailer.Address{Name: user.FullName, Email: user.Email}, user.Locale, mailer.TemplateVerification, map[string]interface{}{
+		"Link": helper.VerificationURL(verificationCode),
+	}); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
 
 	mapResponse := map[string]interface{}{"status": true}
 	return mapResponse, nil
 }
 
-func (a *AuthService) Verification(params map[string]interface{}) (map[string]interface{}, error) {
-	err := a.AuthRepository.Verification(params)
+// registrationOrganization checks the organization part of a sign up before
+// the account is created. It returns the organization to create, the one to
+// join or the invitation to accept, organizations without a join domain can
+// only be joined with an invitation.
+func (a *AuthService) registrationOrganization(req *models.RegisterForm) (*models.Organization, *models.Organization, *models.OrganizationInvitation, error) {
+	name := strings.TrimSpace(req.OrganizationName)
+	slug := strings.ToLower(strings.TrimSpace(req.OrganizationSlug))
+	code := strings.TrimSpace(req.InvitationCode)
+
+	set := 0
+	for _, value := range []string{name, slug, code} {
+		if value != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, nil, nil, errors.New("only one of organization_name, organization_slug or invitation_code can be set")
+	}
+
+	if name != "" {
+		name, slug, _, err := organization.Normalize(name, "", "")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if _, err := a.OrganizationRepository.FetchOrganizationBySlug(slug); err == nil {
+			return nil, nil, nil, errors.New("organization already exist, ask to be invited")
+		}
+
+		return &models.Organization{Name: name, Slug: slug}, nil, nil, nil
+	}
+
+	if slug != "" {
+		org, err := a.OrganizationRepository.FetchOrganizationBySlug(slug)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if !organization.CanJoin(org.JoinDomain, req.Email) {
+			return nil, nil, nil, errors.New("organization can only be joined with an invitation")
+		}
+
+		return nil, org, nil, nil
+	}
+
+	if code != "" {
+		invitation, err := a.OrganizationRepository.FetchInvitationByCode(code)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if err := organization.InvitationError(invitation, time.Now()); err != nil {
+			return nil, nil, nil, err
+		}
+
+		if !strings.EqualFold(strings.TrimSpace(req.Email), invitation.Email) {
+			return nil, nil, nil, errors.New("invitation was sent to another email address")
+		}
+
+		return nil, nil, invitation, nil
+	}
+
+	return nil, nil, nil, nil
+}
+
+// Invitation is looked up before signing in or registering, the client uses
+// it to prefill the register form or to ask the invitee to log in first.
+func (a *AuthService) Invitation(code string) (map[string]interface{}, error) {
+	invitation, err := a.OrganizationRepository.FetchInvitationByCode(code)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	if err := organization.InvitationError(invitation, time.Now()); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	org, err := a.OrganizationRepository.FetchOrganizationByID(int(invitation.OrganizationID))
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	_, err = a.AuthRepository.FetchUserByEmail(invitation.Email)
+
+	return map[string]interface{}{
+		"organization": map[string]interface{}{
+			"id":   org.ID,
+			"name": org.Name,
+			"slug": org.Slug,
+		},
+		"email":          invitation.Email,
+		"role":           invitation.Role,
+		"expired_at":     invitation.ExpiredAt.Format(helper.FormatRFC8601),
+		"account_exists": err == nil,
+	}, nil
+}
+
+func (a *AuthService) Verification(params map[string]interface{}, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	// codes are looked up by value, so guessing is throttled per ip as well
+	// as per code
+	if response, err := a.checkLockout(helper.AuthFailureVerification, 0, meta); err != nil {
+		return response, err
+	}
+
+	userID, err := a.AuthRepository.Verification(params)
+	if err != nil {
+		a.registerFailure(helper.AuthFailureVerification, nil, meta)
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if params["purpose"].(string) == helper.VerificationPurposeEmailChange {
+		event.Record(a.EventRepository, userID, event.TypeEmailChanged, meta, nil)
+	} else {
+		event.Record(a.EventRepository, userID, event.TypeEmailVerified, meta, nil)
+	}
+
 	mapResponse := map[string]interface{}{"status": true}
 	return mapResponse, nil
 }
 
-func (a *AuthService) SendVerificationCode(params map[string]interface{}) (map[string]interface{}, error) {
-	emailType := params["type"].(string)
-	recipient := params["recipient"].(string)
-
-	user, verificationCode, err := a.AuthRepository.SendVerificationCode(recipient)
+func (a *AuthService) CancelEmailChange(token string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	emailChange, err := a.AuthRepository.CancelEmailChange(token)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	sg := new(models.SendGridEmail)
-	sg.From = mail.NewEmail("User Example 1", "user1@example.com")
-	sg.To = mail.NewEmail(user.FullName, user.Email)
-	switch emailType {
+	event.Record(a.EventRepository, emailChange.UserID, event.TypeEmailChangeCancelled, meta, map[string]interface{}{"email": emailChange.NewEmail})
+
+	return map[string]interface{}{"status": true}, nil
+}
+
+func (a *AuthService) SendVerificationCode(params map[string]interface{}) (map[string]interface{}, error) {
+	recipient := params["recipient"].(string)
+
+	var purpose string
+	switch params["type"].(string) {
 	case "email.verify":
-		sg.Subject = "Email Verification: user-management-go"
+		purpose = helper.VerificationPurposeSignup
+	case "account.reactivate":
+		purpose = helper.VerificationPurposeReactivation
 	default:
-		sg.Subject = "hello from user-management-go"
+		err := errors.New("type must be email.verify or account.reactivate")
+		return helper.ErrorMessage(0, err.Error()), err
 	}
-	sg.PlainContent = "Please verify your email"
-	sg.HtmlContent = fmt.Sprintf(`<a href="http://localhost:3000/auth/verification/%s">verify here</a>`, verificationCode)
-	if err := helper.SendVerificationByEmail(sg); err != nil {
+
+	user, verificationCode, err := a.AuthRepository.SendVerificationCode(recipient, purpose)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := mailer.SendTemplate(mailer.Address{Name: user.FullName, Email: user.Email}, user.Locale, mailer.TemplateVerification, map[string]interface{}{
+		"Link": helper.VerificationURL(verificationCode),
+	}); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
 	return map[string]interface{}{"status": true}, nil
 }
 
-func (a *AuthService) Login(email, password string) (map[string]interface{}, error) {
-	response, err := a.AuthRepository.Login(email, password)
+func (a *AuthService) Login(email, password string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	// looked up separately so failed attempts on a known account end up in
+	// that account's event log
+	user, _ := a.AuthRepository.FetchUserByEmail(email)
+
+	userID := 0
+	if user != nil {
+		userID = int(user.ID)
+	}
+
+	if response, err := a.checkLockout(helper.AuthFailureLogin, userID, meta); err != nil {
+		if user != nil {
+			event.Record(a.EventRepository, userID, event.TypeLoginFailed, meta, map[string]interface{}{
+				"method": "password",
+				"reason": err.Error(),
+			})
+		}
+		return response, err
+	}
+
+	knownDevice := user == nil || a.isKnownDevice(userID, meta)
+
+	response, err := a.AuthRepository.Login(email, password, meta)
 	if err != nil {
+		// unknown emails count against the ip, wrong passwords against the
+		// account as well
+		if user == nil {
+			a.registerFailure(helper.AuthFailureLogin, nil, meta)
+		} else if err == auth.ErrInvalidCredentials {
+			a.registerFailure(helper.AuthFailureLogin, user, meta)
+		}
+
+		if user != nil {
+			event.Record(a.EventRepository, userID, event.TypeLoginFailed, meta, map[string]interface{}{
+				"method": "password",
+				"reason": err.Error(),
+			})
+		}
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	if user != nil {
+		a.clearFailures(helper.AuthFailureLogin, userID)
+		event.Record(a.EventRepository, userID, event.TypeLoginSucceeded, meta, map[string]interface{}{"method": "password"})
+
+		if !knownDevice {
+			a.sendNewLoginAlert(user, meta)
+		}
+
+		// an expired password still logs in, but the token only lets the user
+		// through two factor authentication and the password change
+		changedAt := passwordpolicy.ChangedAt(user)
+
+		policy := passwordpolicy.Current()
+		if expiresAt := policy.ExpiresAt(changedAt); expiresAt != nil {
+			response["password_expired"] = policy.Expired(changedAt)
+			response["password_expires_at"] = expiresAt.Format(helper.FormatRFC8601)
+		}
+
+		if a.screenPassword(user, password, meta) {
+			response["password_compromised"] = true
+		}
+	}
+
 	return response, nil
 }
 
-func (a *AuthService) TwoFactorAuthVerify(id int, code string) (map[string]interface{}, error) {
+func (a *AuthService) TwoFactorAuthVerify(id int, currentToken, code string, meta *models.RequestMetadata) (map[string]interface{}, error) {
 	currentUser, err := a.AuthRepository.FetchUserByID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	if currentUser.TFACode != code {
+	if currentUser.IsTFA != 1 {
+		err := errors.New("two factor authentication is not enabled")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if response, err := a.checkLockout(helper.AuthFailureTFA, id, meta); err != nil {
+		return response, err
+	}
+
+	step, ok := helper.ValidateTOTPCode(currentUser.SecretCode, code, time.Now().UTC(), currentUser.TFALastStep)
+	if !ok {
+		a.registerFailure(helper.AuthFailureTFA, currentUser, meta)
+		event.Record(a.EventRepository, id, event.TypeTFAFailed, meta, map[string]interface{}{"method": "totp"})
 		err := errors.New("wrong code")
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	userToken, err := a.AuthRepository.FetchUserTokenByUserID(id)
+	if err := a.AuthRepository.ConsumeTFAStep(id, step); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	a.clearFailures(helper.AuthFailureTFA, id)
+	event.Record(a.EventRepository, id, event.TypeTFAVerified, meta, map[string]interface{}{"method": "totp"})
+
+	return a.tfaVerifiedToken(currentUser, currentToken, meta)
+}
+
+func (a *AuthService) TwoFactorAuthByPass(id int, currentToken, code string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	currentUser, err := a.AuthRepository.FetchUserByID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	isTfa := false
-	if currentUser.IsTFA == 1 {
-		isTfa = true
+	if response, err := a.checkLockout(helper.AuthFailureTFA, id, meta); err != nil {
+		return response, err
 	}
 
-	expiredAt := time.Now().UTC().AddDate(0, 0, 7)
-	signKey := []byte(viper.GetString("jwt.signkey"))
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, models.CustomClaims{
-		ID:    int(currentUser.ID),
-		Email: currentUser.Email,
-		IsTFA: isTfa,
-		Code: code,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expiredAt.Unix(),
-		},
-	})
+	if _, err := a.AuthRepository.ConsumeBackUpCode(id, code); err != nil {
+		a.registerFailure(helper.AuthFailureTFA, currentUser, meta)
+		event.Record(a.EventRepository, id, event.TypeTFAFailed, meta, map[string]interface{}{"method": "backup_code"})
+		err := errors.New("wrong code, try again")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
 
-	tokenString, _ := token.SignedString(signKey)
+	a.clearFailures(helper.AuthFailureTFA, id)
 
-	userToken.Token = tokenString
-	if err := a.AuthRepository.SaveUserToken(userToken); err != nil {
+	remaining, err := a.AuthRepository.CountBackUpCodes(id)
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	event.Record(a.EventRepository, id, event.TypeTFABackupCodeUsed, meta, map[string]interface{}{"remaining": remaining})
+	if err == nil && remaining <= helper.BackupCodeLowThreshold() {
+		a.sendBackupCodesLow(currentUser, remaining)
+	}
+
+	response, err := a.tfaVerifiedToken(currentUser, currentToken, meta)
+	if err != nil {
+		return response, err
+	}
+
+	response["backup_codes_remaining"] = remaining
+	return response, nil
+}
+
+func (a *AuthService) tfaVerifiedToken(currentUser *models.User, currentToken string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	userToken, err := a.AuthRepository.FetchUserToken(int(currentUser.ID), currentToken)
+	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	expiredAtStr := expiredAt.Format(helper.FormatRFC8601)
+	meta.StartedAt = userToken.SessionStartedAt
+	meta.OrganizationID = userToken.OrganizationID
 
-	return map[string]interface{}{
-		"access_token": map[string]interface{} {
-			"value": userToken.Token,
-			"type": userToken.Type,
-			"expired_at": expiredAtStr,
-		},
-	}, nil
+	if err := a.AuthRepository.DeleteUserToken(userToken); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	response, err := a.AuthRepository.IssueUserToken(currentUser, true, userToken.FamilyID, meta)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return response, nil
 }
 
-func (a *AuthService) TwoFactorAuthByPass(id int, code string) (map[string]interface{}, error) {
-	currentUser, err := a.AuthRepository.FetchUserByID(id)
+func (a *AuthService) RefreshToken(refreshToken string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	user, userToken, err := a.AuthRepository.RotateRefreshToken(refreshToken, "")
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	backupCodes, err := a.AuthRepository.FetchBackUpCodesByUserID(id)
+	meta.StartedAt = userToken.SessionStartedAt
+	meta.OrganizationID = userToken.OrganizationID
+	response, err := a.AuthRepository.IssueUserToken(user, true, userToken.FamilyID, meta)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	validCode := false
-	for _, b := range backupCodes {
-		if b.Code == code {
-			validCode = true
-			break
+	return response, nil
+}
+
+func (a *AuthService) ForgotPassword(email string) (map[string]interface{}, error) {
+	user, resetToken, token, err := a.AuthRepository.ForgotPassword(email)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := mailer.SendTemplate(mailer.Address{Name: user.FullName, Email: user.Email}, user.Locale, mailer.TemplatePasswordReset, map[string]interface{}{
+		"Token":     token,
+		"ExpiredAt": resetToken.ExpiredAt.Format(helper.FormatRFC8601),
+	}); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	mapResponse := map[string]interface{}{"status": true}
+	return mapResponse, nil
+}
+
+func (a *AuthService) ResetPassword(token, password string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	user, err := a.AuthRepository.ResetPassword(token, password)
+	if err != nil {
+		return passwordpolicy.ErrorMessage(err), err
+	}
+
+	event.Record(a.EventRepository, int(user.ID), event.TypePasswordReset, meta, nil)
+
+	return map[string]interface{}{"status": true}, nil
+}
+
+func (a *AuthService) JWKS() (map[string]interface{}, error) {
+	return signing.JWKS(), nil
+}
+
+func (a *AuthService) WebAuthnLoginBegin(email string) (map[string]interface{}, error) {
+	userID := 0
+	allow := make([][]byte, 0)
+
+	if email != "" {
+		if user, err := a.AuthRepository.FetchUserByEmail(email); err == nil {
+			userID = int(user.ID)
+
+			credentials, err := a.AuthRepository.FetchWebAuthnCredentialsByUserID(userID)
+			if err != nil {
+				return helper.ErrorMessage(0, err.Error()), err
+			}
+
+			for _, c := range credentials {
+				if credentialID, err := webauthn.DecodeBase64URL(c.CredentialID); err == nil {
+					allow = append(allow, credentialID)
+				}
+			}
 		}
 	}
 
-	if validCode == false {
-		err := errors.New("wrong code, try again")
+	return a.webAuthnChallenge(userID, helper.WebAuthnSessionLogin, allow, "required")
+}
+
+func (a *AuthService) WebAuthnLoginFinish(form *models.WebAuthnCredentialForm, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	credential, session, err := a.verifyWebAuthnAssertion(form, helper.WebAuthnSessionLogin, true)
+	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	userToken, err := a.AuthRepository.FetchUserTokenByUserID(id)
+	if session.UserID != 0 && session.UserID != credential.UserID {
+		err := errors.New("webauthn credential is not allowed")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	user, err := a.AuthRepository.FetchUserByID(credential.UserID)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	isTfa := false
-	if currentUser.IsTFA == 1 {
-		isTfa = true
+	if user.DeactivatedAt != nil {
+		err := errors.New("account is deactivated")
+		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	expiredAt := time.Now().UTC().AddDate(0, 0, 7)
-	signKey := []byte(viper.GetString("jwt.signkey"))
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, models.CustomClaims{
-		ID:    int(currentUser.ID),
-		Email: currentUser.Email,
-		IsTFA: isTfa,
-		Code: currentUser.TFACode,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expiredAt.Unix(),
-		},
-	})
+	if user.IsActive != 1 {
+		err := errors.New("please verify your email")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
 
-	tokenString, _ := token.SignedString(signKey)
+	// a passkey doesn't get around a reset forced by an admin
+	if user.PasswordResetRequired == 1 {
+		err := errors.New("password reset is required, check your email for the reset link")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	knownDevice := a.isKnownDevice(int(user.ID), meta)
 
-	userToken.Token = tokenString
-	if err := a.AuthRepository.SaveUserToken(userToken); err != nil {
+	response, err := a.AuthRepository.IssueUserToken(user, true, "", meta)
+	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	expiredAtStr := expiredAt.Format(helper.FormatRFC8601)
+	event.Record(a.EventRepository, int(user.ID), event.TypeLoginSucceeded, meta, map[string]interface{}{"method": "webauthn"})
 
-	return map[string]interface{}{
-		"access_token": map[string]interface{} {
-			"value": userToken.Token,
-			"type": userToken.Type,
-			"expired_at": expiredAtStr,
-		},
-	}, nil
+	if !knownDevice {
+		a.sendNewLoginAlert(user, meta)
+	}
+
+	response["require_tfa"] = false
+
+	return response, nil
 }
 
-func (a *AuthService) ForgotPassword(email string) (map[string]interface{}, error) {
-	user, token, err := a.AuthRepository.ForgotPassword(email)
+func (a *AuthService) WebAuthnTFABegin(id int) (map[string]interface{}, error) {
+	credentials, err := a.AuthRepository.FetchWebAuthnCredentialsByUserID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	sg := new(models.SendGridEmail)
-	sg.From = mail.NewEmail("User Example 1", "user1@example.com")
-	sg.To = mail.NewEmail(user.FullName, user.Email)
-	sg.Subject = "Forgot Password Token"
-	sg.PlainContent = "here is you reset password token"
-	sg.HtmlContent = token
-	if err := helper.SendVerificationByEmail(sg); err != nil {
+	if len(credentials) == 0 {
+		err := errors.New("no webauthn credential registered")
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	//go func() {
-	//	_ = helper.SendVerificationByEmail(user, verificationCode.Code)
-	//}()
+	allow := make([][]byte, 0)
+	for _, c := range credentials {
+		if credentialID, err := webauthn.DecodeBase64URL(c.CredentialID); err == nil {
+			allow = append(allow, credentialID)
+		}
+	}
 
-	mapResponse := map[string]interface{}{"status": true, "token": token}
-	return mapResponse, nil
+	return a.webAuthnChallenge(id, helper.WebAuthnSessionTFA, allow, "discouraged")
 }
 
-func (a *AuthService) ResetPassword(email, password string) (map[string]interface{}, error) {
-	response, err := a.AuthRepository.ResetPassword(email, password)
+func (a *AuthService) WebAuthnTFAFinish(id int, currentToken string, form *models.WebAuthnCredentialForm, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	credential, session, err := a.verifyWebAuthnAssertion(form, helper.WebAuthnSessionTFA, false)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	return response, nil
+	if session.UserID != id || credential.UserID != id {
+		err := errors.New("webauthn credential is not allowed")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	currentUser, err := a.AuthRepository.FetchUserByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(a.EventRepository, id, event.TypeTFAVerified, meta, map[string]interface{}{"method": "webauthn"})
+
+	return a.tfaVerifiedToken(currentUser, currentToken, meta)
+}
+
+func (a *AuthService) webAuthnChallenge(userID int, sessionType string, allow [][]byte, userVerification string) (map[string]interface{}, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return helper.ErrorMessage(0, "failed to create challenge"), err
+	}
+
+	session := new(models.WebAuthnSession)
+	session.UserID = userID
+	session.Challenge = challenge
+	session.Type = sessionType
+	session.ExpiredAt = time.Now().UTC().Add(helper.WebAuthnSessionTTL)
+	if err := a.AuthRepository.CreateWebAuthnSession(session); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"publicKey": helper.WebAuthnConfig().RequestOptions(challenge, allow, userVerification),
+	}, nil
+}
+
+func (a *AuthService) verifyWebAuthnAssertion(form *models.WebAuthnCredentialForm, sessionType string, requireUV bool) (*models.WebAuthnCredential, *models.WebAuthnSession, error) {
+	rawID, err := webauthn.DecodeBase64URL(form.RawID)
+	if err != nil {
+		return nil, nil, errors.New("invalid rawId")
+	}
+
+	clientDataJSON, err := webauthn.DecodeBase64URL(form.Response.ClientDataJSON)
+	if err != nil {
+		return nil, nil, errors.New("invalid clientDataJSON")
+	}
+
+	authenticatorData, err := webauthn.DecodeBase64URL(form.Response.AuthenticatorData)
+	if err != nil {
+		return nil, nil, errors.New("invalid authenticatorData")
+	}
+
+	signature, err := webauthn.DecodeBase64URL(form.Response.Signature)
+	if err != nil {
+		return nil, nil, errors.New("invalid signature")
+	}
+
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := a.AuthRepository.ConsumeWebAuthnSession(clientData.Challenge, sessionType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credential, err := a.AuthRepository.FetchWebAuthnCredentialByCredentialID(webauthn.EncodeBase64URL(rawID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if form.Response.UserHandle != "" {
+		userHandle, err := webauthn.DecodeBase64URL(form.Response.UserHandle)
+		if err != nil || string(userHandle) != strconv.Itoa(credential.UserID) {
+			return nil, nil, errors.New("webauthn user handle mismatch")
+		}
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(credential.PublicKey)
+	if err != nil {
+		return nil, nil, errors.New("stored webauthn credential is invalid")
+	}
+
+	signCount, err := helper.WebAuthnConfig().VerifyAssertion(session.Challenge, publicKey, uint32(credential.SignCount), clientDataJSON, authenticatorData, signature, requireUV)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	credential.SignCount = int64(signCount)
+	credential.LastUsedAt = &now
+	if err := a.AuthRepository.SaveWebAuthnCredential(credential); err != nil {
+		return nil, nil, err
+	}
+
+	return credential, session, nil
 }
 
-func NewAuthService(authRepository auth.Repository) auth.Service {
+func NewAuthService(authRepository auth.Repository, organizationRepository organization.Repository, eventRepository event.Repository) auth.Service {
 	return &AuthService{
-		AuthRepository: authRepository,
+		AuthRepository:         authRepository,
+		OrganizationRepository: organizationRepository,
+		EventRepository:        eventRepository,
 	}
 }
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/mailer"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/event"
+)
+
+var errTooManyAttempts = errors.New("too many failed attempts, please try again later")
+
+func lockedResponse(lockedUntil time.Time) map[string]interface{} {
+	retryAfter := int(time.Until(lockedUntil).Seconds()) + 1
+
+	response := helper.ErrorMessage(0, errTooManyAttempts.Error())
+	response["retry_after"] = retryAfter
+	response["locked_until"] = lockedUntil.UTC().Format(helper.FormatRFC8601)
+
+	return response
+}
+
+// checkLockout is called before any credential is verified so a locked
+// account doesn't leak whether the guess would have been right.
+func (a *AuthService) checkLockout(kind string, userID int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	now := time.Now().UTC()
+
+	keys := map[string]string{helper.AuthFailureScopeIP: meta.IPAddress}
+	if userID != 0 {
+		keys[helper.AuthFailureScopeAccount] = strconv.Itoa(userID)
+	}
+
+	var lockedUntil *time.Time
+	for scope, key := range keys {
+		authFailure, err := a.AuthRepository.FetchAuthFailure(kind, scope, key)
+		if err != nil || authFailure.LockedUntil == nil || !authFailure.LockedUntil.After(now) {
+			continue
+		}
+
+		if lockedUntil == nil || authFailure.LockedUntil.After(*lockedUntil) {
+			lockedUntil = authFailure.LockedUntil
+		}
+	}
+
+	if lockedUntil != nil {
+		return lockedResponse(*lockedUntil), errTooManyAttempts
+	}
+
+	return nil, nil
+}
+
+func (a *AuthService) registerFailure(kind string, user *models.User, meta *models.RequestMetadata) {
+	if _, err := a.AuthRepository.RegisterAuthFailure(kind, helper.AuthFailureScopeIP, meta.IPAddress,
+		helper.LockoutThreshold(kind, helper.AuthFailureScopeIP)); err != nil {
+		logrus.Error(err)
+	}
+
+	if user == nil {
+		return
+	}
+
+	threshold := helper.LockoutThreshold(kind, helper.AuthFailureScopeAccount)
+	authFailure, err := a.AuthRepository.RegisterAuthFailure(kind, helper.AuthFailureScopeAccount, strconv.Itoa(int(user.ID)), threshold)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	// only the failure that crosses the threshold notifies the user, later
+	// ones just extend the lock
+	if authFailure.Failures != threshold {
+		return
+	}
+
+	event.Record(a.EventRepository, int(user.ID), event.TypeAccountLocked, meta, map[string]interface{}{
+		"kind":         kind,
+		"locked_until": authFailure.LockedUntil.Format(helper.FormatRFC8601),
+	})
+
+	if err := a.sendUnlockEmail(user, authFailure); err != nil {
+		logrus.WithField("user_id", user.ID).Error(err)
+	}
+}
+
+func (a *AuthService) clearFailures(kind string, userID int) {
+	if err := a.AuthRepository.ResetAuthFailures(kind, helper.AuthFailureScopeAccount, strconv.Itoa(userID)); err != nil {
+		logrus.Error(err)
+	}
+}
+
+func (a *AuthService) sendUnlockEmail(user *models.User, authFailure *models.AuthFailure) error {
+	token, err := helper.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	if err := a.AuthRepository.SetUnlockToken(authFailure.ID, helper.HashToken(token), time.Now().UTC().Add(helper.UnlockTokenTTL())); err != nil {
+		return err
+	}
+
+	return mailer.SendTemplate(mailer.Address{Name: user.FullName, Email: user.Email}, user.Locale, mailer.TemplateAccountLocked, map[string]interface{}{
+		"Link":        helper.PublicURL("/auth/unlock/" + token),
+		"LockedUntil": authFailure.LockedUntil.UTC().Format(helper.FormatRFC8601),
+	})
+}
+
+func (a *AuthService) Unlock(token string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	authFailure, err := a.AuthRepository.FetchAuthFailureByUnlockToken(helper.HashToken(token))
+	if err != nil || authFailure.Scope != helper.AuthFailureScopeAccount {
+		err := errors.New("unlock link is invalid or expired")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	userID, err := strconv.Atoi(authFailure.Key)
+	if err != nil {
+		return helper.ErrorMessage(0, "unlock link is invalid or expired"), err
+	}
+
+	for _, kind := range []string{helper.AuthFailureLogin, helper.AuthFailureTFA} {
+		if err := a.AuthRepository.ResetAuthFailures(kind, helper.AuthFailureScopeAccount, authFailure.Key); err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	}
+
+	event.Record(a.EventRepository, userID, event.TypeAccountUnlocked, meta, nil)
+
+	return map[string]interface{}{"status": true}, nil
+}
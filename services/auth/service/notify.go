@@ -0,0 +1,45 @@
+package service
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/mailer"
+	"github.com/ardiantirta/go-user-management/models"
+)
+
+// isKnownDevice has to be asked before the new session is issued, afterwards
+// the device is always known.
+func (a *AuthService) isKnownDevice(userID int, meta *models.RequestMetadata) bool {
+	known, err := a.AuthRepository.IsKnownDevice(userID, meta.UserAgent)
+	if err != nil {
+		logrus.Error(err)
+		return true
+	}
+
+	return known
+}
+
+func (a *AuthService) sendNewLoginAlert(user *models.User, meta *models.RequestMetadata) {
+	browser, os, device := helper.ParseUserAgent(meta.UserAgent)
+
+	if err := mailer.SendTemplate(mailer.Address{Name: user.FullName, Email: user.Email}, user.Locale, mailer.TemplateNewLogin, map[string]interface{}{
+		"Time":      time.Now().UTC().Format(helper.FormatRFC8601),
+		"IPAddress": meta.IPAddress,
+		"Browser":   browser,
+		"OS":        os,
+		"Device":    device,
+	}); err != nil {
+		logrus.WithField("user_id", user.ID).Error(err)
+	}
+}
+
+func (a *AuthService) sendBackupCodesLow(user *models.User, remaining int) {
+	if err := mailer.SendTemplate(mailer.Address{Name: user.FullName, Email: user.Email}, user.Locale, mailer.TemplateBackupCodesLow, map[string]interface{}{
+		"Remaining": remaining,
+	}); err != nil {
+		logrus.WithField("user_id", user.ID).Error(err)
+	}
+}
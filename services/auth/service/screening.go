@@ -0,0 +1,85 @@
+package service
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/passwordpolicy"
+	"github.com/ardiantirta/go-user-management/services/auth"
+	"github.com/ardiantirta/go-user-management/services/event"
+)
+
+// screenPassword checks the password the user just logged in with against the
+// breached corpus, once per corpus update. Only password hashes are stored so a
+// login is the only time the plain password is around to check.
+func (a *AuthService) screenPassword(user *models.User, password string, meta *models.RequestMetadata) bool {
+	policy := passwordpolicy.Current()
+	if user.PasswordCheckedAt != nil || policy.Corpus == nil {
+		return user.PasswordCompromised == 1
+	}
+
+	compromised := policy.IsBreached(password)
+	if err := a.AuthRepository.UpdatePasswordScreening(int(user.ID), compromised); err != nil {
+		logrus.Error(err)
+	}
+
+	if compromised && user.PasswordCompromised == 0 {
+		event.Record(a.EventRepository, int(user.ID), event.TypePasswordCompromised, meta, nil)
+	}
+
+	return compromised
+}
+
+// PasswordScreening watches the breached corpus and, when it changes, queues
+// every password screened against the old one to be screened again.
+type PasswordScreening struct {
+	AuthRepository auth.Repository
+	screenedAt     time.Time
+}
+
+func NewPasswordScreening(authRepository auth.Repository) *PasswordScreening {
+	return &PasswordScreening{
+		AuthRepository: authRepository,
+	}
+}
+
+func (s *PasswordScreening) Start(interval time.Duration) {
+	go func() {
+		s.Process()
+
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			s.Process()
+		}
+	}()
+}
+
+func (s *PasswordScreening) Process() {
+	corpus := passwordpolicy.Current().Corpus
+	if corpus == nil {
+		return
+	}
+
+	updatedAt, err := corpus.UpdatedAt()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	if !updatedAt.After(s.screenedAt) {
+		return
+	}
+
+	count, err := s.AuthRepository.ResetPasswordScreening(updatedAt)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	s.screenedAt = updatedAt
+	if count > 0 {
+		logrus.WithField("users", count).Info("breached password corpus updated, passwords will be screened on next login")
+	}
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/middleware"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/client"
+	"github.com/ardiantirta/go-user-management/services/rbac"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+type ClientHandler struct {
+	ClientService client.Service
+}
+
+func NewClientHandler(r *mux.Router, clientService client.Service) {
+	handler := ClientHandler{
+		ClientService: clientService,
+	}
+
+	v1 := r.PathPrefix("/admin/clients").Subrouter()
+
+	v1.Use(middleware.JwtAuthentication)
+	v1.Use(middleware.TwoFactorAuthentication)
+	v1.Use(middleware.RequirePermission(rbac.PermissionClientsManage))
+
+	v1.Handle("", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Clients))).Methods(http.MethodGet)
+	v1.Handle("", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.CreateClient))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Client))).Methods(http.MethodGet)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.UpdateClient))).Methods(http.MethodPut)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.DeleteClient))).Methods(http.MethodDelete)
+	v1.Handle("/{id:[0-9]+}/secret", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.RotateClientSecret))).Methods(http.MethodPost)
+}
+
+func (c *ClientHandler) Clients(w http.ResponseWriter, r *http.Request) {
+	response, err := c.ClientService.Clients()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (c *ClientHandler) Client(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := c.ClientService.Client(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (c *ClientHandler) CreateClient(w http.ResponseWriter, r *http.Request) {
+	formData := new(models.ClientForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := c.ClientService.CreateClient(formData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (c *ClientHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	formData := new(models.ClientForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := c.ClientService.UpdateClient(id, formData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (c *ClientHandler) RotateClientSecret(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := c.ClientService.RotateClientSecret(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (c *ClientHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := c.ClientService.DeleteClient(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
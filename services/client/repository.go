@@ -0,0 +1,15 @@
+package client
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Repository interface {
+	FetchClients() ([]*models.Client, error)
+	FetchClientByID(id int) (*models.Client, error)
+	FetchClientByClientID(clientID string) (*models.Client, error)
+	FetchActiveClient(clientID string) (*models.Client, error)
+	IsOriginAllowed(origin string) bool
+	CreateClient(client *models.Client) error
+	SaveClient(client *models.Client) error
+	RevokeClientTokens(clientID string) error
+	DeleteClient(client *models.Client) error
+}
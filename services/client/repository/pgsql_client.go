@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/client"
+)
+
+type ClientRepository struct {
+	Conn *gorm.DB
+}
+
+func (c ClientRepository) FetchClients() ([]*models.Client, error) {
+	clients := make([]*models.Client, 0)
+
+	if err := c.Conn.Table("clients").
+		Where("deleted_at is null").
+		Order("id").
+		Find(&clients).Error; err != nil {
+		return nil, errors.New("failed to get clients")
+	}
+
+	return clients, nil
+}
+
+func (c ClientRepository) FetchClientByID(id int) (*models.Client, error) {
+	currentClient := new(models.Client)
+
+	if err := c.Conn.Table("clients").
+		Where("id = ?", id).
+		Where("deleted_at is null").
+		First(&currentClient).Error; err != nil {
+		return nil, errors.New("client not found")
+	}
+
+	return currentClient, nil
+}
+
+func (c ClientRepository) FetchClientByClientID(clientID string) (*models.Client, error) {
+	currentClient := new(models.Client)
+
+	if err := c.Conn.Table("clients").
+		Where("client_id = ?", clientID).
+		Where("deleted_at is null").
+		First(&currentClient).Error; err != nil {
+		return nil, errors.New("client not found")
+	}
+
+	return currentClient, nil
+}
+
+func (c ClientRepository) FetchActiveClient(clientID string) (*models.Client, error) {
+	currentClient, err := c.FetchClientByClientID(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentClient.IsActive != 1 {
+		return nil, errors.New("client is disabled")
+	}
+
+	return currentClient, nil
+}
+
+func (c ClientRepository) IsOriginAllowed(origin string) bool {
+	clients := make([]*models.Client, 0)
+
+	if err := c.Conn.Table("clients").
+		Where("is_active = 1").
+		Where("deleted_at is null").
+		Where("allowed_origins like ?", "%"+origin+"%").
+		Find(&clients).Error; err != nil {
+		return false
+	}
+
+	for _, cl := range clients {
+		if cl.AllowsOrigin(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c ClientRepository) CreateClient(newClient *models.Client) error {
+	if err := c.Conn.Create(&newClient).Error; err != nil {
+		return errors.New("failed to create client")
+	}
+
+	return nil
+}
+
+func (c ClientRepository) SaveClient(currentClient *models.Client) error {
+	if err := c.Conn.Save(&currentClient).Error; err != nil {
+		return errors.New("failed to update client")
+	}
+
+	return nil
+}
+
+func (c ClientRepository) RevokeClientTokens(clientID string) error {
+	if err := c.Conn.Unscoped().Table("user_tokens").
+		Where("client_id = ?", clientID).
+		Delete(models.UserToken{}).Error; err != nil {
+		return errors.New("failed to revoke client tokens")
+	}
+
+	return nil
+}
+
+func (c ClientRepository) DeleteClient(currentClient *models.Client) error {
+	tx := c.Conn.Begin()
+
+	if err := tx.Unscoped().Table("user_tokens").
+		Where("client_id = ?", currentClient.ClientID).
+		Delete(models.UserToken{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to revoke client tokens")
+	}
+
+	if err := tx.Unscoped().Table("oauth_consents").
+		Where("client_id = ?", currentClient.ClientID).
+		Delete(models.OauthConsent{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete client consents")
+	}
+
+	if err := tx.Unscoped().Delete(&currentClient).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete client")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to delete client")
+	}
+
+	return nil
+}
+
+func NewClientRepository(conn *gorm.DB) client.Repository {
+	return &ClientRepository{
+		Conn: conn,
+	}
+}
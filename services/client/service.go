@@ -0,0 +1,12 @@
+package client
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Service interface {
+	Clients() (map[string]interface{}, error)
+	Client(id int) (map[string]interface{}, error)
+	CreateClient(form *models.ClientForm) (map[string]interface{}, error)
+	UpdateClient(id int, form *models.ClientForm) (map[string]interface{}, error)
+	RotateClientSecret(id int) (map[string]interface{}, error)
+	DeleteClient(id int) (map[string]interface{}, error)
+}
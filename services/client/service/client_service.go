@@ -0,0 +1,245 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/passwordhash"
+	"github.com/ardiantirta/go-user-management/services/client"
+)
+
+type ClientService struct {
+	ClientRepository client.Repository
+}
+
+func clientResponse(c *models.Client) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              c.ID,
+		"client_id":       c.ClientID,
+		"name":            c.Name,
+		"confidential":    c.IsConfidential(),
+		"redirect_uris":   c.RedirectURIList(),
+		"grant_types":     c.GrantTypeList(),
+		"allowed_origins": c.AllowedOriginList(),
+		"is_active":       c.IsActive == 1,
+		"created_at":      c.CreatedAt.Format(helper.FormatRFC8601),
+		"updated_at":      c.UpdatedAt.Format(helper.FormatRFC8601),
+	}
+}
+
+func validateClientForm(form *models.ClientForm) error {
+	if len(strings.TrimSpace(form.Name)) == 0 {
+		return errors.New("name is required")
+	}
+
+	if len(form.GrantTypes) == 0 {
+		return errors.New("at least one grant type is required")
+	}
+
+	for _, g := range form.GrantTypes {
+		supported := false
+		for _, s := range helper.SupportedGrantTypes {
+			if g == s {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return errors.New("unsupported grant type " + g)
+		}
+
+		if g == helper.GrantTypeAuthorizationCode && len(form.RedirectURIs) == 0 {
+			return errors.New("authorization_code clients need at least one redirect uri")
+		}
+	}
+
+	for _, uri := range form.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" || strings.ContainsAny(uri, " \t\n") {
+			return errors.New("invalid redirect uri " + uri)
+		}
+	}
+
+	for _, origin := range form.AllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return errors.New("invalid origin " + origin)
+		}
+	}
+
+	return nil
+}
+
+func normalizeOrigins(origins []string) []string {
+	normalized := make([]string, 0)
+	for _, origin := range origins {
+		normalized = append(normalized, strings.TrimSuffix(origin, "/"))
+	}
+	return normalized
+}
+
+func generateClientSecret() (string, string, error) {
+	secret, err := helper.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	hashed, err := passwordhash.Hash(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, hashed, nil
+}
+
+func (c *ClientService) Clients() (map[string]interface{}, error) {
+	clients, err := c.ClientRepository.FetchClients()
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, cl := range clients {
+		list = append(list, clientResponse(cl))
+	}
+
+	return map[string]interface{}{
+		"clients": list,
+	}, nil
+}
+
+func (c *ClientService) Client(id int) (map[string]interface{}, error) {
+	currentClient, err := c.ClientRepository.FetchClientByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return clientResponse(currentClient), nil
+}
+
+func (c *ClientService) CreateClient(form *models.ClientForm) (map[string]interface{}, error) {
+	if err := validateClientForm(form); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	newClient := new(models.Client)
+	newClient.ClientID = helper.GenerateRandomCode()
+	newClient.Name = strings.TrimSpace(form.Name)
+	newClient.RedirectURIs = strings.Join(form.RedirectURIs, " ")
+	newClient.GrantTypes = strings.Join(form.GrantTypes, " ")
+	newClient.AllowedOrigins = strings.Join(normalizeOrigins(form.AllowedOrigins), " ")
+	newClient.IsActive = 1
+	if form.IsActive != nil && !*form.IsActive {
+		newClient.IsActive = 0
+	}
+
+	secret := ""
+	if form.Confidential {
+		var hashed string
+		var err error
+		secret, hashed, err = generateClientSecret()
+		if err != nil {
+			return helper.ErrorMessage(0, "failed to generate client secret"), err
+		}
+		newClient.SecretHash = hashed
+	}
+
+	if err := c.ClientRepository.CreateClient(newClient); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	response := clientResponse(newClient)
+	if secret != "" {
+		response["client_secret"] = secret
+	}
+
+	return response, nil
+}
+
+func (c *ClientService) UpdateClient(id int, form *models.ClientForm) (map[string]interface{}, error) {
+	currentClient, err := c.ClientRepository.FetchClientByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := validateClientForm(form); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	wasActive := currentClient.IsActive == 1
+
+	currentClient.Name = strings.TrimSpace(form.Name)
+	currentClient.RedirectURIs = strings.Join(form.RedirectURIs, " ")
+	currentClient.GrantTypes = strings.Join(form.GrantTypes, " ")
+	currentClient.AllowedOrigins = strings.Join(normalizeOrigins(form.AllowedOrigins), " ")
+	if form.IsActive != nil {
+		currentClient.IsActive = 0
+		if *form.IsActive {
+			currentClient.IsActive = 1
+		}
+	}
+
+	if err := c.ClientRepository.SaveClient(currentClient); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if wasActive && currentClient.IsActive != 1 {
+		if err := c.ClientRepository.RevokeClientTokens(currentClient.ClientID); err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	}
+
+	return clientResponse(currentClient), nil
+}
+
+func (c *ClientService) RotateClientSecret(id int) (map[string]interface{}, error) {
+	currentClient, err := c.ClientRepository.FetchClientByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if !currentClient.IsConfidential() {
+		err := errors.New("public clients have no secret")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	secret, hashed, err := generateClientSecret()
+	if err != nil {
+		return helper.ErrorMessage(0, "failed to generate client secret"), err
+	}
+
+	currentClient.SecretHash = hashed
+	if err := c.ClientRepository.SaveClient(currentClient); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	response := clientResponse(currentClient)
+	response["client_secret"] = secret
+
+	return response, nil
+}
+
+func (c *ClientService) DeleteClient(id int) (map[string]interface{}, error) {
+	currentClient, err := c.ClientRepository.FetchClientByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := c.ClientRepository.DeleteClient(currentClient); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func NewClientService(clientRepository client.Repository) client.Service {
+	return &ClientService{
+		ClientRepository: clientRepository,
+	}
+}
@@ -0,0 +1,138 @@
+package http
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/middleware"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/email"
+	"github.com/ardiantirta/go-user-management/services/rbac"
+)
+
+type EmailHandler struct {
+	EmailService email.Service
+}
+
+func NewEmailHandler(r *mux.Router, emailService email.Service) {
+	handler := EmailHandler{
+		EmailService: emailService,
+	}
+
+	v1 := r.PathPrefix("/admin/emails").Subrouter()
+
+	v1.Use(middleware.JwtAuthentication)
+	v1.Use(middleware.TwoFactorAuthentication)
+	v1.Use(middleware.RequirePermission(rbac.PermissionEmailsManage))
+
+	v1.Handle("/templates", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Templates))).Methods(http.MethodGet)
+	v1.Handle("/templates/{name}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.PreviewTemplate))).Methods(http.MethodGet)
+	v1.Handle("/jobs", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.EmailJobs))).Methods(http.MethodGet)
+	v1.Handle("/jobs/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.EmailJob))).Methods(http.MethodGet)
+	v1.Handle("/jobs/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.DeleteEmailJob))).Methods(http.MethodDelete)
+	v1.Handle("/jobs/{id:[0-9]+}/retry", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.RetryEmailJob))).Methods(http.MethodPost)
+}
+
+func (e *EmailHandler) Templates(w http.ResponseWriter, r *http.Request) {
+	response, err := e.EmailService.Templates()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+// PreviewTemplate renders a template with sample data, ?format=html or
+// ?format=text returns the body as is so it can be opened in a browser.
+func (e *EmailHandler) PreviewTemplate(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	locale := r.URL.Query().Get("locale")
+
+	response, err := e.EmailService.PreviewTemplate(name, locale)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		helper.Response(w, response)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(response["html_content"].(string)))
+		return
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(response["plain_content"].(string)))
+		return
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (e *EmailHandler) EmailJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := new(models.EmailJobFilter)
+	filter.Status = query.Get("status")
+
+	filter.Page, _ = strconv.Atoi(query.Get("page"))
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+
+	filter.PerPage, _ = strconv.Atoi(query.Get("per_page"))
+	if filter.PerPage < 1 || filter.PerPage > 100 {
+		filter.PerPage = 20
+	}
+
+	response, err := e.EmailService.EmailJobs(filter)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (e *EmailHandler) EmailJob(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := e.EmailService.EmailJob(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (e *EmailHandler) RetryEmailJob(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := e.EmailService.RetryEmailJob(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (e *EmailHandler) DeleteEmailJob(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := e.EmailService.DeleteEmailJob(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
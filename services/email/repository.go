@@ -0,0 +1,19 @@
+package email
+
+import (
+	"time"
+
+	"github.com/ardiantirta/go-user-management/models"
+)
+
+type Repository interface {
+	CreateEmailJob(job *models.EmailJob) error
+	ClaimEmailJobs(limit int, lease time.Duration) ([]*models.EmailJob, error)
+	MarkEmailJobSent(job *models.EmailJob) error
+	MarkEmailJobFailed(job *models.EmailJob, lastError string, nextAttemptAt *time.Time) error
+
+	FetchEmailJobs(filter *models.EmailJobFilter) ([]*models.EmailJob, int, error)
+	FetchEmailJob(id int) (*models.EmailJob, error)
+	RetryEmailJob(id int) error
+	DeleteEmailJob(id int) error
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/ardiantirta/go-user-management/mailer"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/email"
+)
+
+type EmailRepository struct {
+	Conn *gorm.DB
+}
+
+func (e EmailRepository) CreateEmailJob(job *models.EmailJob) error {
+	if err := e.Conn.Create(&job).Error; err != nil {
+		return errors.New("failed to queue email")
+	}
+
+	return nil
+}
+
+// ClaimEmailJobs takes due jobs, and jobs whose lease ran out, for the calling
+// worker. skip locked keeps concurrent workers from claiming the same row.
+func (e EmailRepository) ClaimEmailJobs(limit int, lease time.Duration) ([]*models.EmailJob, error) {
+	now := time.Now().UTC()
+	jobs := make([]*models.EmailJob, 0)
+
+	if err := e.Conn.Raw(`
+		update email_jobs set
+			status = ?,
+			attempts = attempts + 1,
+			locked_until = ?,
+			updated_at = ?
+		where id in (
+			select id from email_jobs
+			where (status = ? and next_attempt_at <= ?) or (status = ? and locked_until < ?)
+			order by next_attempt_at
+			limit ?
+			for update skip locked
+		)
+		returning *`,
+		mailer.JobSending, now.Add(lease), now,
+		mailer.JobPending, now, mailer.JobSending, now,
+		limit).
+		Scan(&jobs).Error; err != nil {
+		return nil, errors.New("failed to claim email jobs")
+	}
+
+	return jobs, nil
+}
+
+// the attempts check makes sure a worker whose lease ran out can't overwrite
+// the outcome of the worker that took the job over.
+func (e EmailRepository) MarkEmailJobSent(job *models.EmailJob) error {
+	now := time.Now().UTC()
+
+	if err := e.Conn.Table("email_jobs").
+		Where("id = ?", job.ID).
+		Where("status = ?", mailer.JobSending).
+		Where("attempts = ?", job.Attempts).
+		Updates(map[string]interface{}{
+			"status":        mailer.JobSent,
+			"sent_at":       now,
+			"locked_until":  nil,
+			"last_error":    "",
+			"plain_content": "",
+			"html_content":  "",
+			"updated_at":    now,
+		}).Error; err != nil {
+		return errors.New("failed to update email job")
+	}
+
+	return nil
+}
+
+// MarkEmailJobFailed schedules the job again at nextAttemptAt, a nil
+// nextAttemptAt moves it to the dead letter state.
+func (e EmailRepository) MarkEmailJobFailed(job *models.EmailJob, lastError string, nextAttemptAt *time.Time) error {
+	values := map[string]interface{}{
+		"status":       mailer.JobDead,
+		"locked_until": nil,
+		"last_error":   lastError,
+		"updated_at":   time.Now().UTC(),
+	}
+
+	if nextAttemptAt != nil {
+		values["status"] = mailer.JobPending
+		values["next_attempt_at"] = *nextAttemptAt
+	}
+
+	if err := e.Conn.Table("email_jobs").
+		Where("id = ?", job.ID).
+		Where("status = ?", mailer.JobSending).
+		Where("attempts = ?", job.Attempts).
+		Updates(values).Error; err != nil {
+		return errors.New("failed to update email job")
+	}
+
+	return nil
+}
+
+func (e EmailRepository) FetchEmailJobs(filter *models.EmailJobFilter) ([]*models.EmailJob, int, error) {
+	jobs := make([]*models.EmailJob, 0)
+	total := 0
+
+	query := e.Conn.Table("email_jobs")
+
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, errors.New("failed to get email jobs")
+	}
+
+	if err := query.
+		Order("created_at desc, id desc").
+		Offset((filter.Page - 1) * filter.PerPage).
+		Limit(filter.PerPage).
+		Find(&jobs).Error; err != nil {
+		return nil, 0, errors.New("failed to get email jobs")
+	}
+
+	return jobs, total, nil
+}
+
+func (e EmailRepository) FetchEmailJob(id int) (*models.EmailJob, error) {
+	job := new(models.EmailJob)
+
+	if err := e.Conn.Table("email_jobs").
+		Where("id = ?", id).
+		First(&job).Error; err != nil {
+		return nil, errors.New("email job not found")
+	}
+
+	return job, nil
+}
+
+func (e EmailRepository) RetryEmailJob(id int) error {
+	now := time.Now().UTC()
+
+	result := e.Conn.Table("email_jobs").
+		Where("id = ?", id).
+		Where("status = ?", mailer.JobDead).
+		Updates(map[string]interface{}{
+			"status":          mailer.JobPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+			"updated_at":      now,
+		})
+	if result.Error != nil {
+		return errors.New("failed to retry email job")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("only dead email jobs can be retried")
+	}
+
+	return nil
+}
+
+func (e EmailRepository) DeleteEmailJob(id int) error {
+	result := e.Conn.
+		Where("id = ?", id).
+		Where("status <> ?", mailer.JobSending).
+		Delete(&models.EmailJob{})
+	if result.Error != nil {
+		return errors.New("failed to delete email job")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("email job not found or being sent")
+	}
+
+	return nil
+}
+
+func NewEmailRepository(conn *gorm.DB) email.Repository {
+	return &EmailRepository{
+		Conn: conn,
+	}
+}
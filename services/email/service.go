@@ -0,0 +1,13 @@
+package email
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Service interface {
+	Templates() (map[string]interface{}, error)
+	PreviewTemplate(name, locale string) (map[string]interface{}, error)
+
+	EmailJobs(filter *models.EmailJobFilter) (map[string]interface{}, error)
+	EmailJob(id int) (map[string]interface{}, error)
+	RetryEmailJob(id int) (map[string]interface{}, error)
+	DeleteEmailJob(id int) (map[string]interface{}, error)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/mailer"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/email"
+)
+
+type EmailService struct {
+	EmailRepository email.Repository
+}
+
+func (e *EmailService) Templates() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"templates":      mailer.TemplateNames,
+		"locales":        mailer.Locales(),
+		"default_locale": mailer.DefaultLocale(),
+	}, nil
+}
+
+func (e *EmailService) PreviewTemplate(name, locale string) (map[string]interface{}, error) {
+	known := false
+	for _, templateName := range mailer.TemplateNames {
+		if templateName == name {
+			known = true
+			break
+		}
+	}
+
+	if !known {
+		err := errors.New("template not found")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if locale == "" {
+		locale = mailer.DefaultLocale()
+	}
+
+	rendered, err := mailer.Render(name, locale, mailer.PreviewData(name))
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"name":          name,
+		"locale":        locale,
+		"subject":       rendered.Subject,
+		"plain_content": rendered.PlainContent,
+		"html_content":  rendered.HtmlContent,
+	}, nil
+}
+
+func (e *EmailService) EmailJobs(filter *models.EmailJobFilter) (map[string]interface{}, error) {
+	if filter.Status != "" {
+		known := false
+		for _, status := range mailer.JobStatuses {
+			if status == filter.Status {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			err := errors.New("unknown status " + filter.Status)
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	}
+
+	jobs, total, err := e.EmailRepository.FetchEmailJobs(filter)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"jobs":     jobs,
+		"page":     filter.Page,
+		"per_page": filter.PerPage,
+		"total":    total,
+	}, nil
+}
+
+func (e *EmailService) EmailJob(id int) (map[string]interface{}, error) {
+	job, err := e.EmailRepository.FetchEmailJob(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"job": job,
+	}, nil
+}
+
+func (e *EmailService) RetryEmailJob(id int) (map[string]interface{}, error) {
+	if err := e.EmailRepository.RetryEmailJob(id); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func (e *EmailService) DeleteEmailJob(id int) (map[string]interface{}, error) {
+	if err := e.EmailRepository.DeleteEmailJob(id); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func NewEmailService(emailRepository email.Repository) email.Service {
+	return &EmailService{
+		EmailRepository: emailRepository,
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ardiantirta/go-user-management/mailer"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/email"
+)
+
+// EmailQueue stores outgoing messages in email_jobs, EmailWorker delivers them.
+type EmailQueue struct {
+	EmailRepository email.Repository
+}
+
+func (q *EmailQueue) Enqueue(message *mailer.Message) error {
+	job := new(models.EmailJob)
+	job.Template = message.Template
+	job.FromName = message.From.Name
+	job.FromEmail = message.From.Email
+	job.ToName = message.To.Name
+	job.ToEmail = message.To.Email
+	job.Subject = message.Subject
+	job.PlainContent = message.PlainContent
+	job.HtmlContent = message.HtmlContent
+	job.Status = mailer.JobPending
+	job.MaxAttempts = mailer.QueueMaxAttempts()
+	job.NextAttemptAt = time.Now().UTC()
+
+	return q.EmailRepository.CreateEmailJob(job)
+}
+
+func NewEmailQueue(emailRepository email.Repository) mailer.Queue {
+	return &EmailQueue{
+		EmailRepository: emailRepository,
+	}
+}
+
+type EmailWorker struct {
+	EmailRepository email.Repository
+	BatchSize       int
+}
+
+func NewEmailWorker(emailRepository email.Repository) *EmailWorker {
+	return &EmailWorker{
+		EmailRepository: emailRepository,
+		BatchSize:       10,
+	}
+}
+
+// Start runs workers goroutines polling for due jobs every interval.
+func (w *EmailWorker) Start(workers int, interval time.Duration) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			ticker := time.NewTicker(interval)
+			for range ticker.C {
+				w.Process()
+			}
+		}()
+	}
+}
+
+// Process delivers one batch of due jobs, the batch is sent concurrently so a
+// slow provider doesn't hold up the rest.
+func (w *EmailWorker) Process() {
+	jobs, err := w.EmailRepository.ClaimEmailJobs(w.BatchSize, mailer.QueueLease())
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job *models.EmailJob) {
+			defer wg.Done()
+			w.deliver(job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+func (w *EmailWorker) deliver(job *models.EmailJob) {
+	message := new(mailer.Message)
+	message.From = &mailer.Address{Name: job.FromName, Email: job.FromEmail}
+	message.To = mailer.Address{Name: job.ToName, Email: job.ToEmail}
+	message.Subject = job.Subject
+	message.PlainContent = job.PlainContent
+	message.HtmlContent = job.HtmlContent
+	message.Template = job.Template
+
+	err := mailer.Deliver(message)
+	if err == nil {
+		if err := w.EmailRepository.MarkEmailJobSent(job); err != nil {
+			logrus.WithField("email_job_id", job.ID).Error(err)
+		}
+		return
+	}
+
+	logger := logrus.WithField("email_job_id", job.ID).WithField("attempts", job.Attempts)
+
+	var nextAttemptAt *time.Time
+	if job.Attempts < job.MaxAttempts {
+		next := time.Now().UTC().Add(mailer.RetryDelay(job.Attempts))
+		nextAttemptAt = &next
+		logger.Warn(err)
+	} else {
+		logger.Error("email job moved to dead letter: ", err)
+	}
+
+	if err := w.EmailRepository.MarkEmailJobFailed(job, err.Error(), nextAttemptAt); err != nil {
+		logger.Error(err)
+	}
+}
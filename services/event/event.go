@@ -0,0 +1,126 @@
+package event
+
+import (
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ardiantirta/go-user-management/models"
+)
+
+const (
+	TypeRegistered                = "registered"
+	TypeEmailVerified             = "email_verified"
+	TypeLoginSucceeded            = "login_succeeded"
+	TypeLoginFailed               = "login_failed"
+	TypeTFAVerified               = "tfa_verified"
+	TypeTFAFailed                 = "tfa_failed"
+	TypeTFABackupCodeUsed         = "tfa_backup_code_used"
+	TypeTFABackupCodesRegenerated = "tfa_backup_codes_regenerated"
+	TypeTFAEnabled                = "tfa_enabled"
+	TypeTFADisabled               = "tfa_disabled"
+	TypePasswordChanged           = "password_changed"
+	TypePasswordReset             = "password_reset"
+	TypePasswordCompromised       = "password_compromised"
+	TypeEmailChangeRequested      = "email_change_requested"
+	TypeEmailChanged              = "email_changed"
+	TypeEmailChangeCancelled      = "email_change_cancelled"
+	TypeSessionRevoked            = "session_revoked"
+	TypeOtherSessionsRevoked      = "other_sessions_revoked"
+	TypeWebAuthnCredentialAdded   = "webauthn_credential_added"
+	TypeWebAuthnCredentialRemoved = "webauthn_credential_removed"
+	TypeAccountLocked             = "account_locked"
+	TypeAccountUnlocked           = "account_unlocked"
+	TypeAccountDeleted            = "account_deleted"
+	TypeRoleAssigned              = "role_assigned"
+	TypeRoleUnassigned            = "role_unassigned"
+	TypeAccountCreated            = "account_created"
+	TypeAccountUpdated            = "account_updated"
+	TypeAccountVerified           = "account_verified"
+	TypeAccountDeactivated        = "account_deactivated"
+	TypeAccountReactivated        = "account_reactivated"
+	TypePasswordResetRequired     = "password_reset_required"
+	TypeTFAReset                  = "tfa_reset"
+	TypeOrganizationCreated       = "organization_created"
+	TypeOrganizationDeleted       = "organization_deleted"
+	TypeOrganizationJoined        = "organization_joined"
+	TypeOrganizationLeft          = "organization_left"
+	TypeOrganizationRoleChanged   = "organization_role_changed"
+	TypeInvitationSent            = "organization_invitation_sent"
+	TypeInvitationRevoked         = "organization_invitation_revoked"
+	TypeInvitationAccepted        = "organization_invitation_accepted"
+	TypeGroupJoined               = "group_joined"
+	TypeGroupLeft                 = "group_left"
+)
+
+var Types = []string{
+	TypeRegistered,
+	TypeEmailVerified,
+	TypeLoginSucceeded,
+	TypeLoginFailed,
+	TypeTFAVerified,
+	TypeTFAFailed,
+	TypeTFABackupCodeUsed,
+	TypeTFABackupCodesRegenerated,
+	TypeTFAEnabled,
+	TypeTFADisabled,
+	TypePasswordChanged,
+	TypePasswordReset,
+	TypePasswordCompromised,
+	TypeEmailChangeRequested,
+	TypeEmailChanged,
+	TypeEmailChangeCancelled,
+	TypeSessionRevoked,
+	TypeOtherSessionsRevoked,
+	TypeWebAuthnCredentialAdded,
+	TypeWebAuthnCredentialRemoved,
+	TypeAccountLocked,
+	TypeAccountUnlocked,
+	TypeAccountDeleted,
+	TypeRoleAssigned,
+	TypeRoleUnassigned,
+	TypeAccountCreated,
+	TypeAccountUpdated,
+	TypeAccountVerified,
+	TypeAccountDeactivated,
+	TypeAccountReactivated,
+	TypePasswordResetRequired,
+	TypeTFAReset,
+	TypeOrganizationCreated,
+	TypeOrganizationDeleted,
+	TypeOrganizationJoined,
+	TypeOrganizationLeft,
+	TypeOrganizationRoleChanged,
+	TypeInvitationSent,
+	TypeInvitationRevoked,
+	TypeInvitationAccepted,
+	TypeGroupJoined,
+	TypeGroupLeft,
+}
+
+// Record appends an event for the user. Failing to write the audit log must
+// not fail the action being audited, so errors are only logged.
+func Record(repository Repository, userID int, eventType string, meta *models.RequestMetadata, data map[string]interface{}) {
+	userEvent := new(models.UserEvent)
+	userEvent.UserID = userID
+	userEvent.Type = eventType
+
+	if meta != nil {
+		userEvent.IPAddress = meta.IPAddress
+		userEvent.UserAgent = meta.UserAgent
+	}
+
+	if len(data) > 0 {
+		encoded, err := json.Marshal(data)
+		if err == nil {
+			userEvent.Data = string(encoded)
+		}
+	}
+
+	if err := repository.CreateEvent(userEvent); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user_id": userID,
+			"type":    eventType,
+		}).Error(err)
+	}
+}
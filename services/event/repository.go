@@ -0,0 +1,8 @@
+package event
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Repository interface {
+	CreateEvent(event *models.UserEvent) error
+	FetchEvents(userID int, filter *models.EventFilter) ([]*models.UserEvent, int, error)
+}
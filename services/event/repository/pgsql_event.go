@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/event"
+)
+
+type EventRepository struct {
+	Conn *gorm.DB
+}
+
+func (e EventRepository) CreateEvent(userEvent *models.UserEvent) error {
+	if err := e.Conn.Create(&userEvent).Error; err != nil {
+		return errors.New("failed to record event")
+	}
+
+	return nil
+}
+
+func (e EventRepository) FetchEvents(userID int, filter *models.EventFilter) ([]*models.UserEvent, int, error) {
+	events := make([]*models.UserEvent, 0)
+	total := 0
+
+	query := e.Conn.Table("user_events").
+		Where("user_id = ?", userID)
+
+	if len(filter.Types) > 0 {
+		query = query.Where("type in (?)", filter.Types)
+	}
+
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, errors.New("failed to get events")
+	}
+
+	if err := query.
+		Order("created_at desc, id desc").
+		Offset((filter.Page - 1) * filter.PerPage).
+		Limit(filter.PerPage).
+		Find(&events).Error; err != nil {
+		return nil, 0, errors.New("failed to get events")
+	}
+
+	return events, total, nil
+}
+
+func NewEventRepository(conn *gorm.DB) event.Repository {
+	return &EventRepository{
+		Conn: conn,
+	}
+}
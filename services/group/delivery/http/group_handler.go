@@ -0,0 +1,179 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/middleware"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/group"
+	"github.com/ardiantirta/go-user-management/services/rbac"
+)
+
+type GroupHandler struct {
+	GroupService group.Service
+}
+
+func NewGroupHandler(r *mux.Router, groupService group.Service) {
+	handler := GroupHandler{
+		GroupService: groupService,
+	}
+
+	r.Handle("/me/groups", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.JwtAuthentication(middleware.TwoFactorAuthentication(middleware.RateLimit("me")(http.HandlerFunc(handler.UserGroups)))))).
+		Methods(http.MethodGet)
+
+	v1 := r.PathPrefix("/admin/groups").Subrouter()
+
+	v1.Use(middleware.JwtAuthentication)
+	v1.Use(middleware.TwoFactorAuthentication)
+	v1.Use(middleware.RequirePermission(rbac.PermissionGroupsManage))
+
+	v1.Handle("", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Groups))).Methods(http.MethodGet)
+	v1.Handle("", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.CreateGroup))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Group))).Methods(http.MethodGet)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.UpdateGroup))).Methods(http.MethodPut)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.DeleteGroup))).Methods(http.MethodDelete)
+	v1.Handle("/{id:[0-9]+}/users", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Members))).Methods(http.MethodGet)
+	v1.Handle("/{id:[0-9]+}/users", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.AddMember))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}/users/{user_id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.RemoveMember))).Methods(http.MethodDelete)
+}
+
+func (h *GroupHandler) UserGroups(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+
+	response, err := h.GroupService.UserGroups(userID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *GroupHandler) Groups(w http.ResponseWriter, r *http.Request) {
+	response, err := h.GroupService.Groups()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *GroupHandler) Group(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.GroupService.Group(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	formData := new(models.GroupForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := h.GroupService.CreateGroup(formData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *GroupHandler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	formData := new(models.GroupForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := h.GroupService.UpdateGroup(id, formData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *GroupHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.GroupService.DeleteGroup(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *GroupHandler) Members(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.GroupService.Members(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *GroupHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	formData := new(models.RoleAssignmentForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	if formData.UserID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "user_id is required"))
+		return
+	}
+
+	response, err := h.GroupService.AddMember(id, formData.UserID, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *GroupHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	userID, _ := strconv.Atoi(mux.Vars(r)["user_id"])
+
+	response, err := h.GroupService.RemoveMember(id, userID, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
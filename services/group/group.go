@@ -0,0 +1,84 @@
+package group
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/ardiantirta/go-user-management/models"
+)
+
+// Scope lets an oauth client ask for the groups claim, tokens for this api
+// always carry it.
+const Scope = "groups"
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,63}$`)
+
+// IsValidName keeps names usable as claim values, downstream services match
+// on them.
+func IsValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+func parents(groups []*models.Group) map[uint]uint {
+	parent := make(map[uint]uint, len(groups))
+	for _, g := range groups {
+		parent[g.ID] = g.ParentID
+	}
+	return parent
+}
+
+// Expand adds every group ids are nested in, a member of a subgroup is also a
+// member of its parent groups.
+func Expand(groups []*models.Group, ids []uint) []uint {
+	parent := parents(groups)
+
+	seen := make(map[uint]bool)
+	expanded := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		for id != 0 && !seen[id] {
+			if _, ok := parent[id]; !ok {
+				break
+			}
+			seen[id] = true
+			expanded = append(expanded, id)
+			id = parent[id]
+		}
+	}
+
+	sort.Slice(expanded, func(i, j int) bool { return expanded[i] < expanded[j] })
+	return expanded
+}
+
+// IsDescendant reports whether id sits anywhere below ancestorID, nesting a
+// group under one of its own subgroups would make a loop.
+func IsDescendant(groups []*models.Group, id, ancestorID uint) bool {
+	parent := parents(groups)
+
+	seen := make(map[uint]bool)
+	for id != 0 && !seen[id] {
+		if id == ancestorID {
+			return true
+		}
+		seen[id] = true
+		id = parent[id]
+	}
+	return false
+}
+
+// Names returns the names of the groups with the given ids, sorted.
+func Names(groups []*models.Group, ids []uint) []string {
+	wanted := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	names := make([]string, 0, len(ids))
+	for _, g := range groups {
+		if wanted[g.ID] {
+			names = append(names, g.Name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,20 @@
+package group
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Repository interface {
+	FetchGroups() ([]*models.Group, error)
+	FetchGroupByID(id int) (*models.Group, error)
+	FetchGroupByName(name string) (*models.Group, error)
+	CreateGroup(group *models.Group, roles []string) error
+	UpdateGroup(group *models.Group, roles []string) error
+	DeleteGroup(group *models.Group) error
+	CountSubgroups(groupID uint) (int, error)
+	FetchGroupRoles(groupID uint) ([]string, error)
+
+	FetchGroupMembers(groupID uint) ([]*models.User, error)
+	FetchUserGroups(userID int) ([]*models.Group, error)
+	AddMember(groupID uint, userID int) error
+	RemoveMember(groupID uint, userID int) error
+	FetchUserByID(id int) (*models.User, error)
+}
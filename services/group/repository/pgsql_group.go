@@ -0,0 +1,272 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/group"
+)
+
+type GroupRepository struct {
+	Conn *gorm.DB
+}
+
+func (g GroupRepository) FetchGroups() ([]*models.Group, error) {
+	groups := make([]*models.Group, 0)
+
+	if err := g.Conn.Table("groups").
+		Where("deleted_at is null").
+		Order("name").
+		Find(&groups).Error; err != nil {
+		return nil, errors.New("failed to get groups")
+	}
+
+	return groups, nil
+}
+
+func (g GroupRepository) FetchGroupByID(id int) (*models.Group, error) {
+	grp := new(models.Group)
+
+	if err := g.Conn.Table("groups").
+		Where("id = ?", id).
+		Where("deleted_at is null").
+		First(&grp).Error; err != nil {
+		return nil, errors.New("group not found")
+	}
+
+	return grp, nil
+}
+
+func (g GroupRepository) FetchGroupByName(name string) (*models.Group, error) {
+	grp := new(models.Group)
+
+	if err := g.Conn.Table("groups").
+		Where("name = ?", name).
+		Where("deleted_at is null").
+		First(&grp).Error; err != nil {
+		return nil, errors.New("group not found")
+	}
+
+	return grp, nil
+}
+
+func (g GroupRepository) CreateGroup(grp *models.Group, roles []string) error {
+	tx := g.Conn.Begin()
+
+	if err := tx.Create(&grp).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to create group")
+	}
+
+	if err := setGroupRoles(tx, grp.ID, roles); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to create group")
+	}
+
+	return nil
+}
+
+func (g GroupRepository) UpdateGroup(grp *models.Group, roles []string) error {
+	tx := g.Conn.Begin()
+
+	if err := tx.Save(&grp).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to update group")
+	}
+
+	if err := setGroupRoles(tx, grp.ID, roles); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to update group")
+	}
+
+	return nil
+}
+
+func (g GroupRepository) DeleteGroup(grp *models.Group) error {
+	tx := g.Conn.Begin()
+
+	if err := tx.Unscoped().Table("group_members").
+		Where("group_id = ?", grp.ID).
+		Delete(models.GroupMember{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete group")
+	}
+
+	if err := tx.Unscoped().Table("group_roles").
+		Where("group_id = ?", grp.ID).
+		Delete(models.GroupRole{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete group")
+	}
+
+	if err := tx.Unscoped().Delete(&grp).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete group")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to delete group")
+	}
+
+	return nil
+}
+
+func (g GroupRepository) CountSubgroups(groupID uint) (int, error) {
+	count := 0
+
+	if err := g.Conn.Table("groups").
+		Where("parent_id = ?", groupID).
+		Where("deleted_at is null").
+		Count(&count).Error; err != nil {
+		return 0, errors.New("failed to count subgroups")
+	}
+
+	return count, nil
+}
+
+// setGroupRoles replaces the roles granted to the group, every name has to
+// exist in the roles table.
+func setGroupRoles(tx *gorm.DB, groupID uint, roles []string) error {
+	if err := tx.Unscoped().Table("group_roles").
+		Where("group_id = ?", groupID).
+		Delete(models.GroupRole{}).Error; err != nil {
+		return errors.New("failed to update group roles")
+	}
+
+	if len(roles) == 0 {
+		return nil
+	}
+
+	found := make([]*models.Role, 0)
+	if err := tx.Table("roles").
+		Where("name in (?)", roles).
+		Where("deleted_at is null").
+		Find(&found).Error; err != nil {
+		return errors.New("failed to update group roles")
+	}
+
+	for _, name := range roles {
+		known := false
+		for _, role := range found {
+			if role.Name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return errors.New("unknown role " + name)
+		}
+	}
+
+	for _, role := range found {
+		if err := tx.Create(&models.GroupRole{GroupID: groupID, RoleID: role.ID}).Error; err != nil {
+			return errors.New("failed to update group roles")
+		}
+	}
+
+	return nil
+}
+
+func (g GroupRepository) FetchGroupRoles(groupID uint) ([]string, error) {
+	names := make([]string, 0)
+
+	if err := g.Conn.Table("roles").
+		Joins("join group_roles on group_roles.role_id = roles.id").
+		Where("group_roles.group_id = ?", groupID).
+		Where("roles.deleted_at is null").
+		Order("roles.name").
+		Pluck("roles.name", &names).Error; err != nil {
+		return nil, errors.New("failed to get group roles")
+	}
+
+	return names, nil
+}
+
+func (g GroupRepository) FetchGroupMembers(groupID uint) ([]*models.User, error) {
+	users := make([]*models.User, 0)
+
+	if err := g.Conn.Table("users").
+		Select("users.*").
+		Joins("join group_members on group_members.user_id = users.id").
+		Where("group_members.group_id = ?", groupID).
+		Where("users.deleted_at is null").
+		Order("users.id").
+		Find(&users).Error; err != nil {
+		return nil, errors.New("failed to get group members")
+	}
+
+	return users, nil
+}
+
+// FetchUserGroups only returns the groups the user was added to, not the
+// ones those are nested in.
+func (g GroupRepository) FetchUserGroups(userID int) ([]*models.Group, error) {
+	groups := make([]*models.Group, 0)
+
+	if err := g.Conn.Table("groups").
+		Select("groups.*").
+		Joins("join group_members on group_members.group_id = groups.id").
+		Where("group_members.user_id = ?", userID).
+		Where("groups.deleted_at is null").
+		Order("groups.name").
+		Find(&groups).Error; err != nil {
+		return nil, errors.New("failed to get user groups")
+	}
+
+	return groups, nil
+}
+
+func (g GroupRepository) AddMember(groupID uint, userID int) error {
+	member := new(models.GroupMember)
+
+	if err := g.Conn.Where(models.GroupMember{GroupID: groupID, UserID: userID}).
+		FirstOrCreate(&member).Error; err != nil {
+		return errors.New("failed to add group member")
+	}
+
+	return nil
+}
+
+func (g GroupRepository) RemoveMember(groupID uint, userID int) error {
+	result := g.Conn.Table("group_members").
+		Where("group_id = ?", groupID).
+		Where("user_id = ?", userID).
+		Delete(models.GroupMember{})
+	if err := result.Error; err != nil {
+		return errors.New("failed to remove group member")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("user is not a member of this group")
+	}
+
+	return nil
+}
+
+func (g GroupRepository) FetchUserByID(id int) (*models.User, error) {
+	user := new(models.User)
+
+	if err := g.Conn.Table("users").
+		Where("id = ?", id).
+		First(&user).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	return user, nil
+}
+
+func NewGroupRepository(conn *gorm.DB) group.Repository {
+	return &GroupRepository{
+		Conn: conn,
+	}
+}
@@ -0,0 +1,16 @@
+package group
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Service interface {
+	Groups() (map[string]interface{}, error)
+	Group(id int) (map[string]interface{}, error)
+	CreateGroup(form *models.GroupForm) (map[string]interface{}, error)
+	UpdateGroup(id int, form *models.GroupForm) (map[string]interface{}, error)
+	DeleteGroup(id int) (map[string]interface{}, error)
+
+	Members(id int) (map[string]interface{}, error)
+	AddMember(id, userID int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	RemoveMember(id, userID int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	UserGroups(userID int) (map[string]interface{}, error)
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/event"
+	"github.com/ardiantirta/go-user-management/services/group"
+)
+
+type GroupService struct {
+	GroupRepository group.Repository
+	EventRepository event.Repository
+}
+
+func (s *GroupService) groupResponse(grp *models.Group) (map[string]interface{}, error) {
+	roles, err := s.GroupRepository.FetchGroupRoles(grp.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := map[string]interface{}{
+		"id":          grp.ID,
+		"name":        grp.Name,
+		"description": grp.Description,
+		"parent_id":   nil,
+		"roles":       roles,
+		"created_at":  grp.CreatedAt.Format(helper.FormatRFC8601),
+		"updated_at":  grp.UpdatedAt.Format(helper.FormatRFC8601),
+	}
+	if grp.ParentID != 0 {
+		response["parent_id"] = grp.ParentID
+	}
+
+	return response, nil
+}
+
+// validateParent makes sure the parent exists and that nesting grp under it
+// doesn't make a loop. New groups have no ID yet and can't be part of one.
+func (s *GroupService) validateParent(grp *models.Group, parentID uint) error {
+	if parentID == 0 {
+		return nil
+	}
+
+	if _, err := s.GroupRepository.FetchGroupByID(int(parentID)); err != nil {
+		return errors.New("parent group not found")
+	}
+
+	if grp.ID == 0 {
+		return nil
+	}
+
+	groups, err := s.GroupRepository.FetchGroups()
+	if err != nil {
+		return err
+	}
+
+	if group.IsDescendant(groups, parentID, grp.ID) {
+		return errors.New("a group can't be nested under itself or one of its subgroups")
+	}
+
+	return nil
+}
+
+func (s *GroupService) Groups() (map[string]interface{}, error) {
+	groups, err := s.GroupRepository.FetchGroups()
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, grp := range groups {
+		response, err := s.groupResponse(grp)
+		if err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+		list = append(list, response)
+	}
+
+	return map[string]interface{}{
+		"groups": list,
+	}, nil
+}
+
+func (s *GroupService) Group(id int) (map[string]interface{}, error) {
+	grp, err := s.GroupRepository.FetchGroupByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	response, err := s.groupResponse(grp)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return response, nil
+}
+
+func (s *GroupService) CreateGroup(form *models.GroupForm) (map[string]interface{}, error) {
+	name := strings.ToLower(strings.TrimSpace(form.Name))
+	if !group.IsValidName(name) {
+		err := errors.New("name must be 2 to 64 chars of lowercase letters, digits, - and _")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if _, err := s.GroupRepository.FetchGroupByName(name); err == nil {
+		err := errors.New("group already exist")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	grp := new(models.Group)
+	grp.Name = name
+	grp.Description = strings.TrimSpace(form.Description)
+	grp.ParentID = form.ParentID
+
+	if err := s.validateParent(grp, form.ParentID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.GroupRepository.CreateGroup(grp, form.Roles); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return s.Group(int(grp.ID))
+}
+
+// UpdateGroup replaces the description, parent and roles. Names are fixed
+// once created since downstream services match on the groups claim.
+func (s *GroupService) UpdateGroup(id int, form *models.GroupForm) (map[string]interface{}, error) {
+	grp, err := s.GroupRepository.FetchGroupByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.validateParent(grp, form.ParentID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	grp.Description = strings.TrimSpace(form.Description)
+	grp.ParentID = form.ParentID
+
+	if err := s.GroupRepository.UpdateGroup(grp, form.Roles); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return s.Group(id)
+}
+
+func (s *GroupService) DeleteGroup(id int) (map[string]interface{}, error) {
+	grp, err := s.GroupRepository.FetchGroupByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	count, err := s.GroupRepository.CountSubgroups(grp.ID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+	if count > 0 {
+		err := errors.New("group has subgroups, move or delete them first")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.GroupRepository.DeleteGroup(grp); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+// Members lists who was added to the group itself, members of its subgroups
+// aren't included.
+func (s *GroupService) Members(id int) (map[string]interface{}, error) {
+	grp, err := s.GroupRepository.FetchGroupByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	users, err := s.GroupRepository.FetchGroupMembers(grp.ID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, u := range users {
+		list = append(list, map[string]interface{}{
+			"id":        u.ID,
+			"email":     u.Email,
+			"full_name": u.FullName,
+		})
+	}
+
+	return map[string]interface{}{
+		"users": list,
+	}, nil
+}
+
+// AddMember takes effect on the user's next token, like assigning a role.
+func (s *GroupService) AddMember(id, userID int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	grp, err := s.GroupRepository.FetchGroupByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if _, err := s.GroupRepository.FetchUserByID(userID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.GroupRepository.AddMember(grp.ID, userID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, userID, event.TypeGroupJoined, meta, map[string]interface{}{"group": grp.Name})
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func (s *GroupService) RemoveMember(id, userID int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	grp, err := s.GroupRepository.FetchGroupByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.GroupRepository.RemoveMember(grp.ID, userID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, userID, event.TypeGroupLeft, meta, map[string]interface{}{"group": grp.Name})
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+// UserGroups lists the groups the user was added to along with the ones
+// those are nested in, marked as inherited.
+func (s *GroupService) UserGroups(userID int) (map[string]interface{}, error) {
+	direct, err := s.GroupRepository.FetchUserGroups(userID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	groups, err := s.GroupRepository.FetchGroups()
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	isDirect := make(map[uint]bool, len(direct))
+	ids := make([]uint, 0, len(direct))
+	for _, grp := range direct {
+		isDirect[grp.ID] = true
+		ids = append(ids, grp.ID)
+	}
+
+	effective := make(map[uint]bool)
+	for _, id := range group.Expand(groups, ids) {
+		effective[id] = true
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, grp := range groups {
+		if !effective[grp.ID] {
+			continue
+		}
+
+		response, err := s.groupResponse(grp)
+		if err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+		response["inherited"] = !isDirect[grp.ID]
+		list = append(list, response)
+	}
+
+	return map[string]interface{}{
+		"groups": list,
+	}, nil
+}
+
+func NewGroupService(groupRepository group.Repository, eventRepository event.Repository) group.Service {
+	return &GroupService{
+		GroupRepository: groupRepository,
+		EventRepository: eventRepository,
+	}
+}
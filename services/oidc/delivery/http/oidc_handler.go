@@ -0,0 +1,220 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/middleware"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/oidc"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type OIDCHandler struct {
+	OIDCService oidc.Service
+}
+
+func NewOIDCHandler(r *mux.Router, oidcService oidc.Service) {
+	handler := &OIDCHandler{
+		OIDCService: oidcService,
+	}
+
+	r.Handle("/.well-known/openid-configuration", handlers.LoggingHandler(
+		os.Stdout,
+		http.HandlerFunc(handler.Discovery))).
+		Methods(http.MethodGet)
+	r.Handle("/userinfo", handlers.LoggingHandler(
+		os.Stdout,
+		http.HandlerFunc(handler.UserInfo))).
+		Methods(http.MethodGet, http.MethodPost)
+
+	v1 := r.PathPrefix("/oauth").Subrouter()
+
+	v1.Handle("/authorize", handlers.LoggingHandler(
+		os.Stdout,
+		http.HandlerFunc(handler.Authorize))).
+		Methods(http.MethodGet)
+	v1.Handle("/authorize", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.JwtAuthentication(middleware.TwoFactorAuthentication(http.HandlerFunc(handler.ApproveAuthorization))))).
+		Methods(http.MethodPost)
+	v1.Handle("/token", handlers.LoggingHandler(
+		os.Stdout,
+		http.HandlerFunc(handler.Token))).
+		Methods(http.MethodPost)
+	v1.Handle("/consents", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.JwtAuthentication(middleware.TwoFactorAuthentication(http.HandlerFunc(handler.Consents))))).
+		Methods(http.MethodGet)
+	v1.Handle("/consents/{client_id}", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.JwtAuthentication(middleware.TwoFactorAuthentication(http.HandlerFunc(handler.RevokeConsent))))).
+		Methods(http.MethodDelete)
+}
+
+func (o *OIDCHandler) Discovery(w http.ResponseWriter, r *http.Request) {
+	response, err := o.OIDCService.Discovery()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		helper.Response(w, response)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	helper.Response(w, response)
+	return
+}
+
+func authorizeFormFromQuery(query url.Values) *models.AuthorizeForm {
+	return &models.AuthorizeForm{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+		Prompt:              query.Get("prompt"),
+	}
+}
+
+func (o *OIDCHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	formData := authorizeFormFromQuery(r.URL.Query())
+
+	// errors about the client or redirect_uri must not be sent back to an
+	// unverified redirect_uri, so the service only returns a redirect when
+	// the target is trusted
+	redirectTo, response, _ := o.OIDCService.Authorize(formData)
+	if redirectTo == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, response)
+		return
+	}
+
+	http.Redirect(w, r, redirectTo, http.StatusFound)
+	return
+}
+
+func (o *OIDCHandler) ApproveAuthorization(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "something is missing, try re-login"))
+		return
+	}
+
+	formData := new(models.AuthorizeForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
+	response, err := o.OIDCService.ApproveAuthorization(id, tokenString, formData, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (o *OIDCHandler) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, map[string]interface{}{
+			"error":             "invalid_request",
+			"error_description": "invalid form body",
+		})
+		return
+	}
+
+	formData := &models.TokenForm{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+	}
+
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		formData.ClientID, _ = url.QueryUnescape(clientID)
+		formData.ClientSecret, _ = url.QueryUnescape(clientSecret)
+	}
+
+	response, err := o.OIDCService.Token(formData)
+	if err != nil {
+		if response["error"] == "invalid_client" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (o *OIDCHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	response, err := o.OIDCService.UserInfo(tokenString)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="`+response["error"].(string)+`"`)
+		if response["error"] == "insufficient_scope" {
+			w.WriteHeader(http.StatusForbidden)
+		} else {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (o *OIDCHandler) Consents(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "something is missing, try re-login"))
+		return
+	}
+
+	response, err := o.OIDCService.Consents(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (o *OIDCHandler) RevokeConsent(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "something is missing, try re-login"))
+		return
+	}
+
+	response, err := o.OIDCService.RevokeConsent(id, mux.Vars(r)["client_id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
@@ -0,0 +1,14 @@
+package oidc
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Repository interface {
+	FetchClient(clientID string) (*models.Client, error)
+	FetchConsent(userID int, clientID string) (*models.OauthConsent, error)
+	FetchConsentsByUserID(userID int) ([]*models.OauthConsent, error)
+	SaveConsent(consent *models.OauthConsent) error
+	DeleteConsent(userID int, clientID string) error
+	CreateAuthorizationCode(code *models.OauthAuthorizationCode) error
+	FetchAuthorizationCode(code string) (*models.OauthAuthorizationCode, error)
+	MarkAuthorizationCodeUsed(id uint, familyID string) error
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/oidc"
+)
+
+type OIDCRepository struct {
+	Conn *gorm.DB
+}
+
+func (o OIDCRepository) FetchClient(clientID string) (*models.Client, error) {
+	client := new(models.Client)
+
+	if err := o.Conn.Table("clients").
+		Where("client_id = ?", clientID).
+		Where("is_active = 1").
+		Where("deleted_at is null").
+		First(&client).Error; err != nil {
+		return nil, errors.New("client not found")
+	}
+
+	return client, nil
+}
+
+func (o OIDCRepository) FetchConsent(userID int, clientID string) (*models.OauthConsent, error) {
+	consent := new(models.OauthConsent)
+
+	if err := o.Conn.Table("oauth_consents").
+		Where("user_id = ?", userID).
+		Where("client_id = ?", clientID).
+		First(&consent).Error; err != nil {
+		return nil, errors.New("consent not found")
+	}
+
+	return consent, nil
+}
+
+func (o OIDCRepository) FetchConsentsByUserID(userID int) ([]*models.OauthConsent, error) {
+	consents := make([]*models.OauthConsent, 0)
+
+	if err := o.Conn.Table("oauth_consents").
+		Where("user_id = ?", userID).
+		Order("id").
+		Find(&consents).Error; err != nil {
+		return nil, errors.New("failed to get consents")
+	}
+
+	return consents, nil
+}
+
+func (o OIDCRepository) SaveConsent(consent *models.OauthConsent) error {
+	if err := o.Conn.Save(&consent).Error; err != nil {
+		return errors.New("failed to save consent")
+	}
+
+	return nil
+}
+
+func (o OIDCRepository) DeleteConsent(userID int, clientID string) error {
+	result := o.Conn.Unscoped().Table("oauth_consents").
+		Where("user_id = ?", userID).
+		Where("client_id = ?", clientID).
+		Delete(models.OauthConsent{})
+	if err := result.Error; err != nil {
+		return errors.New("failed to delete consent")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("consent not found")
+	}
+
+	return nil
+}
+
+func (o OIDCRepository) CreateAuthorizationCode(code *models.OauthAuthorizationCode) error {
+	if err := o.Conn.Create(&code).Error; err != nil {
+		return errors.New("failed to create authorization code")
+	}
+
+	return nil
+}
+
+func (o OIDCRepository) FetchAuthorizationCode(code string) (*models.OauthAuthorizationCode, error) {
+	authorizationCode := new(models.OauthAuthorizationCode)
+
+	if err := o.Conn.Table("oauth_authorization_codes").
+		Where("code = ?", code).
+		First(&authorizationCode).Error; err != nil {
+		return nil, errors.New("authorization code not found")
+	}
+
+	return authorizationCode, nil
+}
+
+func (o OIDCRepository) MarkAuthorizationCodeUsed(id uint, familyID string) error {
+	result := o.Conn.Table("oauth_authorization_codes").
+		Where("id = ?", id).
+		Where("used_at is null").
+		Updates(map[string]interface{}{"used_at": time.Now().UTC(), "family_id": familyID})
+	if err := result.Error; err != nil {
+		return errors.New("failed to use authorization code")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("authorization code was already used")
+	}
+
+	return nil
+}
+
+func NewPgsqlOIDCRepository(conn *gorm.DB) oidc.Repository {
+	return &OIDCRepository{
+		Conn: conn,
+	}
+}
@@ -0,0 +1,13 @@
+package oidc
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Service interface {
+	Discovery() (map[string]interface{}, error)
+	Authorize(form *models.AuthorizeForm) (string, map[string]interface{}, error)
+	ApproveAuthorization(userID int, currentToken string, form *models.AuthorizeForm, meta *models.RequestMetadata) (map[string]interface{}, error)
+	Token(form *models.TokenForm) (map[string]interface{}, error)
+	UserInfo(accessToken string) (map[string]interface{}, error)
+	Consents(userID int) (map[string]interface{}, error)
+	RevokeConsent(userID int, clientID string) (map[string]interface{}, error)
+}
@@ -0,0 +1,608 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/spf13/viper"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/passwordhash"
+	"github.com/ardiantirta/go-user-management/passwordpolicy"
+	"github.com/ardiantirta/go-user-management/services/auth"
+	"github.com/ardiantirta/go-user-management/services/group"
+	"github.com/ardiantirta/go-user-management/services/oidc"
+	"github.com/ardiantirta/go-user-management/signing"
+)
+
+const (
+	ScopeOpenID        = "openid"
+	ScopeProfile       = "profile"
+	ScopeEmail         = "email"
+	ScopeOfflineAccess = "offline_access"
+	ScopeGroups        = group.Scope
+)
+
+var supportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopeOfflineAccess, ScopeGroups}
+
+type OIDCService struct {
+	OIDCRepository oidc.Repository
+	AuthRepository auth.Repository
+}
+
+type authorizeError struct {
+	code        string
+	description string
+	redirect    bool
+}
+
+func oauthError(code, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"error":             code,
+		"error_description": description,
+	}
+}
+
+func issuer() string {
+	return strings.TrimRight(viper.GetString("oidc.issuer"), "/")
+}
+
+func codeTTL() time.Duration {
+	if ttl := viper.GetDuration("oidc.code_ttl"); ttl > 0 {
+		return ttl
+	}
+	return time.Minute
+}
+
+// loginMaxAge is how long ago the user may have logged in for a request with
+// prompt=login to be approved without logging in again.
+func loginMaxAge() time.Duration {
+	if maxAge := viper.GetDuration("oidc.login_max_age"); maxAge > 0 {
+		return maxAge
+	}
+	return 5 * time.Minute
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func parseScopes(scope string) []string {
+	scopes := make([]string, 0)
+	for _, s := range strings.Fields(scope) {
+		if hasScope(supportedScopes, s) && !hasScope(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+func withQuery(rawURL string, params map[string]string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := u.Query()
+	for k, v := range params {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+func (o *OIDCService) Discovery() (map[string]interface{}, error) {
+	base := issuer()
+
+	return map[string]interface{}{
+		"issuer":                                base,
+		"authorization_endpoint":                base + "/oauth/authorize",
+		"token_endpoint":                        base + "/oauth/token",
+		"userinfo_endpoint":                     base + "/userinfo",
+		"jwks_uri":                              base + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query"},
+		"grant_types_supported":                 []string{helper.GrantTypeAuthorizationCode, helper.GrantTypeRefreshToken},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": signing.AllowedAlgorithms(),
+		"scopes_supported":                      supportedScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "picture", "website", "updated_at", "email", "email_verified", "groups",
+		},
+	}, nil
+}
+
+func (o *OIDCService) validateAuthorizeRequest(form *models.AuthorizeForm) (*models.Client, []string, *authorizeError) {
+	client, err := o.OIDCRepository.FetchClient(form.ClientID)
+	if err != nil {
+		return nil, nil, &authorizeError{code: "invalid_request", description: "unknown client_id"}
+	}
+
+	if !client.AllowsRedirectURI(form.RedirectURI) {
+		return nil, nil, &authorizeError{code: "invalid_request", description: "redirect_uri is not registered for this client"}
+	}
+
+	if !client.AllowsGrantType(helper.GrantTypeAuthorizationCode) {
+		return nil, nil, &authorizeError{code: "unauthorized_client", description: "client is not allowed to use the authorization code flow", redirect: true}
+	}
+
+	if form.ResponseType != "code" {
+		return nil, nil, &authorizeError{code: "unsupported_response_type", description: "only response_type=code is supported", redirect: true}
+	}
+
+	scopes := parseScopes(form.Scope)
+	if !hasScope(scopes, ScopeOpenID) {
+		return nil, nil, &authorizeError{code: "invalid_scope", description: "scope must contain openid", redirect: true}
+	}
+
+	if form.CodeChallengeMethod != "S256" || len(form.CodeChallenge) < 43 || len(form.CodeChallenge) > 128 {
+		return nil, nil, &authorizeError{code: "invalid_request", description: "code_challenge with code_challenge_method=S256 is required", redirect: true}
+	}
+
+	switch form.Prompt {
+	case "", "none", "login", "consent":
+	default:
+		return nil, nil, &authorizeError{code: "invalid_request", description: "unsupported prompt value", redirect: true}
+	}
+
+	return client, scopes, nil
+}
+
+func (o *OIDCService) errorRedirect(form *models.AuthorizeForm, e *authorizeError) string {
+	return withQuery(form.RedirectURI, map[string]string{
+		"error":             e.code,
+		"error_description": e.description,
+		"state":             form.State,
+	})
+}
+
+func (o *OIDCService) Authorize(form *models.AuthorizeForm) (string, map[string]interface{}, error) {
+	if _, _, e := o.validateAuthorizeRequest(form); e != nil {
+		if e.redirect {
+			return o.errorRedirect(form, e), nil, errors.New(e.description)
+		}
+		return "", oauthError(e.code, e.description), errors.New(e.description)
+	}
+
+	loginURL := withQuery(viper.GetString("oidc.login_url"), map[string]string{
+		"response_type":         form.ResponseType,
+		"client_id":             form.ClientID,
+		"redirect_uri":          form.RedirectURI,
+		"scope":                 form.Scope,
+		"state":                 form.State,
+		"nonce":                 form.Nonce,
+		"code_challenge":        form.CodeChallenge,
+		"code_challenge_method": form.CodeChallengeMethod,
+		"prompt":                form.Prompt,
+	})
+
+	return loginURL, nil, nil
+}
+
+func (o *OIDCService) ApproveAuthorization(userID int, currentToken string, form *models.AuthorizeForm, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	client, scopes, e := o.validateAuthorizeRequest(form)
+	if e != nil {
+		if e.redirect {
+			return map[string]interface{}{"redirect_to": o.errorRedirect(form, e)}, nil
+		}
+		return oauthError(e.code, e.description), errors.New(e.description)
+	}
+
+	userToken, err := o.AuthRepository.FetchUserToken(userID, currentToken)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	// auth_time is when the user entered their credentials, which a token
+	// refresh or the TFA step carries over from the login
+	authTime := userToken.CreatedAt
+	if userToken.SessionStartedAt != nil {
+		authTime = *userToken.SessionStartedAt
+	}
+
+	if form.Prompt == "login" && time.Now().UTC().Sub(authTime) > loginMaxAge() {
+		return map[string]interface{}{
+			"login_required": true,
+			"client": map[string]interface{}{
+				"client_id": client.ClientID,
+				"name":      client.Name,
+			},
+		}, nil
+	}
+
+	consent, err := o.OIDCRepository.FetchConsent(userID, client.ClientID)
+	if err != nil {
+		consent = new(models.OauthConsent)
+		consent.UserID = userID
+		consent.ClientID = client.ClientID
+	}
+
+	granted := form.Prompt != "consent"
+	grantedScopes := strings.Fields(consent.Scope)
+	for _, s := range scopes {
+		if !hasScope(grantedScopes, s) {
+			granted = false
+			break
+		}
+	}
+
+	if !granted {
+		if form.Approve == nil {
+			if form.Prompt == "none" {
+				return map[string]interface{}{
+					"redirect_to": o.errorRedirect(form, &authorizeError{code: "consent_required", description: "user consent is required"}),
+				}, nil
+			}
+
+			return map[string]interface{}{
+				"consent_required": true,
+				"client": map[string]interface{}{
+					"client_id": client.ClientID,
+					"name":      client.Name,
+				},
+				"scopes": scopes,
+			}, nil
+		}
+
+		if !*form.Approve {
+			return map[string]interface{}{
+				"redirect_to": o.errorRedirect(form, &authorizeError{code: "access_denied", description: "user denied the request"}),
+			}, nil
+		}
+
+		for _, s := range scopes {
+			if !hasScope(grantedScopes, s) {
+				grantedScopes = append(grantedScopes, s)
+			}
+		}
+
+		consent.Scope = strings.Join(grantedScopes, " ")
+		if err := o.OIDCRepository.SaveConsent(consent); err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	}
+
+	code, err := helper.GenerateOpaqueToken()
+	if err != nil {
+		return helper.ErrorMessage(0, "failed to create authorization code"), err
+	}
+
+	now := time.Now().UTC()
+
+	authorizationCode := new(models.OauthAuthorizationCode)
+	authorizationCode.Code = helper.HashToken(code)
+	authorizationCode.ClientID = client.ClientID
+	authorizationCode.UserID = userID
+	authorizationCode.RedirectURI = form.RedirectURI
+	authorizationCode.Scope = strings.Join(scopes, " ")
+	authorizationCode.Nonce = form.Nonce
+	authorizationCode.CodeChallenge = form.CodeChallenge
+	authorizationCode.CodeChallengeMethod = form.CodeChallengeMethod
+	authorizationCode.UserAgent = meta.UserAgent
+	authorizationCode.IPAddress = meta.IPAddress
+	authorizationCode.AuthTime = authTime.UTC()
+	authorizationCode.ExpiredAt = now.Add(codeTTL())
+	if err := o.OIDCRepository.CreateAuthorizationCode(authorizationCode); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"redirect_to": withQuery(form.RedirectURI, map[string]string{
+			"code":  code,
+			"state": form.State,
+		}),
+	}, nil
+}
+
+func (o *OIDCService) authenticateClient(form *models.TokenForm) (*models.Client, error) {
+	client, err := o.OIDCRepository.FetchClient(form.ClientID)
+	if err != nil {
+		return nil, errors.New("unknown client")
+	}
+
+	if client.IsConfidential() {
+		if ok, _ := passwordhash.Verify(client.SecretHash, form.ClientSecret); !ok {
+			return nil, errors.New("invalid client credentials")
+		}
+	}
+
+	return client, nil
+}
+
+func (o *OIDCService) Token(form *models.TokenForm) (map[string]interface{}, error) {
+	client, err := o.authenticateClient(form)
+	if err != nil {
+		return oauthError("invalid_client", err.Error()), err
+	}
+
+	if form.GrantType != helper.GrantTypeAuthorizationCode && form.GrantType != helper.GrantTypeRefreshToken {
+		err := errors.New("grant_type must be authorization_code or refresh_token")
+		return oauthError("unsupported_grant_type", err.Error()), err
+	}
+
+	if !client.AllowsGrantType(form.GrantType) {
+		err := errors.New("client is not allowed to use grant_type " + form.GrantType)
+		return oauthError("unauthorized_client", err.Error()), err
+	}
+
+	if form.GrantType == helper.GrantTypeAuthorizationCode {
+		return o.exchangeAuthorizationCode(client, form)
+	}
+
+	return o.exchangeRefreshToken(client, form)
+}
+
+func (o *OIDCService) exchangeAuthorizationCode(client *models.Client, form *models.TokenForm) (map[string]interface{}, error) {
+	authorizationCode, err := o.OIDCRepository.FetchAuthorizationCode(helper.HashToken(form.Code))
+	if err != nil || authorizationCode.ClientID != client.ClientID {
+		err := errors.New("invalid authorization code")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	if authorizationCode.UsedAt != nil {
+		if authorizationCode.FamilyID != "" {
+			_ = o.AuthRepository.RevokeTokenFamily(authorizationCode.FamilyID)
+		}
+		err := errors.New("authorization code was already used")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	if authorizationCode.ExpiredAt.Before(time.Now().UTC()) {
+		err := errors.New("authorization code expired")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	if authorizationCode.RedirectURI != form.RedirectURI {
+		err := errors.New("redirect_uri does not match the authorization request")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	if len(form.CodeVerifier) < 43 || len(form.CodeVerifier) > 128 {
+		err := errors.New("invalid code_verifier")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	digest := sha256.Sum256([]byte(form.CodeVerifier))
+	challenge := base64.RawURLEncoding.EncodeToString(digest[:])
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(authorizationCode.CodeChallenge)) != 1 {
+		err := errors.New("invalid code_verifier")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	familyID := helper.GenerateRandomCode()
+	if err := o.OIDCRepository.MarkAuthorizationCodeUsed(authorizationCode.ID, familyID); err != nil {
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	user, err := o.AuthRepository.FetchUserByID(authorizationCode.UserID)
+	if err != nil || user.IsActive != 1 || user.DeactivatedAt != nil {
+		err := errors.New("user is not active")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	if user.PasswordResetRequired == 1 {
+		err := errors.New("password reset is required")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	if passwordpolicy.Current().UserExpired(user) {
+		err := errors.New("password has expired")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	// the token endpoint is called by the client's backend, so the session is
+	// attributed to the browser that approved the authorization request
+	meta := &models.RequestMetadata{
+		UserAgent: authorizationCode.UserAgent,
+		IPAddress: authorizationCode.IPAddress,
+	}
+
+	scopes := strings.Fields(authorizationCode.Scope)
+	issued, err := o.AuthRepository.IssueClientToken(user, familyID, client.ClientID, authorizationCode.Scope, hasScope(scopes, ScopeOfflineAccess), meta)
+	if err != nil {
+		return oauthError("server_error", err.Error()), err
+	}
+
+	return o.tokenResponse(user, client, issued, scopes, authorizationCode.Nonce, authorizationCode.AuthTime)
+}
+
+func (o *OIDCService) exchangeRefreshToken(client *models.Client, form *models.TokenForm) (map[string]interface{}, error) {
+	user, userToken, err := o.AuthRepository.RotateRefreshToken(form.RefreshToken, client.ClientID)
+	if err != nil {
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	if user.IsActive != 1 || user.DeactivatedAt != nil {
+		err := errors.New("user is not active")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	if user.PasswordResetRequired == 1 {
+		err := errors.New("password reset is required")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	// the session ends until the password is changed and the user approves
+	// the client again
+	if passwordpolicy.Current().UserExpired(user) {
+		err := errors.New("password has expired")
+		return oauthError("invalid_grant", err.Error()), err
+	}
+
+	meta := &models.RequestMetadata{
+		UserAgent: userToken.UserAgent,
+		IPAddress: userToken.IPAddress,
+		StartedAt: userToken.SessionStartedAt,
+	}
+
+	issued, err := o.AuthRepository.IssueClientToken(user, userToken.FamilyID, client.ClientID, userToken.Scope, true, meta)
+	if err != nil {
+		return oauthError("server_error", err.Error()), err
+	}
+
+	return o.tokenResponse(user, client, issued, strings.Fields(userToken.Scope), "", time.Time{})
+}
+
+func (o *OIDCService) tokenResponse(user *models.User, client *models.Client, issued *models.IssuedToken, scopes []string, nonce string, authTime time.Time) (map[string]interface{}, error) {
+	now := time.Now().UTC()
+
+	claims, err := o.userClaims(user, scopes)
+	if err != nil {
+		return oauthError("server_error", err.Error()), err
+	}
+	claims["iss"] = issuer()
+	claims["aud"] = client.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = issued.AccessTokenExpiredAt.Unix()
+	if !authTime.IsZero() {
+		claims["auth_time"] = authTime.Unix()
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	idToken, err := signing.Sign(claims)
+	if err != nil {
+		return oauthError("server_error", "failed to sign id_token"), err
+	}
+
+	response := map[string]interface{}{
+		"access_token": issued.AccessToken,
+		"token_type":   helper.TokenTypeBearer,
+		"expires_in":   int(issued.AccessTokenExpiredAt.Sub(now).Seconds()),
+		"id_token":     idToken,
+		"scope":        strings.Join(scopes, " "),
+	}
+
+	if issued.RefreshToken != "" {
+		response["refresh_token"] = issued.RefreshToken
+	}
+
+	return response, nil
+}
+
+func (o *OIDCService) userClaims(user *models.User, scopes []string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{
+		"sub": strconv.Itoa(int(user.ID)),
+	}
+
+	if hasScope(scopes, ScopeProfile) {
+		claims["name"] = user.FullName
+		claims["picture"] = user.Picture
+		claims["website"] = user.Web
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+
+	if hasScope(scopes, ScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.IsVerified == 1
+	}
+
+	if hasScope(scopes, ScopeGroups) {
+		groups, err := o.AuthRepository.FetchUserGroupNames(int(user.ID))
+		if err != nil {
+			return nil, err
+		}
+		claims["groups"] = groups
+	}
+
+	return claims, nil
+}
+
+func (o *OIDCService) UserInfo(accessToken string) (map[string]interface{}, error) {
+	claims, err := signing.Parse(accessToken)
+	if err != nil {
+		err := errors.New("invalid access token")
+		return oauthError("invalid_token", err.Error()), err
+	}
+
+	id, _ := claims["id"].(float64)
+	scope, _ := claims["scope"].(string)
+	scopes := strings.Fields(scope)
+	if !hasScope(scopes, ScopeOpenID) {
+		err := errors.New("access token has no openid scope")
+		return oauthError("insufficient_scope", err.Error()), err
+	}
+
+	if _, err := o.AuthRepository.FetchUserToken(int(id), accessToken); err != nil {
+		err := errors.New("access token was revoked")
+		return oauthError("invalid_token", err.Error()), err
+	}
+
+	user, err := o.AuthRepository.FetchUserByID(int(id))
+	if err != nil {
+		return oauthError("invalid_token", err.Error()), err
+	}
+
+	claims, err = o.userClaims(user, scopes)
+	if err != nil {
+		return oauthError("server_error", err.Error()), err
+	}
+
+	return claims, nil
+}
+
+func (o *OIDCService) Consents(userID int) (map[string]interface{}, error) {
+	consents, err := o.OIDCRepository.FetchConsentsByUserID(userID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, c := range consents {
+		item := map[string]interface{}{
+			"client_id":  c.ClientID,
+			"name":       "",
+			"scopes":     strings.Fields(c.Scope),
+			"created_at": c.CreatedAt.Format(helper.FormatRFC8601),
+		}
+		if client, err := o.OIDCRepository.FetchClient(c.ClientID); err == nil {
+			item["name"] = client.Name
+		}
+		list = append(list, item)
+	}
+
+	return map[string]interface{}{
+		"consents": list,
+	}, nil
+}
+
+func (o *OIDCService) RevokeConsent(userID int, clientID string) (map[string]interface{}, error) {
+	if err := o.OIDCRepository.DeleteConsent(userID, clientID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := o.AuthRepository.RevokeClientTokens(userID, clientID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func NewOIDCService(oidcRepository oidc.Repository, authRepository auth.Repository) oidc.Service {
+	return &OIDCService{
+		OIDCRepository: oidcRepository,
+		AuthRepository: authRepository,
+	}
+}
@@ -0,0 +1,261 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/middleware"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/organization"
+)
+
+type OrganizationHandler struct {
+	OrganizationService organization.Service
+}
+
+func NewOrganizationHandler(r *mux.Router, organizationService organization.Service) {
+	handler := OrganizationHandler{
+		OrganizationService: organizationService,
+	}
+
+	v1 := r.PathPrefix("/organizations").Subrouter()
+
+	v1.Use(middleware.JwtAuthentication)
+	v1.Use(middleware.TwoFactorAuthentication)
+
+	v1.Handle("", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Organizations))).Methods(http.MethodGet)
+	v1.Handle("", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.CreateOrganization))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Organization))).Methods(http.MethodGet)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.UpdateOrganization))).Methods(http.MethodPut)
+	v1.Handle("/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.DeleteOrganization))).Methods(http.MethodDelete)
+	v1.Handle("/{id:[0-9]+}/switch", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.SwitchOrganization))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}/members", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Members))).Methods(http.MethodGet)
+	v1.Handle("/{id:[0-9]+}/members/{user_id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.UpdateMember))).Methods(http.MethodPut)
+	v1.Handle("/{id:[0-9]+}/members/{user_id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.RemoveMember))).Methods(http.MethodDelete)
+	v1.Handle("/{id:[0-9]+}/invitations", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Invitations))).Methods(http.MethodGet)
+	v1.Handle("/{id:[0-9]+}/invitations", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Invite))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}/invitations/{invitation_id:[0-9]+}/resend", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.ResendInvitation))).Methods(http.MethodPost)
+	v1.Handle("/{id:[0-9]+}/invitations/{invitation_id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.RevokeInvitation))).Methods(http.MethodDelete)
+	v1.Handle("/invitations/{code}/accept", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.AcceptInvitation))).Methods(http.MethodPost)
+}
+
+func (h *OrganizationHandler) Organizations(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+
+	response, err := h.OrganizationService.Organizations(userID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+
+	formData := new(models.OrganizationForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := h.OrganizationService.CreateOrganization(userID, formData, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) Organization(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.OrganizationService.Organization(userID, id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	formData := new(models.OrganizationForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := h.OrganizationService.UpdateOrganization(userID, id, formData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.OrganizationService.DeleteOrganization(userID, id, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) SwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	tfaVerified, _ := strconv.ParseBool(r.Header.Get("tfa_verified"))
+	tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
+
+	response, err := h.OrganizationService.SwitchOrganization(userID, id, tokenString, tfaVerified, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) Members(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.OrganizationService.Members(userID, id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	memberID, _ := strconv.Atoi(mux.Vars(r)["user_id"])
+
+	formData := new(models.OrganizationMemberForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := h.OrganizationService.UpdateMember(userID, id, memberID, formData.Role, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	memberID, _ := strconv.Atoi(mux.Vars(r)["user_id"])
+
+	response, err := h.OrganizationService.RemoveMember(userID, id, memberID, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) Invitations(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.OrganizationService.Invitations(userID, id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) Invite(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	formData := new(models.OrganizationInvitationForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := h.OrganizationService.Invite(userID, id, formData, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) ResendInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	invitationID, _ := strconv.Atoi(mux.Vars(r)["invitation_id"])
+
+	response, err := h.OrganizationService.ResendInvitation(userID, id, invitationID, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	invitationID, _ := strconv.Atoi(mux.Vars(r)["invitation_id"])
+
+	response, err := h.OrganizationService.RevokeInvitation(userID, id, invitationID, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *OrganizationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	code := mux.Vars(r)["code"]
+
+	response, err := h.OrganizationService.AcceptInvitation(userID, code, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
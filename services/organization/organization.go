@@ -0,0 +1,124 @@
+package organization
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ardiantirta/go-user-management/models"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+const (
+	PermissionOrganizationManage = "organization:manage"
+	PermissionMembersManage      = "members:manage"
+	PermissionMembersRead        = "members:read"
+)
+
+// RolePermissions is what each role can do inside its organization. These
+// are separate from the rbac permissions, which cover the whole api.
+var RolePermissions = map[string][]string{
+	RoleOwner:  {PermissionOrganizationManage, PermissionMembersManage, PermissionMembersRead},
+	RoleAdmin:  {PermissionMembersManage, PermissionMembersRead},
+	RoleMember: {PermissionMembersRead},
+}
+
+var (
+	slugPattern       = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}[a-z0-9]$`)
+	slugInvalidChars  = regexp.MustCompile(`[^a-z0-9]+`)
+	joinDomainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}$`)
+)
+
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+func HasPermission(role, permission string) bool {
+	for _, granted := range RolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Slugify turns a name into a slug, "Acme Corp." becomes "acme-corp".
+func Slugify(name string) string {
+	slug := slugInvalidChars.ReplaceAllString(strings.ToLower(name), "-")
+	slug = strings.Trim(slug, "-")
+	if len(slug) > 64 {
+		slug = strings.Trim(slug[:64], "-")
+	}
+	return slug
+}
+
+// Normalize trims the form and fills in the slug from the name when it's
+// missing.
+func Normalize(name, slug, joinDomain string) (string, string, string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 || len(name) > 128 {
+		return "", "", "", errors.New("organization name is required and must between 1 and 128 chars")
+	}
+
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if slug == "" {
+		slug = Slugify(name)
+	}
+	if !slugPattern.MatchString(slug) {
+		return "", "", "", errors.New("organization slug must be 3 to 64 chars of lowercase letters, digits and -")
+	}
+
+	joinDomain = strings.ToLower(strings.TrimSpace(joinDomain))
+	if joinDomain != "" && !joinDomainPattern.MatchString(joinDomain) {
+		return "", "", "", errors.New("join_domain must be a domain like example.com")
+	}
+
+	return name, slug, joinDomain, nil
+}
+
+// CanJoin reports whether an email address is allowed to join an
+// organization without being invited.
+func CanJoin(joinDomain, email string) bool {
+	at := strings.LastIndex(email, "@")
+	return joinDomain != "" && at >= 0 && strings.ToLower(email[at+1:]) == joinDomain
+}
+
+func InvitationStatus(invitation *models.OrganizationInvitation, now time.Time) string {
+	switch {
+	case invitation.AcceptedAt != nil:
+		return InvitationAccepted
+	case invitation.RevokedAt != nil:
+		return InvitationRevoked
+	case invitation.ExpiredAt != nil && now.After(*invitation.ExpiredAt):
+		return InvitationExpired
+	}
+	return InvitationPending
+}
+
+// InvitationError explains why an invitation can't be used anymore, it's nil
+// while the invitation is pending.
+func InvitationError(invitation *models.OrganizationInvitation, now time.Time) error {
+	switch InvitationStatus(invitation, now) {
+	case InvitationAccepted:
+		return errors.New("invitation is already accepted")
+	case InvitationRevoked:
+		return errors.New("invitation has been revoked")
+	case InvitationExpired:
+		return errors.New("invitation has expired, ask for a new one")
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package organization
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Repository interface {
+	FetchUserOrganizations(userID int) ([]*models.OrganizationMembership, error)
+	FetchOrganizationByID(id int) (*models.Organization, error)
+	FetchOrganizationBySlug(slug string) (*models.Organization, error)
+	CreateOrganization(org *models.Organization, ownerID int) error
+	SaveOrganization(org *models.Organization) error
+	DeleteOrganization(org *models.Organization) error
+
+	FetchMember(organizationID uint, userID int) (*models.OrganizationMember, error)
+	FetchMembers(organizationID uint) ([]*models.OrganizationMemberUser, error)
+	AddMember(member *models.OrganizationMember) error
+	SaveMember(member *models.OrganizationMember) error
+	RemoveMember(member *models.OrganizationMember) error
+	CountMembersWithRole(organizationID uint, role string) (int, error)
+	SetCurrentOrganization(userID int, familyID string, organizationID uint) error
+
+	FetchInvitations(organizationID uint) ([]*models.OrganizationInvitation, error)
+	FetchInvitation(organizationID uint, id int) (*models.OrganizationInvitation, error)
+	FetchInvitationByCode(code string) (*models.OrganizationInvitation, error)
+	FetchPendingInvitation(organizationID uint, email string) (*models.OrganizationInvitation, error)
+	CreateInvitation(invitation *models.OrganizationInvitation) (*models.UserVerificationCode, error)
+	RenewInvitation(invitation *models.OrganizationInvitation) (*models.UserVerificationCode, error)
+	RevokeInvitation(invitation *models.OrganizationInvitation) error
+	AcceptInvitation(invitation *models.OrganizationInvitation, userID int, verifyEmail bool) error
+}
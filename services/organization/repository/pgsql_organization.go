@@ -0,0 +1,524 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/organization"
+)
+
+type OrganizationRepository struct {
+	Conn *gorm.DB
+}
+
+func (o OrganizationRepository) FetchUserOrganizations(userID int) ([]*models.OrganizationMembership, error) {
+	memberships := make([]*models.OrganizationMembership, 0)
+
+	if err := o.Conn.Table("organizations").
+		Select("organizations.*, organization_members.role, organization_members.created_at as joined_at").
+		Joins("join organization_members on organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ?", userID).
+		Where("organizations.deleted_at is null").
+		Order("organizations.name").
+		Scan(&memberships).Error; err != nil {
+		return nil, errors.New("failed to get organizations")
+	}
+
+	return memberships, nil
+}
+
+func (o OrganizationRepository) FetchOrganizationByID(id int) (*models.Organization, error) {
+	org := new(models.Organization)
+
+	if err := o.Conn.Table("organizations").
+		Where("id = ?", id).
+		Where("deleted_at is null").
+		First(&org).Error; err != nil {
+		return nil, errors.New("organization not found")
+	}
+
+	return org, nil
+}
+
+func (o OrganizationRepository) FetchOrganizationBySlug(slug string) (*models.Organization, error) {
+	org := new(models.Organization)
+
+	if err := o.Conn.Table("organizations").
+		Where("slug = ?", slug).
+		Where("deleted_at is null").
+		First(&org).Error; err != nil {
+		return nil, errors.New("organization not found")
+	}
+
+	return org, nil
+}
+
+// CreateOrganization makes ownerID its owner and, when the owner has no
+// current organization yet, switches them to it.
+func (o OrganizationRepository) CreateOrganization(org *models.Organization, ownerID int) error {
+	tx := o.Conn.Begin()
+
+	if err := tx.Create(&org).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to create organization")
+	}
+
+	member := &models.OrganizationMember{OrganizationID: org.ID, UserID: ownerID, Role: organization.RoleOwner}
+	if err := addMember(tx, member); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to create organization")
+	}
+
+	return nil
+}
+
+func (o OrganizationRepository) SaveOrganization(org *models.Organization) error {
+	if err := o.Conn.Save(&org).Error; err != nil {
+		return errors.New("failed to update organization")
+	}
+
+	return nil
+}
+
+func (o OrganizationRepository) DeleteOrganization(org *models.Organization) error {
+	tx := o.Conn.Begin()
+
+	if err := tx.Table("organization_members").
+		Where("organization_id = ?", org.ID).
+		Delete(models.OrganizationMember{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete organization")
+	}
+
+	if err := tx.Unscoped().Table("organization_invitations").
+		Where("organization_id = ?", org.ID).
+		Delete(models.OrganizationInvitation{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete organization")
+	}
+
+	if err := clearCurrentOrganization(tx, org.ID, 0); err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete organization")
+	}
+
+	if err := tx.Unscoped().Delete(&org).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete organization")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to delete organization")
+	}
+
+	return nil
+}
+
+func (o OrganizationRepository) FetchMember(organizationID uint, userID int) (*models.OrganizationMember, error) {
+	member := new(models.OrganizationMember)
+
+	if err := o.Conn.Table("organization_members").
+		Joins("join organizations on organizations.id = organization_members.organization_id").
+		Select("organization_members.*").
+		Where("organization_members.organization_id = ?", organizationID).
+		Where("organization_members.user_id = ?", userID).
+		Where("organizations.deleted_at is null").
+		First(&member).Error; err != nil {
+		return nil, errors.New("organization not found")
+	}
+
+	return member, nil
+}
+
+func (o OrganizationRepository) FetchMembers(organizationID uint) ([]*models.OrganizationMemberUser, error) {
+	members := make([]*models.OrganizationMemberUser, 0)
+
+	if err := o.Conn.Table("organization_members").
+		Select("organization_members.*, users.email, users.full_name").
+		Joins("join users on users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ?", organizationID).
+		Where("users.deleted_at is null").
+		Order("organization_members.id").
+		Scan(&members).Error; err != nil {
+		return nil, errors.New("failed to get members")
+	}
+
+	return members, nil
+}
+
+func (o OrganizationRepository) AddMember(member *models.OrganizationMember) error {
+	tx := o.Conn.Begin()
+
+	if err := addMember(tx, member); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to add member")
+	}
+
+	return nil
+}
+
+// addMember also makes the organization current for users who have none, so
+// their next token carries it.
+func addMember(tx *gorm.DB, member *models.OrganizationMember) error {
+	if err := tx.Create(&member).Error; err != nil {
+		return errors.New("failed to add member")
+	}
+
+	if err := tx.Table("users").
+		Where("id = ?", member.UserID).
+		Where("organization_id = 0 or organization_id is null").
+		Update("organization_id", member.OrganizationID).Error; err != nil {
+		return errors.New("failed to add member")
+	}
+
+	return nil
+}
+
+func (o OrganizationRepository) SaveMember(member *models.OrganizationMember) error {
+	if err := o.Conn.Save(&member).Error; err != nil {
+		return errors.New("failed to update member")
+	}
+
+	return nil
+}
+
+func (o OrganizationRepository) RemoveMember(member *models.OrganizationMember) error {
+	tx := o.Conn.Begin()
+
+	if err := tx.Delete(&member).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to remove member")
+	}
+
+	if err := clearCurrentOrganization(tx, member.OrganizationID, member.UserID); err != nil {
+		tx.Rollback()
+		return errors.New("failed to remove member")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to remove member")
+	}
+
+	return nil
+}
+
+// clearCurrentOrganization unsets the organization wherever it's current,
+// for a single user or for everyone when userID is 0. Access tokens already
+// issued keep the claim until they expire.
+func clearCurrentOrganization(tx *gorm.DB, organizationID uint, userID int) error {
+	users := tx.Table("users").Where("organization_id = ?", organizationID)
+	sessions := tx.Table("user_tokens").Where("organization_id = ?", organizationID)
+	if userID != 0 {
+		users = users.Where("id = ?", userID)
+		sessions = sessions.Where("user_id = ?", userID)
+	}
+
+	if err := users.Update("organization_id", 0).Error; err != nil {
+		return err
+	}
+
+	return sessions.Update("organization_id", 0).Error
+}
+
+func (o OrganizationRepository) CountMembersWithRole(organizationID uint, role string) (int, error) {
+	count := 0
+
+	if err := o.Conn.Table("organization_members").
+		Where("organization_id = ?", organizationID).
+		Where("role = ?", role).
+		Count(&count).Error; err != nil {
+		return 0, errors.New("failed to count members")
+	}
+
+	return count, nil
+}
+
+// SetCurrentOrganization remembers the choice for the user's next login and
+// moves the session so refreshing its token keeps the organization.
+func (o OrganizationRepository) SetCurrentOrganization(userID int, familyID string, organizationID uint) error {
+	tx := o.Conn.Begin()
+
+	if err := tx.Table("users").
+		Where("id = ?", userID).
+		Update("organization_id", organizationID).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to switch organization")
+	}
+
+	if err := tx.Table("user_tokens").
+		Where("user_id = ?", userID).
+		Where("family_id = ?", familyID).
+		Update("organization_id", organizationID).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to switch organization")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to switch organization")
+	}
+
+	return nil
+}
+
+func (o OrganizationRepository) FetchInvitations(organizationID uint) ([]*models.OrganizationInvitation, error) {
+	invitations := make([]*models.OrganizationInvitation, 0)
+
+	if err := o.Conn.Table("organization_invitations").
+		Where("organization_id = ?", organizationID).
+		Where("deleted_at is null").
+		Order("id desc").
+		Find(&invitations).Error; err != nil {
+		return nil, errors.New("failed to get invitations")
+	}
+
+	return invitations, nil
+}
+
+func (o OrganizationRepository) FetchInvitation(organizationID uint, id int) (*models.OrganizationInvitation, error) {
+	invitation := new(models.OrganizationInvitation)
+
+	if err := o.Conn.Table("organization_invitations").
+		Where("organization_id = ?", organizationID).
+		Where("id = ?", id).
+		Where("deleted_at is null").
+		First(&invitation).Error; err != nil {
+		return nil, errors.New("invitation not found")
+	}
+
+	return invitation, nil
+}
+
+// FetchInvitationByCode only finds the invitation through its latest code,
+// links from before a resend stop working.
+func (o OrganizationRepository) FetchInvitationByCode(code string) (*models.OrganizationInvitation, error) {
+	invitation := new(models.OrganizationInvitation)
+
+	if err := o.Conn.Table("organization_invitations").
+		Select("organization_invitations.*").
+		Joins("join user_verification_codes on user_verification_codes.id = organization_invitations.verification_code_id").
+		Joins("join organizations on organizations.id = organization_invitations.organization_id").
+		Where("user_verification_codes.code = ?", code).
+		Where("user_verification_codes.purpose = ?", helper.VerificationPurposeInvitation).
+		Where("organization_invitations.deleted_at is null").
+		Where("organizations.deleted_at is null").
+		First(&invitation).Error; err != nil {
+		return nil, errors.New("invitation not found")
+	}
+
+	return invitation, nil
+}
+
+func (o OrganizationRepository) FetchPendingInvitation(organizationID uint, email string) (*models.OrganizationInvitation, error) {
+	invitation := new(models.OrganizationInvitation)
+
+	if err := o.Conn.Table("organization_invitations").
+		Where("organization_id = ?", organizationID).
+		Where("lower(email) = lower(?)", email).
+		Where("accepted_at is null").
+		Where("revoked_at is null").
+		Where("expired_at > ?", time.Now().UTC()).
+		Where("deleted_at is null").
+		First(&invitation).Error; err != nil {
+		return nil, errors.New("invitation not found")
+	}
+
+	return invitation, nil
+}
+
+// createInvitationCode isn't tied to a user, the invitee may not have an
+// account yet. It's filled in once the code is used.
+func createInvitationCode(tx *gorm.DB) (*models.UserVerificationCode, error) {
+	expiredAt := time.Now().UTC().Add(helper.VerificationCodeTTL(helper.VerificationPurposeInvitation))
+
+	verificationCode := new(models.UserVerificationCode)
+	verificationCode.Code = helper.GenerateRandomCode()
+	verificationCode.Purpose = helper.VerificationPurposeInvitation
+	verificationCode.IsUsed = 0
+	verificationCode.ExpiredAt = &expiredAt
+
+	if err := tx.Create(&verificationCode).Error; err != nil {
+		return nil, err
+	}
+
+	return verificationCode, nil
+}
+
+func (o OrganizationRepository) CreateInvitation(invitation *models.OrganizationInvitation) (*models.UserVerificationCode, error) {
+	tx := o.Conn.Begin()
+
+	verificationCode, err := createInvitationCode(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("failed to create invitation")
+	}
+
+	invitation.VerificationCodeID = verificationCode.ID
+	invitation.ExpiredAt = verificationCode.ExpiredAt
+
+	if err := tx.Create(&invitation).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("failed to create invitation")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, errors.New("failed to create invitation")
+	}
+
+	return verificationCode, nil
+}
+
+// RenewInvitation replaces the code so the invitation gets a new link and a
+// new expiry, the previous link stops working.
+func (o OrganizationRepository) RenewInvitation(invitation *models.OrganizationInvitation) (*models.UserVerificationCode, error) {
+	tx := o.Conn.Begin()
+
+	if err := tx.Table("user_verification_codes").
+		Where("id = ?", invitation.VerificationCodeID).
+		Where("revoked_at is null").
+		Update("revoked_at", time.Now().UTC()).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("failed to resend invitation")
+	}
+
+	verificationCode, err := createInvitationCode(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("failed to resend invitation")
+	}
+
+	invitation.VerificationCodeID = verificationCode.ID
+	invitation.ExpiredAt = verificationCode.ExpiredAt
+
+	if err := tx.Save(&invitation).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("failed to resend invitation")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, errors.New("failed to resend invitation")
+	}
+
+	return verificationCode, nil
+}
+
+func (o OrganizationRepository) RevokeInvitation(invitation *models.OrganizationInvitation) error {
+	now := time.Now().UTC()
+
+	tx := o.Conn.Begin()
+
+	if err := tx.Table("user_verification_codes").
+		Where("id = ?", invitation.VerificationCodeID).
+		Where("revoked_at is null").
+		Update("revoked_at", now).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to revoke invitation")
+	}
+
+	invitation.RevokedAt = &now
+	if err := tx.Save(&invitation).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to revoke invitation")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to revoke invitation")
+	}
+
+	return nil
+}
+
+// AcceptInvitation adds userID to the organization unless they're already a
+// member. verifyEmail is set when the account was just registered from the
+// invitation link, which proves the address the same way a signup code does.
+func (o OrganizationRepository) AcceptInvitation(invitation *models.OrganizationInvitation, userID int, verifyEmail bool) error {
+	now := time.Now().UTC()
+
+	tx := o.Conn.Begin()
+
+	// claimed with a conditional update so the invitation can't be used twice
+	result := tx.Table("user_verification_codes").
+		Where("id = ?", invitation.VerificationCodeID).
+		Where("is_used = ?", 0).
+		Where("revoked_at is null").
+		Updates(map[string]interface{}{"is_used": 1, "user_id": userID, "attempts": gorm.Expr("attempts + 1")})
+	if err := result.Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to accept invitation")
+	}
+
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return errors.New("invitation is no longer valid")
+	}
+
+	invitation.AcceptedBy = userID
+	invitation.AcceptedAt = &now
+	if err := tx.Save(&invitation).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to accept invitation")
+	}
+
+	count := 0
+	if err := tx.Table("organization_members").
+		Where("organization_id = ?", invitation.OrganizationID).
+		Where("user_id = ?", userID).
+		Count(&count).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to accept invitation")
+	}
+
+	if count == 0 {
+		member := &models.OrganizationMember{OrganizationID: invitation.OrganizationID, UserID: userID, Role: invitation.Role}
+		if err := addMember(tx, member); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if verifyEmail {
+		if err := tx.Table("users").
+			Where("id = ?", userID).
+			Updates(map[string]interface{}{
+				"is_verified": 1,
+				"is_active":   gorm.Expr("case when deactivated_at is null then 1 else is_active end"),
+			}).Error; err != nil {
+			tx.Rollback()
+			return errors.New("failed to accept invitation")
+		}
+
+		// the signup code mailed on registration isn't needed anymore
+		if err := tx.Table("user_verification_codes").
+			Where("user_id = ?", userID).
+			Where("purpose in (?)", []string{helper.VerificationPurposeSignup, ""}).
+			Where("is_used = ?", 0).
+			Where("revoked_at is null").
+			Update("revoked_at", now).Error; err != nil {
+			tx.Rollback()
+			return errors.New("failed to accept invitation")
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to accept invitation")
+	}
+
+	return nil
+}
+
+func NewOrganizationRepository(conn *gorm.DB) organization.Repository {
+	return &OrganizationRepository{
+		Conn: conn,
+	}
+}
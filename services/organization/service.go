@@ -0,0 +1,20 @@
+package organization
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Service interface {
+	Organizations(userID int) (map[string]interface{}, error)
+	CreateOrganization(userID int, form *models.OrganizationForm, meta *models.RequestMetadata) (map[string]interface{}, error)
+	Organization(userID, id int) (map[string]interface{}, error)
+	UpdateOrganization(userID, id int, form *models.OrganizationForm) (map[string]interface{}, error)
+	DeleteOrganization(userID, id int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	SwitchOrganization(userID, id int, currentToken string, tfaVerified bool, meta *models.RequestMetadata) (map[string]interface{}, error)
+	Members(userID, id int) (map[string]interface{}, error)
+	UpdateMember(userID, id, memberID int, role string, meta *models.RequestMetadata) (map[string]interface{}, error)
+	RemoveMember(userID, id, memberID int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	Invitations(userID, id int) (map[string]interface{}, error)
+	Invite(userID, id int, form *models.OrganizationInvitationForm, meta *models.RequestMetadata) (map[string]interface{}, error)
+	ResendInvitation(userID, id, invitationID int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	RevokeInvitation(userID, id, invitationID int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	AcceptInvitation(userID int, code string, meta *models.RequestMetadata) (map[string]interface{}, error)
+}
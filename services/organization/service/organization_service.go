@@ -0,0 +1,610 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/mailer"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/auth"
+	"github.com/ardiantirta/go-user-management/services/event"
+	"github.com/ardiantirta/go-user-management/services/organization"
+)
+
+type OrganizationService struct {
+	OrganizationRepository organization.Repository
+	AuthRepository         auth.Repository
+	EventRepository        event.Repository
+}
+
+func organizationResponse(org *models.Organization) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          org.ID,
+		"name":        org.Name,
+		"slug":        org.Slug,
+		"join_domain": org.JoinDomain,
+		"created_at":  org.CreatedAt.Format(helper.FormatRFC8601),
+		"updated_at":  org.UpdatedAt.Format(helper.FormatRFC8601),
+	}
+}
+
+// fetchMembership treats organizations the user isn't a member of as missing
+// so their existence doesn't leak.
+func (s *OrganizationService) fetchMembership(userID, id int, permission string) (*models.Organization, *models.OrganizationMember, error) {
+	org, err := s.OrganizationRepository.FetchOrganizationByID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	member, err := s.OrganizationRepository.FetchMember(org.ID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if permission != "" && !organization.HasPermission(member.Role, permission) {
+		return nil, nil, errors.New("you are not allowed to do this in the organization")
+	}
+
+	return org, member, nil
+}
+
+// isLastOwner is true when member is the only owner left, an organization
+// always needs one.
+func (s *OrganizationService) isLastOwner(member *models.OrganizationMember) (bool, error) {
+	if member.Role != organization.RoleOwner {
+		return false, nil
+	}
+
+	count, err := s.OrganizationRepository.CountMembersWithRole(member.OrganizationID, organization.RoleOwner)
+	if err != nil {
+		return false, err
+	}
+
+	return count <= 1, nil
+}
+
+func (s *OrganizationService) Organizations(userID int) (map[string]interface{}, error) {
+	currentUser, err := s.AuthRepository.FetchUserByID(userID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	memberships, err := s.OrganizationRepository.FetchUserOrganizations(userID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, membership := range memberships {
+		response := organizationResponse(&membership.Organization)
+		response["role"] = membership.Role
+		response["joined_at"] = membership.JoinedAt.Format(helper.FormatRFC8601)
+		response["current"] = membership.ID == currentUser.OrganizationID
+		list = append(list, response)
+	}
+
+	return map[string]interface{}{
+		"organizations": list,
+	}, nil
+}
+
+func (s *OrganizationService) CreateOrganization(userID int, form *models.OrganizationForm, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	name, slug, joinDomain, err := organization.Normalize(form.Name, form.Slug, form.JoinDomain)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if _, err := s.OrganizationRepository.FetchOrganizationBySlug(slug); err == nil {
+		err := errors.New("organization slug is already taken")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	org := new(models.Organization)
+	org.Name = name
+	org.Slug = slug
+	org.JoinDomain = joinDomain
+
+	if err := s.OrganizationRepository.CreateOrganization(org, userID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, userID, event.TypeOrganizationCreated, meta, map[string]interface{}{"organization_id": org.ID})
+
+	response := organizationResponse(org)
+	response["role"] = organization.RoleOwner
+
+	return response, nil
+}
+
+func (s *OrganizationService) Organization(userID, id int) (map[string]interface{}, error) {
+	org, member, err := s.fetchMembership(userID, id, "")
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	response := organizationResponse(org)
+	response["role"] = member.Role
+
+	return response, nil
+}
+
+func (s *OrganizationService) UpdateOrganization(userID, id int, form *models.OrganizationForm) (map[string]interface{}, error) {
+	org, member, err := s.fetchMembership(userID, id, organization.PermissionOrganizationManage)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	name, slug, joinDomain, err := organization.Normalize(form.Name, form.Slug, form.JoinDomain)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if slug != org.Slug {
+		if _, err := s.OrganizationRepository.FetchOrganizationBySlug(slug); err == nil {
+			err := errors.New("organization slug is already taken")
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	}
+
+	org.Name = name
+	org.Slug = slug
+	org.JoinDomain = joinDomain
+
+	if err := s.OrganizationRepository.SaveOrganization(org); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	response := organizationResponse(org)
+	response["role"] = member.Role
+
+	return response, nil
+}
+
+func (s *OrganizationService) DeleteOrganization(userID, id int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	org, _, err := s.fetchMembership(userID, id, organization.PermissionOrganizationManage)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.OrganizationRepository.DeleteOrganization(org); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, userID, event.TypeOrganizationDeleted, meta, map[string]interface{}{"organization_id": org.ID})
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+// SwitchOrganization replaces the current access token with one for the
+// organization, the session and its refresh token stay the same.
+func (s *OrganizationService) SwitchOrganization(userID, id int, currentToken string, tfaVerified bool, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	org, _, err := s.fetchMembership(userID, id, "")
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	currentUser, err := s.AuthRepository.FetchUserByID(userID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	userToken, err := s.AuthRepository.FetchUserToken(userID, currentToken)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.OrganizationRepository.SetCurrentOrganization(userID, userToken.FamilyID, org.ID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.AuthRepository.DeleteUserToken(userToken); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	meta.StartedAt = userToken.SessionStartedAt
+	meta.OrganizationID = org.ID
+
+	currentUser.OrganizationID = org.ID
+	response, err := s.AuthRepository.IssueUserToken(currentUser, tfaVerified, userToken.FamilyID, meta)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return response, nil
+}
+
+func (s *OrganizationService) Members(userID, id int) (map[string]interface{}, error) {
+	org, _, err := s.fetchMembership(userID, id, organization.PermissionMembersRead)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	members, err := s.OrganizationRepository.FetchMembers(org.ID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, m := range members {
+		list = append(list, map[string]interface{}{
+			"user_id":   m.UserID,
+			"email":     m.Email,
+			"full_name": m.FullName,
+			"role":      m.Role,
+			"joined_at": m.CreatedAt.Format(helper.FormatRFC8601),
+		})
+	}
+
+	return map[string]interface{}{
+		"members": list,
+	}, nil
+}
+
+// UpdateMember changes a member's role, only owners can hand out or take away
+// ownership.
+func (s *OrganizationService) UpdateMember(userID, id, memberID int, role string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	org, current, err := s.fetchMembership(userID, id, organization.PermissionMembersManage)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if !organization.IsValidRole(role) {
+		err := errors.New("role must be owner, admin or member")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	member, err := s.OrganizationRepository.FetchMember(org.ID, memberID)
+	if err != nil {
+		err := errors.New("member not found")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if (role == organization.RoleOwner || member.Role == organization.RoleOwner) && current.Role != organization.RoleOwner {
+		err := errors.New("only owners can change ownership")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if role != organization.RoleOwner {
+		lastOwner, err := s.isLastOwner(member)
+		if err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+		if lastOwner {
+			err := errors.New("the last owner can't be changed")
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	}
+
+	member.Role = role
+	if err := s.OrganizationRepository.SaveMember(member); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, memberID, event.TypeOrganizationRoleChanged, meta, map[string]interface{}{
+		"organization_id": org.ID,
+		"role":            role,
+		"changed_by":      userID,
+	})
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+// RemoveMember also lets any member leave on their own.
+func (s *OrganizationService) RemoveMember(userID, id, memberID int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	permission := organization.PermissionMembersManage
+	if userID == memberID {
+		permission = ""
+	}
+
+	org, current, err := s.fetchMembership(userID, id, permission)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	member, err := s.OrganizationRepository.FetchMember(org.ID, memberID)
+	if err != nil {
+		err := errors.New("member not found")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if member.Role == organization.RoleOwner && current.Role != organization.RoleOwner {
+		err := errors.New("only owners can remove an owner")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	lastOwner, err := s.isLastOwner(member)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+	if lastOwner {
+		err := errors.New("the last owner can't leave, delete the organization instead")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.OrganizationRepository.RemoveMember(member); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, memberID, event.TypeOrganizationLeft, meta, map[string]interface{}{
+		"organization_id": org.ID,
+		"removed_by":      userID,
+	})
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func invitationResponse(invitation *models.OrganizationInvitation) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":         invitation.ID,
+		"email":      invitation.Email,
+		"role":       invitation.Role,
+		"invited_by": invitation.InvitedBy,
+		"status":     organization.InvitationStatus(invitation, time.Now()),
+		"created_at": invitation.CreatedAt.Format(helper.FormatRFC8601),
+	}
+
+	if invitation.ExpiredAt != nil {
+		response["expired_at"] = invitation.ExpiredAt.Format(helper.FormatRFC8601)
+	}
+	if invitation.AcceptedAt != nil {
+		response["accepted_at"] = invitation.AcceptedAt.Format(helper.FormatRFC8601)
+		response["accepted_by"] = invitation.AcceptedBy
+	}
+	if invitation.RevokedAt != nil {
+		response["revoked_at"] = invitation.RevokedAt.Format(helper.FormatRFC8601)
+	}
+
+	return response
+}
+
+// sendInvitation mails the link in the invitee's language when they already
+// have an account, otherwise in the inviter's.
+func (s *OrganizationService) sendInvitation(org *models.Organization, inviterID int, invitation *models.OrganizationInvitation, verificationCode *models.UserVerificationCode) error {
+	inviter, err := s.AuthRepository.FetchUserByID(inviterID)
+	if err != nil {
+		return err
+	}
+
+	name, locale := "", inviter.Locale
+	if invitee, err := s.AuthRepository.FetchUserByEmail(invitation.Email); err == nil {
+		name, locale = invitee.FullName, invitee.Locale
+	}
+
+	return mailer.SendTemplate(mailer.Address{Name: name, Email: invitation.Email}, locale, mailer.TemplateOrganizationInvitation, map[string]interface{}{
+		"Name":             name,
+		"OrganizationName": org.Name,
+		"InviterName":      inviter.FullName,
+		"Role":             invitation.Role,
+		"Link":             helper.InvitationURL(verificationCode),
+		"ExpiredAt":        verificationCode.ExpiredAt.Format(helper.FormatRFC8601),
+	})
+}
+
+// fetchInvitation also checks the caller may manage it, only owners can
+// handle invitations that hand out ownership.
+func (s *OrganizationService) fetchInvitation(userID, id, invitationID int) (*models.Organization, *models.OrganizationInvitation, error) {
+	org, current, err := s.fetchMembership(userID, id, organization.PermissionMembersManage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	invitation, err := s.OrganizationRepository.FetchInvitation(org.ID, invitationID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if invitation.Role == organization.RoleOwner && current.Role != organization.RoleOwner {
+		return nil, nil, errors.New("only owners can change ownership")
+	}
+
+	return org, invitation, nil
+}
+
+func (s *OrganizationService) Invitations(userID, id int) (map[string]interface{}, error) {
+	org, _, err := s.fetchMembership(userID, id, organization.PermissionMembersManage)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	invitations, err := s.OrganizationRepository.FetchInvitations(org.ID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, invitation := range invitations {
+		list = append(list, invitationResponse(invitation))
+	}
+
+	return map[string]interface{}{
+		"invitations": list,
+	}, nil
+}
+
+func (s *OrganizationService) Invite(userID, id int, form *models.OrganizationInvitationForm, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	org, current, err := s.fetchMembership(userID, id, organization.PermissionMembersManage)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(form.Email))
+	if err := validator.New().Var(email, "required,email,max=128"); err != nil {
+		err := errors.New("email must be a valid email and not longer than 128 chars")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	role := form.Role
+	if role == "" {
+		role = organization.RoleMember
+	}
+
+	if !organization.IsValidRole(role) {
+		err := errors.New("role must be owner, admin or member")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if role == organization.RoleOwner && current.Role != organization.RoleOwner {
+		err := errors.New("only owners can change ownership")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if invitee, err := s.AuthRepository.FetchUserByEmail(email); err == nil {
+		if _, err := s.OrganizationRepository.FetchMember(org.ID, int(invitee.ID)); err == nil {
+			err := errors.New("user is already a member of the organization")
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	}
+
+	if _, err := s.OrganizationRepository.FetchPendingInvitation(org.ID, email); err == nil {
+		err := errors.New("email has already been invited, resend the invitation instead")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	invitation := new(models.OrganizationInvitation)
+	invitation.OrganizationID = org.ID
+	invitation.Email = email
+	invitation.Role = role
+	invitation.InvitedBy = userID
+
+	verificationCode, err := s.OrganizationRepository.CreateInvitation(invitation)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, userID, event.TypeInvitationSent, meta, map[string]interface{}{
+		"organization_id": org.ID,
+		"invitation_id":   invitation.ID,
+		"email":           email,
+		"role":            role,
+	})
+
+	if err := s.sendInvitation(org, userID, invitation, verificationCode); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return invitationResponse(invitation), nil
+}
+
+// ResendInvitation mails a new link, which also works for invitations that
+// have expired.
+func (s *OrganizationService) ResendInvitation(userID, id, invitationID int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	org, invitation, err := s.fetchInvitation(userID, id, invitationID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if status := organization.InvitationStatus(invitation, time.Now()); status != organization.InvitationPending && status != organization.InvitationExpired {
+		err := organization.InvitationError(invitation, time.Now())
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	verificationCode, err := s.OrganizationRepository.RenewInvitation(invitation)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, userID, event.TypeInvitationSent, meta, map[string]interface{}{
+		"organization_id": org.ID,
+		"invitation_id":   invitation.ID,
+		"email":           invitation.Email,
+		"role":            invitation.Role,
+		"resent":          true,
+	})
+
+	if err := s.sendInvitation(org, userID, invitation, verificationCode); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return invitationResponse(invitation), nil
+}
+
+func (s *OrganizationService) RevokeInvitation(userID, id, invitationID int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	org, invitation, err := s.fetchInvitation(userID, id, invitationID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if status := organization.InvitationStatus(invitation, time.Now()); status == organization.InvitationAccepted || status == organization.InvitationRevoked {
+		err := organization.InvitationError(invitation, time.Now())
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.OrganizationRepository.RevokeInvitation(invitation); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, userID, event.TypeInvitationRevoked, meta, map[string]interface{}{
+		"organization_id": org.ID,
+		"invitation_id":   invitation.ID,
+		"email":           invitation.Email,
+	})
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+// AcceptInvitation attaches an existing account, it has to be the one the
+// invitation was sent to.
+func (s *OrganizationService) AcceptInvitation(userID int, code string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	invitation, err := s.OrganizationRepository.FetchInvitationByCode(code)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := organization.InvitationError(invitation, time.Now()); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	currentUser, err := s.AuthRepository.FetchUserByID(userID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if !strings.EqualFold(currentUser.Email, invitation.Email) {
+		err := errors.New("invitation was sent to another email address")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	org, err := s.OrganizationRepository.FetchOrganizationByID(int(invitation.OrganizationID))
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.OrganizationRepository.AcceptInvitation(invitation, userID, false); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, userID, event.TypeInvitationAccepted, meta, map[string]interface{}{
+		"organization_id": org.ID,
+		"invitation_id":   invitation.ID,
+		"invited_by":      invitation.InvitedBy,
+	})
+
+	// members who were invited again keep the role they already had
+	response := organizationResponse(org)
+	response["role"] = invitation.Role
+	if member, err := s.OrganizationRepository.FetchMember(org.ID, userID); err == nil {
+		response["role"] = member.Role
+	}
+
+	return response, nil
+}
+
+func NewOrganizationService(organizationRepository organization.Repository, authRepository auth.Repository, eventRepository event.Repository) organization.Service {
+	return &OrganizationService{
+		OrganizationRepository: organizationRepository,
+		AuthRepository:         authRepository,
+		EventRepository:        eventRepository,
+	}
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/middleware"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/rbac"
+)
+
+type RbacHandler struct {
+	RbacService rbac.Service
+}
+
+func NewRbacHandler(r *mux.Router, rbacService rbac.Service) {
+	handler := RbacHandler{
+		RbacService: rbacService,
+	}
+
+	v1 := r.PathPrefix("/admin").Subrouter()
+
+	v1.Use(middleware.JwtAuthentication)
+	v1.Use(middleware.TwoFactorAuthentication)
+	v1.Use(middleware.RequirePermission(rbac.PermissionRolesManage))
+
+	v1.Handle("/permissions", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Permissions))).Methods(http.MethodGet)
+	v1.Handle("/roles", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Roles))).Methods(http.MethodGet)
+	v1.Handle("/roles", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.CreateRole))).Methods(http.MethodPost)
+	v1.Handle("/roles/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.Role))).Methods(http.MethodGet)
+	v1.Handle("/roles/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.UpdateRole))).Methods(http.MethodPut)
+	v1.Handle("/roles/{id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.DeleteRole))).Methods(http.MethodDelete)
+	v1.Handle("/roles/{id:[0-9]+}/users", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.RoleUsers))).Methods(http.MethodGet)
+	v1.Handle("/roles/{id:[0-9]+}/users", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.AssignRole))).Methods(http.MethodPost)
+	v1.Handle("/roles/{id:[0-9]+}/users/{user_id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.UnassignRole))).Methods(http.MethodDelete)
+}
+
+func (h *RbacHandler) Permissions(w http.ResponseWriter, r *http.Request) {
+	response, err := h.RbacService.Permissions()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *RbacHandler) Roles(w http.ResponseWriter, r *http.Request) {
+	response, err := h.RbacService.Roles()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *RbacHandler) Role(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.RbacService.Role(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *RbacHandler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	formData := new(models.RoleForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := h.RbacService.CreateRole(formData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *RbacHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	formData := new(models.RoleForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	response, err := h.RbacService.UpdateRole(id, formData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *RbacHandler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.RbacService.DeleteRole(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *RbacHandler) RoleUsers(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	response, err := h.RbacService.RoleUsers(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *RbacHandler) AssignRole(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	formData := new(models.RoleAssignmentForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	if formData.UserID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "user_id is required"))
+		return
+	}
+
+	response, err := h.RbacService.AssignRole(id, formData.UserID, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (h *RbacHandler) UnassignRole(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	userID, _ := strconv.Atoi(mux.Vars(r)["user_id"])
+
+	response, err := h.RbacService.UnassignRole(id, userID, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
@@ -0,0 +1,25 @@
+package rbac
+
+const RoleAdmin = "admin"
+
+const (
+	PermissionClientsManage = "clients:manage"
+	PermissionEmailsManage  = "emails:manage"
+	PermissionGroupsManage  = "groups:manage"
+	PermissionRolesManage   = "roles:manage"
+	PermissionUsersRead     = "users:read"
+	PermissionUsersManage   = "users:manage"
+	PermissionUsersDelete   = "users:delete"
+)
+
+// Permissions are seeded on startup, the admin role is always granted every
+// one of them.
+var Permissions = map[string]string{
+	PermissionClientsManage: "Manage OAuth clients",
+	PermissionEmailsManage:  "Preview email templates and manage the email queue",
+	PermissionGroupsManage:  "Manage groups, their members and the roles they grant",
+	PermissionRolesManage:   "Manage roles and assign them to users",
+	PermissionUsersRead:     "List, search and view user accounts",
+	PermissionUsersManage:   "Create, edit, verify, deactivate and reset user accounts",
+	PermissionUsersDelete:   "Permanently delete user accounts",
+}
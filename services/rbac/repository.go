@@ -0,0 +1,26 @@
+package rbac
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Repository interface {
+	FetchRoles() ([]*models.Role, error)
+	FetchRoleByID(id int) (*models.Role, error)
+	FetchRoleByName(name string) (*models.Role, error)
+	CreateRole(role *models.Role, permissions []string) error
+	UpdateRole(role *models.Role, permissions []string) error
+	DeleteRole(role *models.Role) error
+
+	FetchPermissions() ([]*models.Permission, error)
+	FetchRolePermissions(roleID uint) ([]string, error)
+	SeedPermissions(permissions map[string]string) error
+	SeedRole(name, description string, permissions []string) (*models.Role, error)
+
+	FetchRoleUsers(roleID uint) ([]*models.User, error)
+	FetchUserRoles(userID int) ([]*models.Role, error)
+	FetchUserPermissions(userID int) ([]string, error)
+	AssignRole(userID int, roleID uint) error
+	UnassignRole(userID int, roleID uint) error
+	CountRoleUsers(roleID uint) (int, error)
+	FetchUserByID(id int) (*models.User, error)
+	FetchVerifiedUsersByEmails(emails []string) ([]*models.User, error)
+}
@@ -0,0 +1,370 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/rbac"
+)
+
+type RbacRepository struct {
+	Conn *gorm.DB
+}
+
+func (r RbacRepository) FetchRoles() ([]*models.Role, error) {
+	roles := make([]*models.Role, 0)
+
+	if err := r.Conn.Table("roles").
+		Where("deleted_at is null").
+		Order("id").
+		Find(&roles).Error; err != nil {
+		return nil, errors.New("failed to get roles")
+	}
+
+	return roles, nil
+}
+
+func (r RbacRepository) FetchRoleByID(id int) (*models.Role, error) {
+	role := new(models.Role)
+
+	if err := r.Conn.Table("roles").
+		Where("id = ?", id).
+		Where("deleted_at is null").
+		First(&role).Error; err != nil {
+		return nil, errors.New("role not found")
+	}
+
+	return role, nil
+}
+
+func (r RbacRepository) FetchRoleByName(name string) (*models.Role, error) {
+	role := new(models.Role)
+
+	if err := r.Conn.Table("roles").
+		Where("name = ?", name).
+		Where("deleted_at is null").
+		First(&role).Error; err != nil {
+		return nil, errors.New("role not found")
+	}
+
+	return role, nil
+}
+
+func (r RbacRepository) CreateRole(role *models.Role, permissions []string) error {
+	tx := r.Conn.Begin()
+
+	if err := tx.Create(&role).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to create role")
+	}
+
+	if err := setRolePermissions(tx, role.ID, permissions); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to create role")
+	}
+
+	return nil
+}
+
+func (r RbacRepository) UpdateRole(role *models.Role, permissions []string) error {
+	tx := r.Conn.Begin()
+
+	if err := tx.Save(&role).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to update role")
+	}
+
+	if err := setRolePermissions(tx, role.ID, permissions); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to update role")
+	}
+
+	return nil
+}
+
+func (r RbacRepository) DeleteRole(role *models.Role) error {
+	tx := r.Conn.Begin()
+
+	if err := tx.Unscoped().Table("user_roles").
+		Where("role_id = ?", role.ID).
+		Delete(models.UserRole{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete role")
+	}
+
+	if err := tx.Unscoped().Table("role_permissions").
+		Where("role_id = ?", role.ID).
+		Delete(models.RolePermission{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete role")
+	}
+
+	if err := tx.Unscoped().Table("group_roles").
+		Where("role_id = ?", role.ID).
+		Delete(models.GroupRole{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete role")
+	}
+
+	if err := tx.Unscoped().Delete(&role).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete role")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to delete role")
+	}
+
+	return nil
+}
+
+// setRolePermissions replaces the role's grants with permissions, every name
+// has to exist in the permissions table.
+func setRolePermissions(tx *gorm.DB, roleID uint, permissions []string) error {
+	if err := tx.Unscoped().Table("role_permissions").
+		Where("role_id = ?", roleID).
+		Delete(models.RolePermission{}).Error; err != nil {
+		return errors.New("failed to update role permissions")
+	}
+
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	found := make([]*models.Permission, 0)
+	if err := tx.Table("permissions").
+		Where("name in (?)", permissions).
+		Where("deleted_at is null").
+		Find(&found).Error; err != nil {
+		return errors.New("failed to update role permissions")
+	}
+
+	for _, name := range permissions {
+		known := false
+		for _, permission := range found {
+			if permission.Name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return errors.New("unknown permission " + name)
+		}
+	}
+
+	for _, permission := range found {
+		if err := tx.Create(&models.RolePermission{RoleID: roleID, PermissionID: permission.ID}).Error; err != nil {
+			return errors.New("failed to update role permissions")
+		}
+	}
+
+	return nil
+}
+
+func (r RbacRepository) FetchPermissions() ([]*models.Permission, error) {
+	permissions := make([]*models.Permission, 0)
+
+	if err := r.Conn.Table("permissions").
+		Where("deleted_at is null").
+		Order("name").
+		Find(&permissions).Error; err != nil {
+		return nil, errors.New("failed to get permissions")
+	}
+
+	return permissions, nil
+}
+
+func (r RbacRepository) FetchRolePermissions(roleID uint) ([]string, error) {
+	names := make([]string, 0)
+
+	if err := r.Conn.Table("permissions").
+		Joins("join role_permissions on role_permissions.permission_id = permissions.id").
+		Where("role_permissions.role_id = ?", roleID).
+		Where("permissions.deleted_at is null").
+		Order("permissions.name").
+		Pluck("permissions.name", &names).Error; err != nil {
+		return nil, errors.New("failed to get role permissions")
+	}
+
+	return names, nil
+}
+
+func (r RbacRepository) SeedPermissions(permissions map[string]string) error {
+	for name, description := range permissions {
+		permission := new(models.Permission)
+		if err := r.Conn.Where(models.Permission{Name: name}).
+			Assign(models.Permission{Description: description}).
+			FirstOrCreate(&permission).Error; err != nil {
+			return errors.New("failed to seed permissions")
+		}
+	}
+
+	return nil
+}
+
+// SeedRole creates the role when it's missing and resets its permissions to
+// exactly the given ones.
+func (r RbacRepository) SeedRole(name, description string, permissions []string) (*models.Role, error) {
+	role := new(models.Role)
+
+	tx := r.Conn.Begin()
+
+	if err := tx.Where(models.Role{Name: name}).
+		Assign(models.Role{Description: description, IsSystem: 1}).
+		FirstOrCreate(&role).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("failed to seed role")
+	}
+
+	if err := setRolePermissions(tx, role.ID, permissions); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, errors.New("failed to seed role")
+	}
+
+	return role, nil
+}
+
+func (r RbacRepository) FetchRoleUsers(roleID uint) ([]*models.User, error) {
+	users := make([]*models.User, 0)
+
+	if err := r.Conn.Table("users").
+		Select("users.*").
+		Joins("join user_roles on user_roles.user_id = users.id").
+		Where("user_roles.role_id = ?", roleID).
+		Where("users.deleted_at is null").
+		Order("users.id").
+		Find(&users).Error; err != nil {
+		return nil, errors.New("failed to get role users")
+	}
+
+	return users, nil
+}
+
+func (r RbacRepository) FetchUserRoles(userID int) ([]*models.Role, error) {
+	roles := make([]*models.Role, 0)
+
+	if err := r.Conn.Table("roles").
+		Select("roles.*").
+		Joins("join user_roles on user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Where("roles.deleted_at is null").
+		Order("roles.name").
+		Find(&roles).Error; err != nil {
+		return nil, errors.New("failed to get user roles")
+	}
+
+	return roles, nil
+}
+
+func (r RbacRepository) FetchUserPermissions(userID int) ([]string, error) {
+	names := make([]string, 0)
+
+	if err := r.Conn.Table("permissions").
+		Joins("join role_permissions on role_permissions.permission_id = permissions.id").
+		Joins("join user_roles on user_roles.role_id = role_permissions.role_id").
+		Joins("join roles on roles.id = user_roles.role_id").
+		Where("user_roles.user_id = ?", userID).
+		Where("roles.deleted_at is null").
+		Where("permissions.deleted_at is null").
+		Order("permissions.name").
+		Pluck("distinct permissions.name", &names).Error; err != nil {
+		return nil, errors.New("failed to get user permissions")
+	}
+
+	return names, nil
+}
+
+func (r RbacRepository) AssignRole(userID int, roleID uint) error {
+	userRole := new(models.UserRole)
+
+	if err := r.Conn.Where(models.UserRole{UserID: userID, RoleID: roleID}).
+		FirstOrCreate(&userRole).Error; err != nil {
+		return errors.New("failed to assign role")
+	}
+
+	return nil
+}
+
+func (r RbacRepository) UnassignRole(userID int, roleID uint) error {
+	result := r.Conn.Table("user_roles").
+		Where("user_id = ?", userID).
+		Where("role_id = ?", roleID).
+		Delete(models.UserRole{})
+	if err := result.Error; err != nil {
+		return errors.New("failed to unassign role")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("user does not have this role")
+	}
+
+	return nil
+}
+
+func (r RbacRepository) CountRoleUsers(roleID uint) (int, error) {
+	count := 0
+
+	if err := r.Conn.Table("user_roles").
+		Joins("join users on users.id = user_roles.user_id").
+		Where("user_roles.role_id = ?", roleID).
+		Where("users.deleted_at is null").
+		Count(&count).Error; err != nil {
+		return 0, errors.New("failed to count role users")
+	}
+
+	return count, nil
+}
+
+func (r RbacRepository) FetchUserByID(id int) (*models.User, error) {
+	user := new(models.User)
+
+	if err := r.Conn.Table("users").
+		Where("id = ?", id).
+		First(&user).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	return user, nil
+}
+
+func (r RbacRepository) FetchVerifiedUsersByEmails(emails []string) ([]*models.User, error) {
+	users := make([]*models.User, 0)
+	if len(emails) == 0 {
+		return users, nil
+	}
+
+	lowered := make([]string, 0, len(emails))
+	for _, email := range emails {
+		lowered = append(lowered, strings.ToLower(email))
+	}
+
+	if err := r.Conn.Table("users").
+		Where("lower(email) in (?)", lowered).
+		Where("is_verified = ?", 1).
+		Find(&users).Error; err != nil {
+		return nil, errors.New("failed to get users")
+	}
+
+	return users, nil
+}
+
+func NewRbacRepository(conn *gorm.DB) rbac.Repository {
+	return &RbacRepository{
+		Conn: conn,
+	}
+}
@@ -0,0 +1,18 @@
+package rbac
+
+import "github.com/ardiantirta/go-user-management/models"
+
+type Service interface {
+	Seed() error
+
+	Roles() (map[string]interface{}, error)
+	Role(id int) (map[string]interface{}, error)
+	CreateRole(form *models.RoleForm) (map[string]interface{}, error)
+	UpdateRole(id int, form *models.RoleForm) (map[string]interface{}, error)
+	DeleteRole(id int) (map[string]interface{}, error)
+	Permissions() (map[string]interface{}, error)
+
+	RoleUsers(id int) (map[string]interface{}, error)
+	AssignRole(id, userID int, meta *models.RequestMetadata) (map[string]interface{}, error)
+	UnassignRole(id, userID int, meta *models.RequestMetadata) (map[string]interface{}, error)
+}
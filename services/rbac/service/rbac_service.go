@@ -0,0 +1,295 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/services/event"
+	"github.com/ardiantirta/go-user-management/services/rbac"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,63}$`)
+
+type RbacService struct {
+	RbacRepository  rbac.Repository
+	EventRepository event.Repository
+}
+
+func (s *RbacService) roleResponse(role *models.Role) (map[string]interface{}, error) {
+	permissions, err := s.RbacRepository.FetchRolePermissions(role.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"id":          role.ID,
+		"name":        role.Name,
+		"description": role.Description,
+		"is_system":   role.IsSystem == 1,
+		"permissions": permissions,
+		"created_at":  role.CreatedAt.Format(helper.FormatRFC8601),
+		"updated_at":  role.UpdatedAt.Format(helper.FormatRFC8601),
+	}, nil
+}
+
+// Seed makes sure every known permission exists and that the admin role holds
+// all of them. Accounts listed in admin.emails, which used to be the only way
+// to reach the admin api, are given the admin role.
+func (s *RbacService) Seed() error {
+	if err := s.RbacRepository.SeedPermissions(rbac.Permissions); err != nil {
+		return err
+	}
+
+	permissions := make([]string, 0, len(rbac.Permissions))
+	for name := range rbac.Permissions {
+		permissions = append(permissions, name)
+	}
+	sort.Strings(permissions)
+
+	adminRole, err := s.RbacRepository.SeedRole(rbac.RoleAdmin, "Full access to the admin api", permissions)
+	if err != nil {
+		return err
+	}
+
+	// anyone can register an unverified account with an admin's address, only
+	// the owner of the mailbox gets the role
+	admins, err := s.RbacRepository.FetchVerifiedUsersByEmails(viper.GetStringSlice("admin.emails"))
+	if err != nil {
+		return err
+	}
+
+	for _, admin := range admins {
+		if err := s.RbacRepository.AssignRole(int(admin.ID), adminRole.ID); err != nil {
+			logrus.WithField("user_id", admin.ID).Error(err)
+		}
+	}
+
+	return nil
+}
+
+func (s *RbacService) Roles() (map[string]interface{}, error) {
+	roles, err := s.RbacRepository.FetchRoles()
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, role := range roles {
+		response, err := s.roleResponse(role)
+		if err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+		list = append(list, response)
+	}
+
+	return map[string]interface{}{
+		"roles": list,
+	}, nil
+}
+
+func (s *RbacService) Role(id int) (map[string]interface{}, error) {
+	role, err := s.RbacRepository.FetchRoleByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	response, err := s.roleResponse(role)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return response, nil
+}
+
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if _, ok := rbac.Permissions[permission]; !ok {
+			return errors.New("unknown permission " + permission)
+		}
+	}
+
+	return nil
+}
+
+func (s *RbacService) CreateRole(form *models.RoleForm) (map[string]interface{}, error) {
+	name := strings.ToLower(strings.TrimSpace(form.Name))
+	if !roleNamePattern.MatchString(name) {
+		err := errors.New("name must be 2 to 64 chars of lowercase letters, digits, - and _")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if _, err := s.RbacRepository.FetchRoleByName(name); err == nil {
+		err := errors.New("role already exist")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := validatePermissions(form.Permissions); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	role := new(models.Role)
+	role.Name = name
+	role.Description = strings.TrimSpace(form.Description)
+
+	if err := s.RbacRepository.CreateRole(role, form.Permissions); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return s.Role(int(role.ID))
+}
+
+// UpdateRole replaces the description and permissions, names are fixed once
+// created. System roles are reseeded on startup so they can't be edited.
+func (s *RbacService) UpdateRole(id int, form *models.RoleForm) (map[string]interface{}, error) {
+	role, err := s.RbacRepository.FetchRoleByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if role.IsSystem == 1 {
+		err := errors.New("system roles can't be changed")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := validatePermissions(form.Permissions); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	role.Description = strings.TrimSpace(form.Description)
+
+	if err := s.RbacRepository.UpdateRole(role, form.Permissions); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return s.Role(id)
+}
+
+func (s *RbacService) DeleteRole(id int) (map[string]interface{}, error) {
+	role, err := s.RbacRepository.FetchRoleByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if role.IsSystem == 1 {
+		err := errors.New("system roles can't be deleted")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.RbacRepository.DeleteRole(role); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func (s *RbacService) Permissions() (map[string]interface{}, error) {
+	permissions, err := s.RbacRepository.FetchPermissions()
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, permission := range permissions {
+		list = append(list, map[string]interface{}{
+			"name":        permission.Name,
+			"description": permission.Description,
+		})
+	}
+
+	return map[string]interface{}{
+		"permissions": list,
+	}, nil
+}
+
+func (s *RbacService) RoleUsers(id int) (map[string]interface{}, error) {
+	role, err := s.RbacRepository.FetchRoleByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	users, err := s.RbacRepository.FetchRoleUsers(role.ID)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, u := range users {
+		list = append(list, map[string]interface{}{
+			"id":        u.ID,
+			"email":     u.Email,
+			"full_name": u.FullName,
+		})
+	}
+
+	return map[string]interface{}{
+		"users": list,
+	}, nil
+}
+
+// AssignRole takes effect on the user's next token, access tokens already
+// issued keep the permissions they were signed with until they expire.
+func (s *RbacService) AssignRole(id, userID int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	role, err := s.RbacRepository.FetchRoleByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if _, err := s.RbacRepository.FetchUserByID(userID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if err := s.RbacRepository.AssignRole(userID, role.ID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, userID, event.TypeRoleAssigned, meta, map[string]interface{}{"role": role.Name})
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func (s *RbacService) UnassignRole(id, userID int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	role, err := s.RbacRepository.FetchRoleByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	// nobody would be left to give the role back
+	if role.Name == rbac.RoleAdmin {
+		count, err := s.RbacRepository.CountRoleUsers(role.ID)
+		if err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+		if count <= 1 {
+			err := errors.New("the last admin can't be removed")
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	}
+
+	if err := s.RbacRepository.UnassignRole(userID, role.ID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(s.EventRepository, userID, event.TypeRoleUnassigned, meta, map[string]interface{}{"role": role.Name})
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func NewRbacService(rbacRepository rbac.Repository, eventRepository event.Repository) rbac.Service {
+	return &RbacService{
+		RbacRepository:  rbacRepository,
+		EventRepository: eventRepository,
+	}
+}
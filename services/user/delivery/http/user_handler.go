@@ -6,12 +6,14 @@ import (
 	"github.com/ardiantirta/go-user-management/middleware"
 	"github.com/ardiantirta/go-user-management/models"
 	"github.com/ardiantirta/go-user-management/services/user"
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type UserHandler struct {
@@ -23,29 +25,41 @@ func NewUserHandler(r *mux.Router, userService user.Service) {
 		UserService: userService,
 	}
 
+	// registered ahead of the /me subrouter, it's the one route a session
+	// with an expired password may use
+	r.Handle("/me/password", handlers.LoggingHandler(
+		os.Stdout,
+		middleware.JwtAuthenticationAllowExpiredPassword(middleware.TwoFactorAuthentication(middleware.RateLimit("me")(http.HandlerFunc(handler.ChangePassword)))))).
+		Methods(http.MethodPost)
+
 	v1 := r.PathPrefix("/me").Subrouter()
 
 	v1.Use(middleware.JwtAuthentication)
 	v1.Use(middleware.TwoFactorAuthentication)
+	v1.Use(middleware.RateLimit("me"))
 
 	v1.Handle("", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.GetInfo))).Methods(http.MethodGet)
 	v1.Handle("", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.UpdateBasicInfo))).Methods(http.MethodPost)
 	v1.Handle("/email", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.GetEmailAddress))).Methods(http.MethodGet)
 	v1.Handle("/email", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.UpdateEmailAddress))).Methods(http.MethodPost)
-	v1.Handle("/password", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.ChangePassword))).Methods(http.MethodPost)
 	v1.Handle("/picture", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.SetProfilePicture))).Methods(http.MethodPost)
 	v1.Handle("/picture", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.DeleteProfilePicture))).Methods(http.MethodDelete)
 	v1.Handle("/tfa", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.TwoFactorAuthenticationStatus))).Methods(http.MethodGet)
 	v1.Handle("/tfa/enroll", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.TwoFactorAuthenticationSetup))).Methods(http.MethodGet)
 	v1.Handle("/tfa/enroll", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.ActivateTwoFactorAuthentication))).Methods(http.MethodPost)
+	v1.Handle("/tfa/backup-codes", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.BackupCodes))).Methods(http.MethodGet)
+	v1.Handle("/tfa/backup-codes", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.RegenerateBackupCodes))).Methods(http.MethodPost)
 	v1.Handle("/tfa/remove", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.RemoveTwoFactorAuthentication))).Methods(http.MethodPost)
 	v1.Handle("/events", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.ListEventData))).Methods(http.MethodGet)
 	v1.Handle("/delete", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.DeleteAccount))).Methods(http.MethodPost)
 	v1.Handle("/session", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.SessionLists))).Methods(http.MethodGet)
 	v1.Handle("/session", handlers.LoggingHandler(os. Stdout, http.HandlerFunc(handler.DeleteSession))).Methods(http.MethodDelete)
 	v1.Handle("/session/other", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.DeleteOtherSessions))).Methods(http.MethodDelete)
-	v1.Handle("/session/refresh/token", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.RefreshToken))).Methods(http.MethodGet)
-	v1.Handle("/session/access/token", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.NewAccessToken))).Methods(http.MethodGet)
+	v1.Handle("/session/{session_id}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.DeleteSession))).Methods(http.MethodDelete)
+	v1.Handle("/webauthn", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.WebAuthnCredentials))).Methods(http.MethodGet)
+	v1.Handle("/webauthn/register/begin", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.WebAuthnRegistrationBegin))).Methods(http.MethodPost)
+	v1.Handle("/webauthn/register/finish", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.WebAuthnRegistrationFinish))).Methods(http.MethodPost)
+	v1.Handle("/webauthn/{credential_id:[0-9]+}", handlers.LoggingHandler(os.Stdout, http.HandlerFunc(handler.DeleteWebAuthnCredential))).Methods(http.MethodDelete)
 }
 
 func (u *UserHandler) GetInfo(w http.ResponseWriter, r *http.Request) {
@@ -104,6 +118,12 @@ func (u *UserHandler) UpdateBasicInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if formData.Locale != "" && !helper.IsValidLocale(formData.Locale) {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "locale must be a language tag like en or pt-BR"))
+		return
+	}
+
 	response, err := u.UserService.UpdateBasicInfo(id, formData)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -146,7 +166,13 @@ func (u *UserHandler) UpdateEmailAddress(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	response, err := u.UserService.UpdateEmailAddress(id, formData.Email)
+	if err := validator.New().Var(formData.Email, "required,email,max=128"); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "email must be a valid email and not longer than 128 chars"))
+		return
+	}
+
+	response, err := u.UserService.UpdateEmailAddress(id, formData.Email, helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -176,19 +202,15 @@ func (u *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(formData.Password) < 6 || len(formData.Password) > 128 {
-		w.WriteHeader(http.StatusBadRequest)
-		helper.Response(w, helper.ErrorMessage(0, "password must between 6 and 128 chars"))
-		return
-	}
-
 	if formData.Password != formData.PasswordConfirm {
 		w.WriteHeader(http.StatusBadRequest)
 		helper.Response(w, helper.ErrorMessage(0, "password and password_confirm must be equal"))
 		return
 	}
 
-	response, err := u.UserService.ChangePassword(id, formData.Password)
+	tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
+
+	response, err := u.UserService.ChangePassword(id, tokenString, formData.PasswordCurrent, formData.Password, helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -287,9 +309,9 @@ func (u *UserHandler) ActivateTwoFactorAuthentication(w http.ResponseWriter, r *
 		return
 	}
 
-	if len(formData.Secret) != 20 {
+	if len(formData.Secret) != 32 {
 		w.WriteHeader(http.StatusBadRequest)
-		helper.Response(w, helper.ErrorMessage(0, "secret must be 20 chars"))
+		helper.Response(w, helper.ErrorMessage(0, "secret must be 32 chars"))
 		return
 	}
 
@@ -299,7 +321,7 @@ func (u *UserHandler) ActivateTwoFactorAuthentication(w http.ResponseWriter, r *
 		return
 	}
 
-	response, err := u.UserService.ActivateTwoFactorAuthentication(id, formData)
+	response, err := u.UserService.ActivateTwoFactorAuthentication(id, formData, helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -329,7 +351,54 @@ func (u *UserHandler) RemoveTwoFactorAuthentication(w http.ResponseWriter, r *ht
 		return
 	}
 
-	response, err := u.UserService.RemoveTwoFactorAuthentication(id, formData.Password)
+	response, err := u.UserService.RemoveTwoFactorAuthentication(id, formData.Password, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (u *UserHandler) BackupCodes(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "something is missing, try re-login"))
+		return
+	}
+
+	response, err := u.UserService.BackupCodes(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (u *UserHandler) RegenerateBackupCodes(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "something is missing, try re-login"))
+		return
+	}
+
+	formData := new(models.RegenerateBackupCodesForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	if formData.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "password is required"))
+		return
+	}
+
+	response, err := u.UserService.RegenerateBackupCodes(id, formData.Password, helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -346,7 +415,48 @@ func (u *UserHandler) ListEventData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := u.UserService.ListEventData(id)
+	query := r.URL.Query()
+
+	filter := new(models.EventFilter)
+	filter.Page, _ = strconv.Atoi(query.Get("page"))
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+
+	filter.PerPage, _ = strconv.Atoi(query.Get("per_page"))
+	if filter.PerPage < 1 || filter.PerPage > 100 {
+		filter.PerPage = 20
+	}
+
+	for _, t := range query["type"] {
+		for _, eventType := range strings.Split(t, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				filter.Types = append(filter.Types, eventType)
+			}
+		}
+	}
+
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			helper.Response(w, helper.ErrorMessage(0, "from must be an RFC 3339 timestamp"))
+			return
+		}
+		filter.From = &t
+	}
+
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			helper.Response(w, helper.ErrorMessage(0, "to must be an RFC 3339 timestamp"))
+			return
+		}
+		filter.To = &t
+	}
+
+	response, err := u.UserService.ListEventData(id, filter)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -376,7 +486,7 @@ func (u *UserHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := u.UserService.DeleteAccount(id, formData.Password)
+	response, err := u.UserService.DeleteAccount(id, formData.Password, helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -386,7 +496,22 @@ func (u *UserHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
 }
 
 func (u *UserHandler) SessionLists(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "something is missing, try re-login"))
+		return
+	}
 
+	tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
+
+	response, err := u.UserService.SessionLists(id, tokenString)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
 }
 
 func (u *UserHandler) DeleteSession(w http.ResponseWriter, r *http.Request) {
@@ -399,7 +524,7 @@ func (u *UserHandler) DeleteSession(w http.ResponseWriter, r *http.Request) {
 
 	tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
 
-	response, err := u.UserService.DeleteSession(id, tokenString)
+	response, err := u.UserService.DeleteSession(id, tokenString, mux.Vars(r)["session_id"], helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -418,7 +543,7 @@ func (u *UserHandler) DeleteOtherSessions(w http.ResponseWriter, r *http.Request
 
 	tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
 
-	response, err := u.UserService.DeleteOtherSessions(id, tokenString)
+	response, err := u.UserService.DeleteOtherSessions(id, tokenString, helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -427,7 +552,7 @@ func (u *UserHandler) DeleteOtherSessions(w http.ResponseWriter, r *http.Request
 	return
 }
 
-func (u *UserHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+func (u *UserHandler) WebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.Header.Get("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -435,7 +560,7 @@ func (u *UserHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := u.UserService.RefreshToken(id)
+	response, err := u.UserService.WebAuthnCredentials(id)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -444,7 +569,7 @@ func (u *UserHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func (u *UserHandler) NewAccessToken(w http.ResponseWriter, r *http.Request) {
+func (u *UserHandler) WebAuthnRegistrationBegin(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.Header.Get("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -452,9 +577,67 @@ func (u *UserHandler) NewAccessToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
+	response, err := u.UserService.WebAuthnRegistrationBegin(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (u *UserHandler) WebAuthnRegistrationFinish(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "something is missing, try re-login"))
+		return
+	}
+
+	formData := new(models.WebAuthnCredentialForm)
+	if err := json.NewDecoder(r.Body).Decode(&formData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid json body"))
+		return
+	}
+
+	if formData.Type != "public-key" {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "type must be public-key"))
+		return
+	}
+
+	if len(formData.Name) > 128 {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "name cannot more than 128 chars"))
+		return
+	}
+
+	response, err := u.UserService.WebAuthnRegistrationFinish(id, formData, helper.RequestMetadata(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	helper.Response(w, response)
+	return
+}
+
+func (u *UserHandler) DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "something is missing, try re-login"))
+		return
+	}
+
+	credentialID, err := strconv.Atoi(mux.Vars(r)["credential_id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		helper.Response(w, helper.ErrorMessage(0, "invalid credential id"))
+		return
+	}
 
-	response, err := u.UserService.NewAccessToken(id, tokenString)
+	response, err := u.UserService.DeleteWebAuthnCredential(id, credentialID, helper.RequestMetadata(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -4,13 +4,31 @@ import "github.com/ardiantirta/go-user-management/models"
 
 type Repository interface {
 	FetchUserByID(id int) (*models.User, error)
+	FetchUsers(filter *models.UserFilter) ([]*models.User, int, error)
 	SaveUser(user *models.User) error
+	ConsumeTFAStep(userID int, step int64) error
+	UpdatePassword(currentUser *models.User, hashedPassword, currentToken string) error
+	FetchPasswordHistory(currentUser *models.User, limit int) ([]string, error)
+	IsLastOrganizationOwner(userID int) (bool, error)
 	DeleteUser(user *models.User) error
 	DeleteUserToken(id int) error
 	DeleteUserTokenByToken(id int, token string) error
 	DeleteBackUpCodes(id int) error
-	CreateVerificationCode(id int) (*models.UserVerificationCode, error)
-	CreateBackUpCode(id int, codes []string) error
-	CreateNewToken(id int, newToken string) error
+	CreateEmailChange(currentUser *models.User, newEmail, cancelToken string) (*models.EmailChange, *models.UserVerificationCode, error)
+	FetchPendingEmailChange(userID int) (*models.EmailChange, error)
+	DeleteEmailChanges(userID int) error
+	IsEmailTaken(email string) (bool, error)
+	ReplaceBackUpCodes(id int, codes []string) error
+	FetchBackUpCodes(id int) ([]*models.BackUpCode, error)
+	DeleteOtherUserTokens(id int, currentToken string) error
 	CheckToken(id int, token string) error
+	FetchUserToken(userID int, token string) (*models.UserToken, error)
+	FetchUserSessions(userID int) ([]*models.UserToken, error)
+	DeleteUserTokenFamily(userID int, familyID string) error
+	TouchUserToken(userID int, token, ipAddress string) error
+	FetchWebAuthnCredentialsByUserID(userID int) ([]*models.WebAuthnCredential, error)
+	CreateWebAuthnCredential(credential *models.WebAuthnCredential) error
+	DeleteWebAuthnCredential(userID, id int) error
+	CreateWebAuthnSession(session *models.WebAuthnSession) error
+	ConsumeWebAuthnSession(challenge, sessionType string) (*models.WebAuthnSession, error)
 }
@@ -2,11 +2,13 @@ package repository
 
 import (
 	"errors"
-	"fmt"
 	"github.com/ardiantirta/go-user-management/helper"
 	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/passwordpolicy"
+	"github.com/ardiantirta/go-user-management/services/organization"
 	"github.com/ardiantirta/go-user-management/services/user"
 	"github.com/jinzhu/gorm"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -37,42 +39,284 @@ func (u UserRepository) SaveUser(user *models.User) error {
 	return nil
 }
 
-func (u UserRepository) CreateVerificationCode(id int) (*models.UserVerificationCode, error) {
-	verificationCode := new(models.UserVerificationCode)
-	verificationCode.UserID = id
-	verificationCode.Code = helper.GenerateRandomCode()
-	verificationCode.IsUsed = 0
+// ConsumeTFAStep moves tfa_last_step forward only if step is newer, so two
+// requests racing with the same code can't both succeed.
+func (u UserRepository) ConsumeTFAStep(userID int, step int64) error {
+	result := u.Conn.Table("users").
+		Where("id = ?", userID).
+		Where("coalesce(tfa_last_step, 0) < ?", step).
+		Update("tfa_last_step", step)
+	if err := result.Error; err != nil {
+		return errors.New("verify code failed")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("code already used, wait for the next one")
+	}
+
+	return nil
+}
+
+// UpdatePassword stores the new hash and keeps the previous ones for the
+// password policy's reuse check. Every session except the one making the
+// change is revoked, like a reset does.
+func (u UserRepository) UpdatePassword(currentUser *models.User, hashedPassword, currentToken string) error {
+	now := time.Now().UTC()
+	checkedAt := passwordpolicy.Current().ScreenedAt()
+
+	tx := u.Conn.Begin()
+
+	userToken := new(models.UserToken)
+	if err := tx.Table("user_tokens").
+		Where("user_id = ?", currentUser.ID).
+		Where("token = ?", currentToken).
+		Where("type = ?", helper.TokenTypeBearer).
+		First(&userToken).Error; err != nil {
+		tx.Rollback()
+		return errors.New("user token not found")
+	}
+
+	if err := tx.Table("users").
+		Where("id = ?", currentUser.ID).
+		Updates(map[string]interface{}{
+			"password":             hashedPassword,
+			"password_changed_at":  now,
+			"password_compromised": 0,
+			"password_checked_at":  checkedAt,
+		}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to update password")
+	}
+
+	if err := tx.Create(&models.PasswordHistory{UserID: int(currentUser.ID), Password: hashedPassword}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to update password")
+	}
+
+	if err := tx.Unscoped().Table("user_tokens").
+		Where("user_id = ?", currentUser.ID).
+		Where("family_id <> ?", userToken.FamilyID).
+		Delete(models.UserToken{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to update password")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to update password")
+	}
+
+	currentUser.Password = hashedPassword
+	currentUser.PasswordChangedAt = &now
+	currentUser.PasswordCompromised = 0
+	currentUser.PasswordCheckedAt = checkedAt
+
+	return nil
+}
+
+// FetchPasswordHistory returns the current hash followed by the previous
+// ones, newest first. The current hash is included so accounts created before
+// the history table existed are covered too.
+func (u UserRepository) FetchPasswordHistory(currentUser *models.User, limit int) ([]string, error) {
+	hashes := []string{currentUser.Password}
+	if limit <= 0 {
+		return hashes, nil
+	}
+
+	histories := make([]*models.PasswordHistory, 0)
+	if err := u.Conn.Table("password_histories").
+		Where("user_id = ?", currentUser.ID).
+		Order("id desc").
+		Limit(limit).
+		Find(&histories).Error; err != nil {
+		return nil, errors.New("failed to get password history")
+	}
+
+	for _, history := range histories {
+		if history.Password != currentUser.Password {
+			hashes = append(hashes, history.Password)
+		}
+	}
+
+	return hashes, nil
+}
+
+// CreateEmailChange replaces any change still pending for the user, only the
+// latest request can be confirmed or cancelled.
+func (u UserRepository) CreateEmailChange(currentUser *models.User, newEmail, cancelToken string) (*models.EmailChange, *models.UserVerificationCode, error) {
+	now := time.Now().UTC()
+
+	emailChange := new(models.EmailChange)
+	emailChange.UserID = int(currentUser.ID)
+	emailChange.OldEmail = currentUser.Email
+	emailChange.NewEmail = newEmail
+	emailChange.CancelToken = helper.HashToken(cancelToken)
+
+	tx := u.Conn.Begin()
+
+	if err := tx.Table("email_changes").
+		Where("user_id = ?", currentUser.ID).
+		Where("confirmed_at is null").
+		Where("cancelled_at is null").
+		Update("cancelled_at", now).Error; err != nil {
+		tx.Rollback()
+		return nil, nil, errors.New("error when create email change")
+	}
+
+	verificationCode, err := helper.CreateVerificationCode(tx, int(currentUser.ID), helper.VerificationPurposeEmailChange)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, errors.New("error when create email change")
+	}
+
+	emailChange.VerificationCodeID = verificationCode.ID
+	emailChange.ExpiredAt = *verificationCode.ExpiredAt
+	if err := tx.Create(&emailChange).Error; err != nil {
+		tx.Rollback()
+		return nil, nil, errors.New("error when create email change")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, nil, errors.New("error when create email change")
+	}
+
+	return emailChange, verificationCode, nil
+}
+
+func (u UserRepository) FetchPendingEmailChange(userID int) (*models.EmailChange, error) {
+	emailChange := new(models.EmailChange)
+
+	if err := u.Conn.Table("email_changes").
+		Where("user_id = ?", userID).
+		Where("confirmed_at is null").
+		Where("cancelled_at is null").
+		Where("expired_at > ?", time.Now().UTC()).
+		Order("id desc").
+		First(&emailChange).Error; err != nil {
+		return nil, errors.New("no pending email change")
+	}
+
+	return emailChange, nil
+}
+
+func (u UserRepository) DeleteEmailChanges(userID int) error {
+	if err := u.Conn.Unscoped().Table("email_changes").
+		Where("user_id = ?", userID).
+		Delete(models.EmailChange{}).Error; err != nil {
+		return errors.New("failed to delete email changes")
+	}
+
+	return nil
+}
+
+func (u UserRepository) IsEmailTaken(email string) (bool, error) {
+	count := 0
+
+	if err := u.Conn.Table("users").
+		Where("lower(email) = ?", strings.ToLower(email)).
+		Count(&count).Error; err != nil {
+		return false, errors.New("connection error. please retry")
+	}
+
+	return count > 0, nil
+}
 
-	if err := u.Conn.Create(&verificationCode).Error; err != nil {
-		return nil, errors.New("error when create verification code")
+// FetchUsers matches Query against the email and full name, roles are
+// matched by name.
+func (u UserRepository) FetchUsers(filter *models.UserFilter) ([]*models.User, int, error) {
+	users := make([]*models.User, 0)
+	total := 0
+
+	query := u.Conn.Table("users").Select("users.*")
+
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		pattern := "%" + strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(strings.ToLower(q)) + "%"
+		query = query.Where("lower(users.email) like ? or lower(users.full_name) like ?", pattern, pattern)
 	}
 
-	return verificationCode, nil
+	if filter.IsVerified != nil {
+		query = query.Where("users.is_verified = ?", boolToInt(*filter.IsVerified))
+	}
+
+	if filter.IsActive != nil {
+		query = query.Where("users.is_active = ?", boolToInt(*filter.IsActive))
+	}
+
+	if filter.Role != "" {
+		query = query.
+			Joins("join user_roles on user_roles.user_id = users.id").
+			Joins("join roles on roles.id = user_roles.role_id").
+			Where("roles.name = ?", filter.Role).
+			Where("roles.deleted_at is null")
+	}
+
+	if filter.OrganizationID != 0 {
+		query = query.
+			Joins("join organization_members on organization_members.user_id = users.id").
+			Where("organization_members.organization_id = ?", filter.OrganizationID)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, errors.New("failed to get users")
+	}
+
+	if err := query.
+		Order("users.id").
+		Offset((filter.Page - 1) * filter.PerPage).
+		Limit(filter.PerPage).
+		Find(&users).Error; err != nil {
+		return nil, 0, errors.New("failed to get users")
+	}
+
+	return users, total, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
 
-func (u UserRepository) CreateBackUpCode(id int, codes []string) error {
-	query := `insert into back_up_codes (user_id, code, created_at, updated_at) values `
-	countInsert := 0
-	createdAt := time.Now().Format(helper.FormatRFC8601)
-	for _, c := range codes {
-		values := fmt.Sprintf("" +
-			"(%d, '%s', '%s', '%s'),",
-			id, c, createdAt, createdAt,
-			)
-		query = query + values
-		countInsert++
+// ReplaceBackUpCodes drops every existing code, used or not, so only the set
+// just shown to the user works.
+func (u UserRepository) ReplaceBackUpCodes(id int, codes []string) error {
+	tx := u.Conn.Begin()
+
+	if err := tx.Unscoped().Table("back_up_codes").
+		Where("user_id = ?", id).
+		Delete(models.BackUpCode{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to create backup_codes")
 	}
 
-	if countInsert > 0 {
-		query = strings.TrimSuffix(query, ",")
-		if err := u.Conn.Exec(query).Error; err != nil {
+	for _, code := range codes {
+		backUpCode := &models.BackUpCode{UserID: id, Code: helper.HashBackupCode(code)}
+		if err := tx.Create(&backUpCode).Error; err != nil {
+			tx.Rollback()
 			return errors.New("failed to create backup_codes")
 		}
 	}
 
+	if err := tx.Commit().Error; err != nil {
+		return errors.New("failed to create backup_codes")
+	}
+
 	return nil
 }
 
+func (u UserRepository) FetchBackUpCodes(id int) ([]*models.BackUpCode, error) {
+	codes := make([]*models.BackUpCode, 0)
+
+	if err := u.Conn.Table("back_up_codes").
+		Where("user_id = ?", id).
+		Order("id").
+		Find(&codes).Error; err != nil {
+		return nil, errors.New("failed to get backup_codes")
+	}
+
+	return codes, nil
+}
+
 func (u UserRepository) DeleteBackUpCodes(id int) error {
 	if err := u.Conn.Unscoped().Table("back_up_codes").
 		Where("user_id = ?", id).
@@ -83,8 +327,109 @@ func (u UserRepository) DeleteBackUpCodes(id int) error {
 	return nil
 }
 
+// IsLastOrganizationOwner is true when the user is the only owner of any
+// organization, an organization always needs one.
+func (u UserRepository) IsLastOrganizationOwner(userID int) (bool, error) {
+	lastOwner, err := isLastOrganizationOwner(u.Conn, userID)
+	if err != nil {
+		return false, errors.New("failed to check organization ownership")
+	}
+
+	return lastOwner, nil
+}
+
+func isLastOrganizationOwner(conn *gorm.DB, userID int) (bool, error) {
+	count := 0
+
+	if err := conn.Table("organization_members").
+		Where("user_id = ?", userID).
+		Where("role = ?", organization.RoleOwner).
+		Where("not exists (select 1 from organization_members others where others.organization_id = organization_members.organization_id and others.role = ? and others.user_id <> ?)", organization.RoleOwner, userID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// DeleteUser removes the account together with every row keyed by its id, in
+// one transaction so a failure can't leave half an account behind. The event
+// log is append-only and outlives the account.
 func (u UserRepository) DeleteUser(user *models.User) error {
-	if err := u.Conn.Unscoped().Delete(&user).Error; err != nil {
+	userRows := []interface{}{
+		models.UserVerificationCode{},
+		models.PasswordResetToken{},
+		models.EmailChange{},
+		models.PasswordHistory{},
+		models.UserToken{},
+		models.BackUpCode{},
+		models.WebAuthnCredential{},
+		models.WebAuthnSession{},
+		models.OauthAuthorizationCode{},
+		models.OauthConsent{},
+		models.UserRole{},
+		models.OrganizationMember{},
+		models.GroupMember{},
+	}
+
+	tx := u.Conn.Begin()
+
+	// checked again inside the transaction so an owner leaving at the same
+	// time can't orphan the organization
+	lastOwner, err := isLastOrganizationOwner(tx, int(user.ID))
+	if err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete user")
+	}
+
+	if lastOwner {
+		tx.Rollback()
+		return errors.New("the last owner of an organization can't be deleted, transfer ownership first")
+	}
+
+	// invitations the account sent stop working with it
+	now := time.Now().UTC()
+	if err := tx.Table("user_verification_codes").
+		Where("id in (select verification_code_id from organization_invitations where invited_by = ? and accepted_at is null and revoked_at is null and deleted_at is null)", user.ID).
+		Where("revoked_at is null").
+		Update("revoked_at", now).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete user")
+	}
+
+	if err := tx.Table("organization_invitations").
+		Where("invited_by = ?", user.ID).
+		Where("accepted_at is null").
+		Where("revoked_at is null").
+		Where("deleted_at is null").
+		Updates(map[string]interface{}{"revoked_at": now, "updated_at": now}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete user")
+	}
+
+	for _, model := range userRows {
+		if err := tx.Unscoped().
+			Where("user_id = ?", user.ID).
+			Delete(model).Error; err != nil {
+			tx.Rollback()
+			return errors.New("failed to delete user")
+		}
+	}
+
+	if err := tx.Unscoped().Table("auth_failures").
+		Where("scope = ?", helper.AuthFailureScopeAccount).
+		Where("key = ?", strconv.Itoa(int(user.ID))).
+		Delete(models.AuthFailure{}).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete user")
+	}
+
+	if err := tx.Unscoped().Delete(&user).Error; err != nil {
+		tx.Rollback()
+		return errors.New("failed to delete user")
+	}
+
+	if err := tx.Commit().Error; err != nil {
 		return errors.New("failed to delete user")
 	}
 
@@ -102,27 +447,42 @@ func (u UserRepository) DeleteUserToken(userID int) error {
 }
 
 func (u UserRepository) DeleteUserTokenByToken(userID int, token string) error {
-	if err := u.Conn.Unscoped().Table("user_tokens").
+	userToken := new(models.UserToken)
+
+	if err := u.Conn.Table("user_tokens").
 		Where("user_id = ?", userID).
 		Where("token = ?", token).
+		Where("type = ?", helper.TokenTypeBearer).
+		First(&userToken).Error; err != nil {
+			return errors.New("user token not found")
+	}
+
+	if err := u.Conn.Unscoped().Table("user_tokens").
+		Where("user_id = ?", userID).
+		Where("family_id = ?", userToken.FamilyID).
 		Delete(models.UserToken{}).Error; err != nil {
 			return errors.New("failed to delete user token")
 	}
 
-	fmt.Println(userID, token)
-
 	return nil
 }
 
-func (u UserRepository) CreateNewToken(id int, newToken string) error {
-	token := new(models.UserToken)
+func (u UserRepository) DeleteOtherUserTokens(userID int, currentToken string) error {
+	userToken := new(models.UserToken)
 
-	token.Type = "Bearer"
-	token.UserID = id
-	token.Token = newToken
+	if err := u.Conn.Table("user_tokens").
+		Where("user_id = ?", userID).
+		Where("token = ?", currentToken).
+		Where("type = ?", helper.TokenTypeBearer).
+		First(&userToken).Error; err != nil {
+			return errors.New("user token not found")
+	}
 
-	if err := u.Conn.Create(&token).Error; err != nil {
-		return errors.New("failed to create new token")
+	if err := u.Conn.Unscoped().Table("user_tokens").
+		Where("user_id = ?", userID).
+		Where("family_id <> ?", userToken.FamilyID).
+		Delete(models.UserToken{}).Error; err != nil {
+			return errors.New("failed to delete user token")
 	}
 
 	return nil
@@ -134,6 +494,7 @@ func (u UserRepository) CheckToken(id int, token string) error {
 	if err := u.Conn.Table("user_tokens").
 		Where("user_id = ?", id).
 		Where("token = ?", token).
+		Where("type = ?", helper.TokenTypeBearer).
 		First(&userToken).Error; err != nil {
 			return errors.New("user token not found")
 	}
@@ -141,6 +502,134 @@ func (u UserRepository) CheckToken(id int, token string) error {
 	return nil
 }
 
+func (u UserRepository) FetchUserToken(userID int, token string) (*models.UserToken, error) {
+	userToken := new(models.UserToken)
+
+	if err := u.Conn.Table("user_tokens").
+		Where("user_id = ?", userID).
+		Where("token = ?", token).
+		Where("type = ?", helper.TokenTypeBearer).
+		First(&userToken).Error; err != nil {
+			return nil, errors.New("user token not found")
+	}
+
+	return userToken, nil
+}
+
+func (u UserRepository) FetchUserSessions(userID int) ([]*models.UserToken, error) {
+	userTokens := make([]*models.UserToken, 0)
+
+	if err := u.Conn.Table("user_tokens").
+		Where("user_id = ?", userID).
+		Where("rotated_at is null").
+		Where("expired_at > ?", time.Now().UTC()).
+		Order("session_started_at desc").
+		Find(&userTokens).Error; err != nil {
+			return nil, errors.New("failed to get sessions")
+	}
+
+	return userTokens, nil
+}
+
+func (u UserRepository) DeleteUserTokenFamily(userID int, familyID string) error {
+	result := u.Conn.Unscoped().Table("user_tokens").
+		Where("user_id = ?", userID).
+		Where("family_id = ?", familyID).
+		Delete(models.UserToken{})
+	if err := result.Error; err != nil {
+		return errors.New("failed to delete session")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("session not found")
+	}
+
+	return nil
+}
+
+// TouchUserToken records activity on a token at most once a minute so
+// authenticated requests don't all turn into writes.
+func (u UserRepository) TouchUserToken(userID int, token, ipAddress string) error {
+	now := time.Now().UTC()
+
+	if err := u.Conn.Table("user_tokens").
+		Where("user_id = ?", userID).
+		Where("token = ?", token).
+		Where("type = ?", helper.TokenTypeBearer).
+		Where("last_seen_at is null or last_seen_at < ?", now.Add(-time.Minute)).
+		Updates(map[string]interface{}{"last_seen_at": now, "ip_address": ipAddress}).Error; err != nil {
+			return errors.New("failed to update user token")
+	}
+
+	return nil
+}
+
+func (u UserRepository) FetchWebAuthnCredentialsByUserID(userID int) ([]*models.WebAuthnCredential, error) {
+	credentials := make([]*models.WebAuthnCredential, 0)
+
+	if err := u.Conn.Table("web_authn_credentials").
+		Where("user_id = ?", userID).
+		Order("id").
+		Find(&credentials).Error; err != nil {
+			return nil, errors.New("failed to get webauthn credentials")
+	}
+
+	return credentials, nil
+}
+
+func (u UserRepository) CreateWebAuthnCredential(credential *models.WebAuthnCredential) error {
+	if err := u.Conn.Create(&credential).Error; err != nil {
+		return errors.New("failed to save webauthn credential")
+	}
+
+	return nil
+}
+
+func (u UserRepository) DeleteWebAuthnCredential(userID, id int) error {
+	result := u.Conn.Unscoped().Table("web_authn_credentials").
+		Where("user_id = ?", userID).
+		Where("id = ?", id).
+		Delete(models.WebAuthnCredential{})
+	if err := result.Error; err != nil {
+		return errors.New("failed to delete webauthn credential")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("webauthn credential not found")
+	}
+
+	return nil
+}
+
+func (u UserRepository) CreateWebAuthnSession(session *models.WebAuthnSession) error {
+	if err := u.Conn.Create(&session).Error; err != nil {
+		return errors.New("failed to create webauthn session")
+	}
+
+	return nil
+}
+
+func (u UserRepository) ConsumeWebAuthnSession(challenge, sessionType string) (*models.WebAuthnSession, error) {
+	session := new(models.WebAuthnSession)
+
+	if err := u.Conn.Table("web_authn_sessions").
+		Where("challenge = ?", challenge).
+		Where("type = ?", sessionType).
+		First(&session).Error; err != nil {
+			return nil, errors.New("webauthn session not found")
+	}
+
+	if result := u.Conn.Unscoped().Delete(&session); result.Error != nil || result.RowsAffected == 0 {
+		return nil, errors.New("webauthn session not found")
+	}
+
+	if session.ExpiredAt.Before(time.Now().UTC()) {
+		return nil, errors.New("webauthn session expired")
+	}
+
+	return session, nil
+}
+
 func NewUserRepository(conn *gorm.DB) user.Repository {
 	return &UserRepository{
 		Conn: conn,
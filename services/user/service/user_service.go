@@ -1,18 +1,26 @@
 package service
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/ardiantirta/go-user-management/helper"
+	"github.com/ardiantirta/go-user-management/mailer"
 	"github.com/ardiantirta/go-user-management/models"
+	"github.com/ardiantirta/go-user-management/passwordhash"
+	"github.com/ardiantirta/go-user-management/passwordpolicy"
+	"github.com/ardiantirta/go-user-management/services/event"
 	"github.com/ardiantirta/go-user-management/services/user"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/ardiantirta/go-user-management/webauthn"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type UserService struct {
-	UserRepository user.Repository
+	UserRepository  user.Repository
+	EventRepository event.Repository
 }
 
 func (u UserService) GetInfo(id int) (map[string]interface{}, error) {
@@ -31,6 +39,7 @@ func (u UserService) GetInfo(id int) (map[string]interface{}, error) {
 			"bio": currentUser.Bio,
 			"web": currentUser.Web,
 			"picture": currentUser.Picture,
+			"locale": currentUser.Locale,
 			"created_at": createdAt,
 		},
 	}, nil
@@ -46,6 +55,9 @@ func (u UserService) UpdateBasicInfo(id int, data *models.UpdateUserInfoForm) (m
 	currentUser.Location = data.Location
 	currentUser.Bio = data.Bio
 	currentUser.Web = data.Web
+	if data.Locale != "" {
+		currentUser.Locale = data.Locale
+	}
 
 	if err := u.UserRepository.SaveUser(currentUser); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
@@ -62,56 +74,108 @@ func (u UserService) GetEmailAddress(id int) (map[string]interface{}, error) {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	return map[string]interface{}{
+	response := map[string]interface{}{
 		"email": currentUser.Email,
-	}, nil
+	}
+
+	if emailChange, err := u.UserRepository.FetchPendingEmailChange(id); err == nil {
+		response["pending_email"] = emailChange.NewEmail
+		response["pending_email_expired_at"] = emailChange.ExpiredAt.Format(helper.FormatRFC8601)
+	}
+
+	return response, nil
 }
 
-func (u UserService) UpdateEmailAddress(id int, email string) (map[string]interface{}, error) {
+func (u UserService) UpdateEmailAddress(id int, email string, meta *models.RequestMetadata) (map[string]interface{}, error) {
 	currentUser, err := u.UserRepository.FetchUserByID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	verificationCode, err := u.UserRepository.CreateVerificationCode(id)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == strings.ToLower(currentUser.Email) {
+		err := errors.New("new email must be different from the current one")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	taken, err := u.UserRepository.IsEmailTaken(email)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if taken {
+		err := errors.New("email already exist")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	cancelToken, err := helper.GenerateOpaqueToken()
+	if err != nil {
+		return helper.ErrorMessage(0, "error when create email change"), err
+	}
+
+	emailChange, verificationCode, err := u.UserRepository.CreateEmailChange(currentUser, email, cancelToken)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	sg := new(models.SendGridEmail)
-	sg.From = mail.NewEmail("User Example 1", "user1@example.com")
-	sg.To = mail.NewEmail(currentUser.FullName, email)
-	sg.Subject = "Update Email Verification"
-	sg.PlainContent = "please verify your email"
-	sg.HtmlContent = fmt.Sprintf(`<a href="http://localhost:3000/auth/verification/%s">email verification</a>`, verificationCode.Code)
-	if err := helper.SendVerificationByEmail(sg); err != nil {
+	if err := mailer.SendTemplate(mailer.Address{Name: currentUser.FullName, Email: email}, currentUser.Locale, mailer.TemplateEmailChange, map[string]interface{}{
+		"Link":     helper.VerificationURL(verificationCode),
+		"NewEmail": email,
+	}); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	currentUser.IsActive = 0
-	currentUser.IsVerified = 0
-	err = u.UserRepository.SaveUser(currentUser); if err != nil {
+	if err := mailer.SendTemplate(mailer.Address{Name: currentUser.FullName, Email: currentUser.Email}, currentUser.Locale, mailer.TemplateEmailChangeNotice, map[string]interface{}{
+		"Link":     helper.PublicURL("/auth/email/cancel/" + cancelToken),
+		"NewEmail": email,
+	}); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	event.Record(u.EventRepository, id, event.TypeEmailChangeRequested, meta, map[string]interface{}{"email": email})
+
 	return map[string]interface{}{
 		"status": true,
+		"pending_email": emailChange.NewEmail,
+		"expired_at": emailChange.ExpiredAt.Format(helper.FormatRFC8601),
 	}, nil
 }
 
-func (u UserService) ChangePassword(id int, password string) (map[string]interface{}, error) {
+func (u UserService) ChangePassword(id int, currentToken, passwordCurrent, password string, meta *models.RequestMetadata) (map[string]interface{}, error) {
 	currentUser, err := u.UserRepository.FetchUserByID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	currentUser.Password = string(hashedPassword)
+	if ok, _ := passwordhash.Verify(currentUser.Password, passwordCurrent); !ok {
+		return helper.ErrorMessage(0, "password_current didn't match"), errors.New("password_current didn't match")
+	}
+
+	policy := passwordpolicy.Current()
+	if violations := policy.Check(password, passwordpolicy.UserInfo{Email: currentUser.Email, FullName: currentUser.FullName}); violations != nil {
+		return passwordpolicy.ErrorMessage(violations), violations
+	}
+
+	history, err := u.UserRepository.FetchPasswordHistory(currentUser, policy.History)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if violations := policy.CheckHistory(password, history); violations != nil {
+		return passwordpolicy.ErrorMessage(violations), violations
+	}
 
-	if err := u.UserRepository.SaveUser(currentUser); err != nil {
+	hashedPassword, err := passwordhash.Hash(password)
+	if err != nil {
+		return helper.ErrorMessage(0, "failed to update password"), err
+	}
+
+	if err := u.UserRepository.UpdatePassword(currentUser, hashedPassword, currentToken); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	event.Record(u.EventRepository, id, event.TypePasswordChanged, meta, nil)
+
 	return map[string]interface{}{
 		"status": true,
 	}, nil
@@ -180,23 +244,32 @@ func (u UserService) TwoFactorAuthenticationSetup(id int) (map[string]interface{
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	secretCode := helper.GenerateSecretCode(20)
-	TFACode := helper.GenerateTFACode(6)
-	qrCode := helper.GenerateQRCode(TFACode)
+	if currentUser.IsTFA == 1 {
+		err := errors.New("two factor authentication is already enabled")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	secretCode, err := helper.GenerateTOTPSecret()
+	if err != nil {
+		return helper.ErrorMessage(0, "failed to generate secret"), err
+	}
+	uri := helper.TOTPProvisioningURI(secretCode, currentUser.Email)
+	qrCode := helper.GenerateQRCode(uri)
 
 	currentUser.SecretCode = secretCode
-	currentUser.TFACode = TFACode
+	currentUser.TFALastStep = 0
 	if err := u.UserRepository.SaveUser(currentUser); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
 	return map[string]interface{}{
 		"secret": secretCode,
+		"uri": uri,
 		"qr": qrCode,
 	}, nil
 }
 
-func (u UserService) ActivateTwoFactorAuthentication(id int, tfa *models.ActivateTFAForm) (map[string]interface{}, error) {
+func (u UserService) ActivateTwoFactorAuthentication(id int, tfa *models.ActivateTFAForm, meta *models.RequestMetadata) (map[string]interface{}, error) {
 	currentUser, err := u.UserRepository.FetchUserByID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
@@ -207,40 +280,58 @@ func (u UserService) ActivateTwoFactorAuthentication(id int, tfa *models.Activat
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	if currentUser.TFACode != tfa.Code {
+	now := time.Now().UTC()
+
+	step, ok := helper.ValidateTOTPCode(currentUser.SecretCode, tfa.Code, now, currentUser.TFALastStep)
+	if !ok {
 		err := errors.New("wrong code")
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	now := time.Now().UTC()
+	if err := u.UserRepository.ConsumeTFAStep(id, step); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
 
+	// keep the consumed step, SaveUser writes every column back
+	currentUser.TFALastStep = step
 	currentUser.IsTFA = 1
 	currentUser.TFAActivation = &now
 	if err := u.UserRepository.SaveUser(currentUser); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	backUpCodes := make([]string, 0)
-	backUpCodes = append(backUpCodes, helper.GenerateSecretCode(12), helper.GenerateSecretCode(12))
-	if err := u.UserRepository.CreateBackUpCode(id, backUpCodes); err != nil {
+	backUpCodes, err := helper.GenerateBackupCodes(helper.BackupCodeCount())
+	if err != nil {
+		return helper.ErrorMessage(0, "failed to create backup_codes"), err
+	}
+
+	if err := u.UserRepository.ReplaceBackUpCodes(id, backUpCodes); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	event.Record(u.EventRepository, id, event.TypeTFAEnabled, meta, nil)
+	mailer.SendSecurityNotice(currentUser, mailer.TemplateTFAEnabled, meta)
+
 	return map[string]interface{}{
 		"backup_codes": backUpCodes,
 	}, nil
 }
 
-func (u UserService) RemoveTwoFactorAuthentication(id int, password string) (map[string]interface{}, error) {
+func (u UserService) RemoveTwoFactorAuthentication(id int, password string, meta *models.RequestMetadata) (map[string]interface{}, error) {
 	currentUser, err := u.UserRepository.FetchUserByID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	if ok, _ := passwordhash.Verify(currentUser.Password, password); !ok {
+		err := errors.New("password didn't match")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
 	currentUser.TFAActivation = nil
 	currentUser.IsTFA = 0
 	currentUser.SecretCode = ""
-	currentUser.TFACode = ""
+	currentUser.TFALastStep = 0
 	if err := u.UserRepository.SaveUser(currentUser); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
@@ -249,133 +340,403 @@ func (u UserService) RemoveTwoFactorAuthentication(id int, password string) (map
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	event.Record(u.EventRepository, id, event.TypeTFADisabled, meta, nil)
+	mailer.SendSecurityNotice(currentUser, mailer.TemplateTFADisabled, meta)
+
 	return map[string]interface{}{
 		"status": true,
 	}, nil
 }
 
-func (u UserService) ListEventData(id int) (map[string]interface{}, error) {
+func (u UserService) BackupCodes(id int) (map[string]interface{}, error) {
 	currentUser, err := u.UserRepository.FetchUserByID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	if currentUser.IsTFA != 1 {
+		err := errors.New("two factor authentication is not enabled")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	codes, err := u.UserRepository.FetchBackUpCodes(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	remaining := 0
+	for _, code := range codes {
+		if code.UsedAt == nil {
+			remaining++
+		}
+	}
+
+	response := map[string]interface{}{
+		"total":     len(codes),
+		"remaining": remaining,
+		"low":       remaining <= helper.BackupCodeLowThreshold(),
+	}
+	if len(codes) > 0 {
+		response["generated_at"] = codes[0].CreatedAt.Format(helper.FormatRFC8601)
+	}
+
+	return response, nil
+}
+
+// RegenerateBackupCodes asks for the password again, a stolen session alone
+// must not be enough to mint a fresh way past two factor authentication.
+func (u UserService) RegenerateBackupCodes(id int, password string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	currentUser, err := u.UserRepository.FetchUserByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if ok, _ := passwordhash.Verify(currentUser.Password, password); !ok {
+		err := errors.New("password didn't match")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	if currentUser.IsTFA != 1 {
+		err := errors.New("two factor authentication is not enabled")
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	backUpCodes, err := helper.GenerateBackupCodes(helper.BackupCodeCount())
+	if err != nil {
+		return helper.ErrorMessage(0, "failed to create backup_codes"), err
+	}
+
+	if err := u.UserRepository.ReplaceBackUpCodes(id, backUpCodes); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(u.EventRepository, id, event.TypeTFABackupCodesRegenerated, meta, nil)
+
 	return map[string]interface{}{
-		"status": true,
-		"user": currentUser,
+		"backup_codes": backUpCodes,
 	}, nil
 }
 
-func (u UserService) DeleteAccount(id int, password string) (map[string]interface{}, error) {
-	currentUser, err := u.UserRepository.FetchUserByID(id)
+func (u UserService) ListEventData(id int, filter *models.EventFilter) (map[string]interface{}, error) {
+	for _, t := range filter.Types {
+		known := false
+		for _, eventType := range event.Types {
+			if t == eventType {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			err := errors.New("unknown event type " + t)
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	}
+
+	events, total, err := u.EventRepository.FetchEvents(id, filter)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(currentUser.Password), []byte(password)); err != nil {
-		return helper.ErrorMessage(0, "password didn't match"), err
+	list := make([]map[string]interface{}, 0)
+	for _, e := range events {
+		data := make(map[string]interface{})
+		if e.Data != "" {
+			_ = json.Unmarshal([]byte(e.Data), &data)
+		}
+
+		list = append(list, map[string]interface{}{
+			"id": e.ID,
+			"type": e.Type,
+			"ip_address": e.IPAddress,
+			"user_agent": e.UserAgent,
+			"data": data,
+			"created_at": e.CreatedAt.Format(helper.FormatRFC8601),
+		})
 	}
 
-	if err := u.UserRepository.DeleteUser(currentUser); err != nil {
-		return helper.ErrorMessage(0,  err.Error()), err
+	return map[string]interface{}{
+		"events": list,
+		"page": filter.Page,
+		"per_page": filter.PerPage,
+		"total": total,
+	}, nil
+}
+
+func (u UserService) DeleteAccount(id int, password string, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	currentUser, err := u.UserRepository.FetchUserByID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	if err := u.UserRepository.DeleteBackUpCodes(id); err != nil {
+	if ok, _ := passwordhash.Verify(currentUser.Password, password); !ok {
+		return helper.ErrorMessage(0, "password didn't match"), errors.New("password didn't match")
+	}
+
+	lastOwner, err := u.UserRepository.IsLastOrganizationOwner(id)
+	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	if err := u.UserRepository.DeleteUserToken(id); err != nil {
+	if lastOwner {
+		err := errors.New("the last owner of an organization can't be deleted, transfer ownership first")
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	event.Record(u.EventRepository, id, event.TypeAccountDeleted, meta, nil)
+
+	if err := u.UserRepository.DeleteUser(currentUser); err != nil {
+		return helper.ErrorMessage(0,  err.Error()), err
+	}
+
 	return map[string]interface{}{
 		"status": true,
 	}, nil
 }
 
-func (u UserService) SessionLists(id int) (map[string]interface{}, error) {
-	panic("implement me")
+func (u UserService) SessionLists(id int, currentToken string) (map[string]interface{}, error) {
+	currentUserToken, err := u.UserRepository.FetchUserToken(id, currentToken)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	userTokens, err := u.UserRepository.FetchUserSessions(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	// a session is a token family, its most recently seen token holds the
+	// latest device and ip address
+	latest := make(map[string]*models.UserToken)
+	order := make([]string, 0)
+	for _, t := range userTokens {
+		l, ok := latest[t.FamilyID]
+		if !ok {
+			order = append(order, t.FamilyID)
+		}
+		if !ok || (t.LastSeenAt != nil && (l.LastSeenAt == nil || t.LastSeenAt.After(*l.LastSeenAt))) {
+			latest[t.FamilyID] = t
+		}
+	}
+
+	sessions := make([]map[string]interface{}, 0)
+	for _, familyID := range order {
+		t := latest[familyID]
+
+		item := map[string]interface{}{
+			"id":           t.FamilyID,
+			"user_agent":   t.UserAgent,
+			"browser":      t.Browser,
+			"os":           t.OS,
+			"device":       t.Device,
+			"ip_address":   t.IPAddress,
+			"client_id":    t.ClientID,
+			"created_at":   nil,
+			"last_seen_at": nil,
+			"current":      t.FamilyID == currentUserToken.FamilyID,
+		}
+		if t.SessionStartedAt != nil {
+			item["created_at"] = t.SessionStartedAt.Format(helper.FormatRFC8601)
+		}
+		if t.LastSeenAt != nil {
+			item["last_seen_at"] = t.LastSeenAt.Format(helper.FormatRFC8601)
+		}
+
+		sessions = append(sessions, item)
+	}
+
+	return map[string]interface{}{
+		"sessions": sessions,
+	}, nil
 }
 
-func (u UserService) DeleteSession(id int, currentToken string) (map[string]interface{}, error) {
+func (u UserService) DeleteSession(id int, currentToken, sessionID string, meta *models.RequestMetadata) (map[string]interface{}, error) {
 	_, err := u.UserRepository.FetchUserByID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	if err := u.UserRepository.DeleteUserTokenByToken(id, currentToken); err != nil {
+	if sessionID == "" {
+		if err := u.UserRepository.DeleteUserTokenByToken(id, currentToken); err != nil {
+			return helper.ErrorMessage(0, err.Error()), err
+		}
+	} else if err := u.UserRepository.DeleteUserTokenFamily(id, sessionID); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	data := map[string]interface{}{"current": sessionID == ""}
+	if sessionID != "" {
+		data["session_id"] = sessionID
+	}
+	event.Record(u.EventRepository, id, event.TypeSessionRevoked, meta, data)
+
 	return map[string]interface{}{
 		"status": true,
 	}, nil
 }
 
-func (u UserService) DeleteOtherSessions(id int, currentToken string) (map[string]interface{}, error) {
+func (u UserService) DeleteOtherSessions(id int, currentToken string, meta *models.RequestMetadata) (map[string]interface{}, error) {
 	_, err := u.UserRepository.FetchUserByID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	if err := u.UserRepository.DeleteUserToken(id); err != nil {
+	if err := u.UserRepository.DeleteOtherUserTokens(id, currentToken); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	if err := u.UserRepository.CreateNewToken(id, currentToken); err != nil {
+	event.Record(u.EventRepository, id, event.TypeOtherSessionsRevoked, meta, nil)
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func (u UserService) WebAuthnCredentials(id int) (map[string]interface{}, error) {
+	credentials, err := u.UserRepository.FetchWebAuthnCredentialsByUserID(id)
+	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
+	list := make([]map[string]interface{}, 0)
+	for _, c := range credentials {
+		item := map[string]interface{}{
+			"id": c.ID,
+			"name": c.Name,
+			"created_at": c.CreatedAt.Format(helper.FormatRFC8601),
+			"last_used_at": nil,
+		}
+		if c.LastUsedAt != nil {
+			item["last_used_at"] = c.LastUsedAt.Format(helper.FormatRFC8601)
+		}
+		list = append(list, item)
+	}
+
 	return map[string]interface{}{
-		"status": true,
+		"credentials": list,
 	}, nil
 }
 
-func (u UserService) RefreshToken(id int) (map[string]interface{}, error) {
+func (u UserService) WebAuthnRegistrationBegin(id int) (map[string]interface{}, error) {
 	currentUser, err := u.UserRepository.FetchUserByID(id)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	newUserToken := helper.RefreshToken(currentUser)
+	credentials, err := u.UserRepository.FetchWebAuthnCredentialsByUserID(id)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	exclude := make([][]byte, 0)
+	for _, c := range credentials {
+		if credentialID, err := webauthn.DecodeBase64URL(c.CredentialID); err == nil {
+			exclude = append(exclude, credentialID)
+		}
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return helper.ErrorMessage(0, "failed to create challenge"), err
+	}
+
+	session := new(models.WebAuthnSession)
+	session.UserID = id
+	session.Challenge = challenge
+	session.Type = helper.WebAuthnSessionRegistration
+	session.ExpiredAt = time.Now().UTC().Add(helper.WebAuthnSessionTTL)
+	if err := u.UserRepository.CreateWebAuthnSession(session); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
 
-	expiredAtStr := newUserToken.DeletedAt.Format(helper.FormatRFC8601)
+	userHandle := []byte(strconv.Itoa(id))
+	options := helper.WebAuthnConfig().CreationOptions(challenge, userHandle, currentUser.Email, currentUser.FullName, exclude)
 
 	return map[string]interface{}{
-		"access_token": map[string]interface{} {
-			"value": newUserToken.Token,
-			"type": newUserToken.Type,
-			"expired_at": expiredAtStr,
-		},
+		"publicKey": options,
 	}, nil
 }
 
-func (u UserService) NewAccessToken(id int, oldToken string) (map[string]interface{}, error) {
-	_, err := u.UserRepository.FetchUserByID(id)
+func (u UserService) WebAuthnRegistrationFinish(id int, form *models.WebAuthnCredentialForm, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	clientDataJSON, err := webauthn.DecodeBase64URL(form.Response.ClientDataJSON)
+	if err != nil {
+		return helper.ErrorMessage(0, "invalid clientDataJSON"), err
+	}
+
+	attestationObject, err := webauthn.DecodeBase64URL(form.Response.AttestationObject)
+	if err != nil {
+		return helper.ErrorMessage(0, "invalid attestationObject"), err
+	}
+
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	session, err := u.UserRepository.ConsumeWebAuthnSession(clientData.Challenge, helper.WebAuthnSessionRegistration)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	if err := u.UserRepository.DeleteUserTokenByToken(id, oldToken); err != nil {
+	if session.UserID != id {
+		err := errors.New("webauthn session not found")
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	res, err := u.RefreshToken(id)
+	credential, err := helper.WebAuthnConfig().VerifyRegistration(session.Challenge, clientDataJSON, attestationObject, false)
 	if err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	newToken := res["access_token"].(map[string]interface{})["value"].(string)
+	name := form.Name
+	if name == "" {
+		name = "Security key"
+	}
 
-	if err := u.UserRepository.CreateNewToken(id, newToken); err != nil {
+	newCredential := new(models.WebAuthnCredential)
+	newCredential.UserID = id
+	newCredential.Name = name
+	newCredential.CredentialID = webauthn.EncodeBase64URL(credential.ID)
+	newCredential.PublicKey = base64.StdEncoding.EncodeToString(credential.PublicKey)
+	newCredential.SignCount = int64(credential.SignCount)
+	newCredential.AAGUID = hex.EncodeToString(credential.AAGUID)
+	if err := u.UserRepository.CreateWebAuthnCredential(newCredential); err != nil {
 		return helper.ErrorMessage(0, err.Error()), err
 	}
 
-	return res, nil
+	event.Record(u.EventRepository, id, event.TypeWebAuthnCredentialAdded, meta, map[string]interface{}{
+		"credential_id": newCredential.ID,
+		"name": newCredential.Name,
+	})
+
+	return map[string]interface{}{
+		"status": true,
+		"credential": map[string]interface{}{
+			"id": newCredential.ID,
+			"name": newCredential.Name,
+			"created_at": newCredential.CreatedAt.Format(helper.FormatRFC8601),
+		},
+	}, nil
 }
 
-func NewUserService(userRepository user.Repository) user.Service {
+func (u UserService) DeleteWebAuthnCredential(id int, credentialID int, meta *models.RequestMetadata) (map[string]interface{}, error) {
+	if err := u.UserRepository.DeleteWebAuthnCredential(id, credentialID); err != nil {
+		return helper.ErrorMessage(0, err.Error()), err
+	}
+
+	event.Record(u.EventRepository, id, event.TypeWebAuthnCredentialRemoved, meta, map[string]interface{}{
+		"credential_id": credentialID,
+	})
+
+	return map[string]interface{}{
+		"status": true,
+	}, nil
+}
+
+func NewUserService(userRepository user.Repository, eventRepository event.Repository) user.Service {
 	return &UserService{
-		UserRepository: userRepository,
+		UserRepository:  userRepository,
+		EventRepository: eventRepository,
 	}
 }
+
@@ -0,0 +1,42 @@
+package setup
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// MigrateTFA fixes up users enrolled before RFC 6238 TOTP. AutoMigrate adds
+// tfa_last_step without backfilling it, and the old static-code scheme stored
+// a secret that isn't base32, so those accounts could never produce a valid
+// code. Their two-factor authentication is switched off so they enroll again.
+func MigrateTFA(dbConn *gorm.DB) error {
+	tx := dbConn.Begin()
+
+	if err := tx.Exec(`update users set tfa_last_step = 0 where tfa_last_step is null`).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	legacy := `is_tfa = 1 and secret_code !~ '^[A-Z2-7]{32}$'`
+
+	if err := tx.Exec(`delete from back_up_codes where user_id in (select id from users where ` + legacy + `)`).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	result := tx.Exec(`update users set is_tfa = 0, tfa_activation = null, secret_code = '', tfa_last_step = 0 where ` + legacy)
+	if err := result.Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected > 0 {
+		logrus.WithField("users", result.RowsAffected).Info("two-factor authentication reset for accounts enrolled before TOTP")
+	}
+
+	return nil
+}
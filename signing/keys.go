@@ -0,0 +1,136 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+var SupportedAlgorithms = []string{AlgRS256, AlgES256, AlgEdDSA}
+
+type Key struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	ActivatedAt time.Time
+	RetiredAt   time.Time
+	ExpiresAt   time.Time
+}
+
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	}
+
+	return nil, errors.New("unsupported signing algorithm " + algorithm)
+}
+
+func EncodePrivateKey(privateKey crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func DecodePrivateKey(algorithm, encoded string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("invalid private key encoding")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch privateKey := parsed.(type) {
+	case *rsa.PrivateKey:
+		if algorithm == AlgRS256 {
+			return privateKey, nil
+		}
+	case *ecdsa.PrivateKey:
+		if algorithm == AlgES256 && privateKey.Curve == elliptic.P256() {
+			return privateKey, nil
+		}
+	case ed25519.PrivateKey:
+		if algorithm == AlgEdDSA {
+			return privateKey, nil
+		}
+	}
+
+	return nil, errors.New("private key does not match algorithm " + algorithm)
+}
+
+func (k *Key) Method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k *Key) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+func (k *Key) JWK() map[string]interface{} {
+	jwk := map[string]interface{}{
+		"kid": k.ID,
+		"alg": k.Algorithm,
+		"use": "sig",
+	}
+
+	switch publicKey := k.PublicKey().(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = encodeBase64URL(publicKey.N.Bytes())
+		jwk["e"] = encodeBase64URL(big.NewInt(int64(publicKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		jwk["kty"] = "EC"
+		jwk["crv"] = publicKey.Curve.Params().Name
+		jwk["x"] = encodeBase64URL(padBytes(publicKey.X.Bytes(), size))
+		jwk["y"] = encodeBase64URL(padBytes(publicKey.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = encodeBase64URL(publicKey)
+	}
+
+	return jwk
+}
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
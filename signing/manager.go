@@ -0,0 +1,315 @@
+package signing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ardiantirta/go-user-management/models"
+)
+
+const reloadInterval = 10 * time.Second
+
+type Manager struct {
+	Conn *gorm.DB
+
+	mu       sync.RWMutex
+	keys     []*Key
+	loadedAt time.Time
+}
+
+func NewManager(conn *gorm.DB) *Manager {
+	return &Manager{
+		Conn: conn,
+	}
+}
+
+func Algorithm() string {
+	if algorithm := viper.GetString("jwt.algorithm"); algorithm != "" {
+		return algorithm
+	}
+	return AlgES256
+}
+
+func AllowedAlgorithms() []string {
+	if algorithms := viper.GetStringSlice("jwt.allowed_algorithms"); len(algorithms) > 0 {
+		return algorithms
+	}
+	return SupportedAlgorithms
+}
+
+func rotationInterval() time.Duration {
+	if interval := viper.GetDuration("jwt.rotation_interval"); interval > 0 {
+		return interval
+	}
+	return 30 * 24 * time.Hour
+}
+
+func prepublishWindow() time.Duration {
+	if window := viper.GetDuration("jwt.key_prepublish"); window > 0 {
+		return window
+	}
+	return 24 * time.Hour
+}
+
+func retentionWindow() time.Duration {
+	if window := viper.GetDuration("jwt.key_retention"); window > 0 {
+		return window
+	}
+	return 7 * 24 * time.Hour
+}
+
+func (m *Manager) Load() error {
+	rows := make([]*models.SigningKey, 0)
+	if err := m.Conn.Table("signing_keys").
+		Where("expires_at > ?", time.Now().UTC()).
+		Find(&rows).Error; err != nil {
+		return errors.New("failed to load signing keys")
+	}
+
+	keys := make([]*Key, 0)
+	for _, row := range rows {
+		privateKey, err := DecodePrivateKey(row.Algorithm, row.PrivateKey)
+		if err != nil {
+			logrus.WithField("kid", row.KeyID).Error(err)
+			continue
+		}
+
+		keys = append(keys, &Key{
+			ID:          row.KeyID,
+			Algorithm:   row.Algorithm,
+			PrivateKey:  privateKey,
+			ActivatedAt: row.ActivatedAt,
+			RetiredAt:   row.RetiredAt,
+			ExpiresAt:   row.ExpiresAt,
+		})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ActivatedAt.After(keys[j].ActivatedAt)
+	})
+
+	m.mu.Lock()
+	m.keys = keys
+	m.loadedAt = time.Now()
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Rotate makes sure there is a key to sign with, publishes the next key
+// jwt.key_prepublish before the current one retires so verifiers can pick it
+// up from the JWKS in time, and drops keys whose retention has passed.
+func (m *Manager) Rotate() error {
+	now := time.Now().UTC()
+
+	if err := m.Conn.Unscoped().Table("signing_keys").
+		Where("expires_at <= ?", now).
+		Delete(models.SigningKey{}).Error; err != nil {
+		return errors.New("failed to delete expired signing keys")
+	}
+
+	if err := m.Load(); err != nil {
+		return err
+	}
+
+	current := m.current(now)
+	if current == nil {
+		if _, err := m.create(now); err != nil {
+			return err
+		}
+		return m.Load()
+	}
+
+	if current.RetiredAt.Sub(now) > prepublishWindow() {
+		return nil
+	}
+
+	m.mu.RLock()
+	hasNext := false
+	for _, k := range m.keys {
+		if k.ActivatedAt.After(now) {
+			hasNext = true
+			break
+		}
+	}
+	m.mu.RUnlock()
+
+	if !hasNext {
+		if _, err := m.create(current.RetiredAt); err != nil {
+			return err
+		}
+		return m.Load()
+	}
+
+	return nil
+}
+
+func (m *Manager) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := m.Rotate(); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}()
+}
+
+func (m *Manager) create(activatedAt time.Time) (*models.SigningKey, error) {
+	algorithm := Algorithm()
+
+	privateKey, err := GenerateKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := EncodePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := make([]byte, 16)
+	if _, err := rand.Read(kid); err != nil {
+		return nil, err
+	}
+
+	row := new(models.SigningKey)
+	row.KeyID = hex.EncodeToString(kid)
+	row.Algorithm = algorithm
+	row.PrivateKey = encoded
+	row.ActivatedAt = activatedAt
+	row.RetiredAt = activatedAt.Add(rotationInterval())
+	row.ExpiresAt = row.RetiredAt.Add(retentionWindow())
+	if err := m.Conn.Create(&row).Error; err != nil {
+		return nil, errors.New("failed to create signing key")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"kid":          row.KeyID,
+		"alg":          row.Algorithm,
+		"activated_at": row.ActivatedAt,
+	}).Info("signing key created")
+
+	return row, nil
+}
+
+func (m *Manager) current(now time.Time) *Key {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.keys {
+		if !k.ActivatedAt.After(now) && k.RetiredAt.After(now) {
+			return k
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) SigningKey() (*Key, error) {
+	now := time.Now().UTC()
+
+	if k := m.current(now); k != nil {
+		return k, nil
+	}
+
+	if err := m.Rotate(); err != nil {
+		return nil, err
+	}
+
+	if k := m.current(now); k != nil {
+		return k, nil
+	}
+
+	return nil, errors.New("no active signing key")
+}
+
+func (m *Manager) Lookup(kid string) *Key {
+	now := time.Now().UTC()
+
+	find := func() (*Key, time.Time) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+
+		for _, k := range m.keys {
+			if k.ID == kid && k.ExpiresAt.After(now) {
+				return k, m.loadedAt
+			}
+		}
+		return nil, m.loadedAt
+	}
+
+	k, loadedAt := find()
+	if k != nil || time.Since(loadedAt) < reloadInterval {
+		return k
+	}
+
+	if err := m.Load(); err != nil {
+		logrus.Error(err)
+		return nil
+	}
+
+	k, _ = find()
+	return k
+}
+
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	k, err := m.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(k.Method(), claims)
+	token.Header["kid"] = k.ID
+
+	return token.SignedString(k.PrivateKey)
+}
+
+func (m *Manager) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	parser := &jwt.Parser{ValidMethods: AllowedAlgorithms()}
+
+	return parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid")
+		}
+
+		k := m.Lookup(kid)
+		if k == nil {
+			return nil, errors.New("unknown signing key")
+		}
+
+		if token.Method.Alg() != k.Algorithm {
+			return nil, errors.New("token algorithm does not match signing key")
+		}
+
+		return k.PublicKey(), nil
+	})
+}
+
+func (m *Manager) JWKS() map[string]interface{} {
+	now := time.Now().UTC()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]map[string]interface{}, 0)
+	for _, k := range m.keys {
+		if k.ExpiresAt.After(now) {
+			keys = append(keys, k.JWK())
+		}
+	}
+
+	return map[string]interface{}{
+		"keys": keys,
+	}
+}
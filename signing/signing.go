@@ -0,0 +1,50 @@
+package signing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+)
+
+var defaultManager *Manager
+
+func Init(conn *gorm.DB) error {
+	manager := NewManager(conn)
+	if err := manager.Rotate(); err != nil {
+		return err
+	}
+
+	manager.Start(time.Minute)
+	defaultManager = manager
+
+	return nil
+}
+
+func Sign(claims jwt.Claims) (string, error) {
+	if defaultManager == nil {
+		return "", errors.New("signing keys are not initialized")
+	}
+	return defaultManager.Sign(claims)
+}
+
+func Parse(tokenString string) (jwt.MapClaims, error) {
+	if defaultManager == nil {
+		return nil, errors.New("signing keys are not initialized")
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := defaultManager.Parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func JWKS() map[string]interface{} {
+	if defaultManager == nil {
+		return map[string]interface{}{"keys": []interface{}{}}
+	}
+	return defaultManager.JWKS()
+}
@@ -0,0 +1,143 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// decodeCBOR decodes the first CBOR item in data and returns it together with
+// the number of bytes it occupied. Only the subset used by WebAuthn
+// authenticators is supported: integers, byte/text strings, arrays, maps and
+// the simple values false, true and null. Indefinite lengths are rejected.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, int, error) {
+	if depth > 16 {
+		return nil, 0, errors.New("cbor: nesting too deep")
+	}
+
+	if len(data) < 1 {
+		return nil, 0, errCBORTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, 1, nil
+		case 21:
+			return true, 1, nil
+		case 22:
+			return nil, 1, nil
+		default:
+			return nil, 0, errors.New("cbor: unsupported simple value")
+		}
+	}
+
+	arg, n, err := decodeCBORArgument(data, info)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, 0, errors.New("cbor: integer overflow")
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, 0, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if uint64(len(data)-n) < arg {
+			return nil, 0, errCBORTruncated
+		}
+		end := n + int(arg)
+		if major == 2 {
+			b := make([]byte, arg)
+			copy(b, data[n:end])
+			return b, end, nil
+		}
+		return string(data[n:end]), end, nil
+	case 4:
+		if uint64(len(data)-n) < arg {
+			return nil, 0, errCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, m, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += m
+		}
+		return items, n, nil
+	case 5:
+		if uint64(len(data)-n) < arg {
+			return nil, 0, errCBORTruncated
+		}
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, m, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += m
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errors.New("cbor: unsupported map key")
+			}
+
+			value, m, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += m
+
+			items[key] = value
+		}
+		return items, n, nil
+	}
+
+	return nil, 0, errors.New("cbor: unsupported major type")
+}
+
+func decodeCBORArgument(data []byte, info byte) (uint64, int, error) {
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info == 24:
+		if len(data) < 2 {
+			return 0, 0, errCBORTruncated
+		}
+		return uint64(data[1]), 2, nil
+	case info == 25:
+		if len(data) < 3 {
+			return 0, 0, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(data[1:3])), 3, nil
+	case info == 26:
+		if len(data) < 5 {
+			return 0, 0, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(data[1:5])), 5, nil
+	case info == 27:
+		if len(data) < 9 {
+			return 0, 0, errCBORTruncated
+		}
+		return binary.BigEndian.Uint64(data[1:9]), 9, nil
+	}
+
+	return 0, 0, errors.New("cbor: indefinite length items are not supported")
+}
@@ -0,0 +1,145 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	coseCurve     = -1
+	coseX         = -2
+	coseY         = -3
+	coseRSAN      = -1
+	coseRSAE      = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+type PublicKey struct {
+	Algorithm int
+	Key       crypto.PublicKey
+}
+
+func ParsePublicKey(coseKey []byte) (*PublicKey, error) {
+	decoded, n, err := decodeCBOR(coseKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if n != len(coseKey) {
+		return nil, errors.New("cose: trailing data after key")
+	}
+
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("cose: key is not a map")
+	}
+
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseAlgorithm)].(int64)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("cose: invalid P-256 key")
+		}
+
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("cose: point is not on curve")
+		}
+
+		return &PublicKey{Algorithm: AlgES256, Key: key}, nil
+	case kty == coseKeyTypeOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("cose: invalid Ed25519 key")
+		}
+
+		return &PublicKey{Algorithm: AlgEdDSA, Key: ed25519.PublicKey(x)}, nil
+	case kty == coseKeyTypeRSA && alg == AlgRS256:
+		n, _ := m[int64(coseRSAN)].([]byte)
+		e, _ := m[int64(coseRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("cose: invalid RSA key")
+		}
+
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: exponent,
+		}
+
+		return &PublicKey{Algorithm: AlgRS256, Key: key}, nil
+	}
+
+	return nil, errors.New("cose: unsupported key type or algorithm")
+}
+
+func (p *PublicKey) Verify(message, signature []byte) error {
+	switch key := p.Key.(type) {
+	case *ecdsa.PublicKey:
+		var sig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) != 0 {
+			return errors.New("invalid signature encoding")
+		}
+
+		digest := sha256.Sum256(message)
+		if !ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, message, signature) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	}
+
+	return errors.New("unsupported public key")
+}
@@ -0,0 +1,337 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	FlagUserPresent            = 0x01
+	FlagUserVerified           = 0x04
+	FlagAttestedCredentialData = 0x40
+	FlagExtensionDataIncluded  = 0x80
+)
+
+const (
+	CeremonyCreate = "webauthn.create"
+	CeremonyGet    = "webauthn.get"
+)
+
+const (
+	challengeLength             = 32
+	minAuthenticatorDataLength  = 37
+	attestedCredentialDataFixed = 18
+)
+
+var (
+	ErrChallengeMismatch = errors.New("challenge mismatch")
+	ErrOriginMismatch    = errors.New("origin mismatch")
+	ErrRPIDMismatch      = errors.New("relying party mismatch")
+	ErrUserNotPresent    = errors.New("user presence is required")
+	ErrUserNotVerified   = errors.New("user verification is required")
+	ErrSignCount         = errors.New("signature counter did not increase, authenticator may be cloned")
+)
+
+type Config struct {
+	RPID   string
+	RPName string
+	Origin string
+}
+
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type AuthenticatorData struct {
+	RPIDHash            []byte
+	Flags               byte
+	SignCount           uint32
+	AAGUID              []byte
+	CredentialID        []byte
+	CredentialPublicKey []byte
+}
+
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+	AAGUID    []byte
+}
+
+func EncodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func NewChallenge() (string, error) {
+	b := make([]byte, challengeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return EncodeBase64URL(b), nil
+}
+
+func ParseClientData(raw []byte) (*ClientData, error) {
+	clientData := new(ClientData)
+	if err := json.Unmarshal(raw, clientData); err != nil {
+		return nil, errors.New("invalid client data")
+	}
+	return clientData, nil
+}
+
+func ParseAuthenticatorData(raw []byte) (*AuthenticatorData, error) {
+	if len(raw) < minAuthenticatorDataLength {
+		return nil, errors.New("authenticator data is too short")
+	}
+
+	authData := &AuthenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	rest := raw[minAuthenticatorDataLength:]
+	if authData.Flags&FlagAttestedCredentialData != 0 {
+		if len(rest) < attestedCredentialDataFixed {
+			return nil, errors.New("attested credential data is too short")
+		}
+
+		authData.AAGUID = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[attestedCredentialDataFixed:]
+		if len(rest) < idLength {
+			return nil, errors.New("credential id is truncated")
+		}
+
+		authData.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, errors.New("invalid credential public key")
+		}
+
+		authData.CredentialPublicKey = rest[:n]
+		rest = rest[n:]
+	}
+
+	if authData.Flags&FlagExtensionDataIncluded != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, errors.New("invalid extension data")
+		}
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("trailing authenticator data")
+	}
+
+	return authData, nil
+}
+
+func (c Config) verifyClientData(raw []byte, ceremony, challenge string) error {
+	clientData, err := ParseClientData(raw)
+	if err != nil {
+		return err
+	}
+
+	if clientData.Type != ceremony {
+		return errors.New("unexpected ceremony type")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(challenge)) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	if clientData.Origin != c.Origin {
+		return ErrOriginMismatch
+	}
+
+	return nil
+}
+
+func (c Config) verifyAuthenticatorData(authData *AuthenticatorData, requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return ErrRPIDMismatch
+	}
+
+	if authData.Flags&FlagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+
+	if requireUV && authData.Flags&FlagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+
+	return nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create()
+// and returns the new credential. Only "none" attestation and "packed"
+// self attestation are accepted, since registration options always ask for
+// attestation "none".
+func (c Config) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte, requireUV bool) (*Credential, error) {
+	if err := c.verifyClientData(clientDataJSON, CeremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, n, err := decodeCBOR(attestationObject)
+	if err != nil || n != len(attestationObject) {
+		return nil, errors.New("invalid attestation object")
+	}
+
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid attestation object")
+	}
+
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return nil, err
+	}
+
+	if authData.Flags&FlagAttestedCredentialData == 0 || len(authData.CredentialID) == 0 {
+		return nil, errors.New("missing attested credential data")
+	}
+
+	publicKey, err := ParsePublicKey(authData.CredentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return nil, errors.New("unexpected attestation statement")
+		}
+	case "packed":
+		if _, ok := statement["x5c"]; ok {
+			return nil, errors.New("unsupported attestation format")
+		}
+
+		alg, _ := statement["alg"].(int64)
+		sig, _ := statement["sig"].([]byte)
+		if int(alg) != publicKey.Algorithm {
+			return nil, errors.New("attestation algorithm mismatch")
+		}
+
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		if err := publicKey.Verify(append(append([]byte{}, rawAuthData...), clientDataHash[:]...), sig); err != nil {
+			return nil, errors.New("invalid attestation signature")
+		}
+	default:
+		return nil, errors.New("unsupported attestation format")
+	}
+
+	return &Credential{
+		ID:        authData.CredentialID,
+		PublicKey: authData.CredentialPublicKey,
+		SignCount: authData.SignCount,
+		AAGUID:    authData.AAGUID,
+	}, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get() against
+// a stored credential and returns the authenticator's new signature counter.
+func (c Config) VerifyAssertion(challenge string, publicKey []byte, signCount uint32, clientDataJSON, authenticatorData, signature []byte, requireUV bool) (uint32, error) {
+	if err := c.verifyClientData(clientDataJSON, CeremonyGet, challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := ParseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return 0, err
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := key.Verify(append(append([]byte{}, authenticatorData...), clientDataHash[:]...), signature); err != nil {
+		return 0, err
+	}
+
+	if (authData.SignCount != 0 || signCount != 0) && authData.SignCount <= signCount {
+		return 0, ErrSignCount
+	}
+
+	return authData.SignCount, nil
+}
+
+func (c Config) CreationOptions(challenge string, userHandle []byte, name, displayName string, exclude [][]byte) map[string]interface{} {
+	params := make([]map[string]interface{}, 0)
+	for _, alg := range SupportedAlgorithms {
+		params = append(params, map[string]interface{}{"type": "public-key", "alg": alg})
+	}
+
+	return map[string]interface{}{
+		"challenge": challenge,
+		"rp": map[string]interface{}{
+			"id":   c.RPID,
+			"name": c.RPName,
+		},
+		"user": map[string]interface{}{
+			"id":          EncodeBase64URL(userHandle),
+			"name":        name,
+			"displayName": displayName,
+		},
+		"pubKeyCredParams":   params,
+		"timeout":            60000,
+		"attestation":        "none",
+		"excludeCredentials": credentialDescriptors(exclude),
+		"authenticatorSelection": map[string]interface{}{
+			"residentKey":      "preferred",
+			"userVerification": "preferred",
+		},
+	}
+}
+
+func (c Config) RequestOptions(challenge string, allow [][]byte, userVerification string) map[string]interface{} {
+	return map[string]interface{}{
+		"challenge":        challenge,
+		"rpId":             c.RPID,
+		"timeout":          60000,
+		"allowCredentials": credentialDescriptors(allow),
+		"userVerification": userVerification,
+	}
+}
+
+func credentialDescriptors(ids [][]byte) []map[string]interface{} {
+	descriptors := make([]map[string]interface{}, 0)
+	for _, id := range ids {
+		descriptors = append(descriptors, map[string]interface{}{
+			"type": "public-key",
+			"id":   EncodeBase64URL(id),
+		})
+	}
+	return descriptors
+}
@@ -0,0 +1,761 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+var testConfig = Config{
+	RPID:   "example.com",
+	RPName: "Example",
+	Origin: "https://example.com",
+}
+
+// cborPair and cborMap keep map entries in insertion order so the encoded
+// bytes are deterministic.
+type cborPair struct {
+	key   interface{}
+	value interface{}
+}
+
+type cborMap []cborPair
+
+// cborRaw is written out as is, for embedding already encoded items.
+type cborRaw []byte
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		b := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		return b
+	case n <= 0xffffffff:
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+
+	b := []byte{major<<5 | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(b[1:], n)
+	return b
+}
+
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return []byte{0xf6}
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case int:
+		return encodeCBOR(int64(v))
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case cborRaw:
+		return v
+	case []interface{}:
+		out := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case cborMap:
+		out := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, encodeCBOR(pair.key)...)
+			out = append(out, encodeCBOR(pair.value)...)
+		}
+		return out
+	}
+
+	panic("cbor: unsupported test value")
+}
+
+func pad32(b []byte) []byte {
+	out := make([]byte, 32)
+	copy(out[32-len(b):], b)
+	return out
+}
+
+// testAuthenticator is a software authenticator holding one credential.
+type testAuthenticator struct {
+	alg          int
+	key          crypto.Signer
+	credentialID []byte
+	aaguid       []byte
+	signCount    uint32
+}
+
+var rsaTestKey *rsa.PrivateKey
+
+func newTestAuthenticator(t *testing.T, alg int) *testAuthenticator {
+	a := &testAuthenticator{
+		alg:          alg,
+		credentialID: make([]byte, 16),
+		aaguid:       make([]byte, 16),
+	}
+	if _, err := rand.Read(a.credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	switch alg {
+	case AlgES256:
+		a.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, a.key, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		// 2048 bit keys are slow to generate, share one between tests
+		if rsaTestKey == nil {
+			rsaTestKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		}
+		a.key = rsaTestKey
+	default:
+		t.Fatalf("unknown algorithm %d", alg)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func (a *testAuthenticator) coseKey() []byte {
+	switch key := a.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		return encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKeyTypeEC2)},
+			{int64(coseAlgorithm), int64(AlgES256)},
+			{int64(coseCurve), int64(coseCurveP256)},
+			{int64(coseX), pad32(key.X.Bytes())},
+			{int64(coseY), pad32(key.Y.Bytes())},
+		})
+	case ed25519.PublicKey:
+		return encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKeyTypeOKP)},
+			{int64(coseAlgorithm), int64(AlgEdDSA)},
+			{int64(coseCurve), int64(coseCurveEd25519)},
+			{int64(coseX), []byte(key)},
+		})
+	case *rsa.PublicKey:
+		return encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKeyTypeRSA)},
+			{int64(coseAlgorithm), int64(AlgRS256)},
+			{int64(coseRSAN), key.N.Bytes()},
+			{int64(coseRSAE), big.NewInt(int64(key.E)).Bytes()},
+		})
+	}
+
+	panic("unsupported test key")
+}
+
+func (a *testAuthenticator) sign(t *testing.T, message []byte) []byte {
+	var sig []byte
+	var err error
+
+	switch key := a.key.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(message)
+		r, s, signErr := ecdsa.Sign(rand.Reader, key, digest[:])
+		if signErr != nil {
+			t.Fatal(signErr)
+		}
+		sig, err = asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, message)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(message)
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+func (a *testAuthenticator) authenticatorData(rpID string, flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	out := append([]byte{}, rpIDHash[:]...)
+	out = append(out, flags)
+
+	count := make([]byte, 4)
+	binary.BigEndian.PutUint32(count, a.signCount)
+	out = append(out, count...)
+
+	if attested {
+		idLength := make([]byte, 2)
+		binary.BigEndian.PutUint16(idLength, uint16(len(a.credentialID)))
+
+		out = append(out, a.aaguid...)
+		out = append(out, idLength...)
+		out = append(out, a.credentialID...)
+		out = append(out, a.coseKey()...)
+	}
+
+	return out
+}
+
+func clientDataJSON(t *testing.T, ceremony, challenge, origin string) []byte {
+	raw, err := json.Marshal(ClientData{Type: ceremony, Challenge: challenge, Origin: origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func attestationObject(format string, statement cborMap, authData []byte) []byte {
+	return encodeCBOR(cborMap{
+		{"fmt", format},
+		{"attStmt", statement},
+		{"authData", authData},
+	})
+}
+
+// registration builds a create() response with the given attestation format.
+type registration struct {
+	rpID      string
+	origin    string
+	ceremony  string
+	flags     byte
+	format    string
+	challenge string
+}
+
+func defaultRegistration(challenge, format string) registration {
+	return registration{
+		rpID:      testConfig.RPID,
+		origin:    testConfig.Origin,
+		ceremony:  CeremonyCreate,
+		flags:     FlagUserPresent | FlagUserVerified | FlagAttestedCredentialData,
+		format:    format,
+		challenge: challenge,
+	}
+}
+
+func (a *testAuthenticator) register(t *testing.T, r registration) ([]byte, []byte) {
+	clientData := clientDataJSON(t, r.ceremony, r.challenge, r.origin)
+	authData := a.authenticatorData(r.rpID, r.flags, true)
+
+	statement := cborMap{}
+	if r.format == "packed" {
+		clientDataHash := sha256.Sum256(clientData)
+		statement = cborMap{
+			{"alg", int64(a.alg)},
+			{"sig", a.sign(t, append(append([]byte{}, authData...), clientDataHash[:]...))},
+		}
+	}
+
+	return clientData, attestationObject(r.format, statement, authData)
+}
+
+// assertion builds a get() response.
+type assertion struct {
+	rpID      string
+	origin    string
+	ceremony  string
+	flags     byte
+	challenge string
+}
+
+func defaultAssertion(challenge string) assertion {
+	return assertion{
+		rpID:      testConfig.RPID,
+		origin:    testConfig.Origin,
+		ceremony:  CeremonyGet,
+		flags:     FlagUserPresent | FlagUserVerified,
+		challenge: challenge,
+	}
+}
+
+func (a *testAuthenticator) assert(t *testing.T, r assertion) ([]byte, []byte, []byte) {
+	a.signCount++
+
+	clientData := clientDataJSON(t, r.ceremony, r.challenge, r.origin)
+	authData := a.authenticatorData(r.rpID, r.flags, false)
+	clientDataHash := sha256.Sum256(clientData)
+	signature := a.sign(t, append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	return clientData, authData, signature
+}
+
+func newTestChallenge(t *testing.T) string {
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func registerCredential(t *testing.T, a *testAuthenticator) *Credential {
+	challenge := newTestChallenge(t)
+	clientData, attestation := a.register(t, defaultRegistration(challenge, "none"))
+
+	credential, err := testConfig.VerifyRegistration(challenge, clientData, attestation, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return credential
+}
+
+var testAlgorithms = []struct {
+	name string
+	alg  int
+}{
+	{"ES256", AlgES256},
+	{"EdDSA", AlgEdDSA},
+	{"RS256", AlgRS256},
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	for _, alg := range testAlgorithms {
+		for _, format := range []string{"none", "packed"} {
+			t.Run(alg.name+"/"+format, func(t *testing.T) {
+				a := newTestAuthenticator(t, alg.alg)
+				a.signCount = 3
+
+				challenge := newTestChallenge(t)
+				clientData, attestation := a.register(t, defaultRegistration(challenge, format))
+
+				credential, err := testConfig.VerifyRegistration(challenge, clientData, attestation, true)
+				if err != nil {
+					t.Fatalf("registration: %v", err)
+				}
+
+				if !bytes.Equal(credential.ID, a.credentialID) {
+					t.Error("credential id mismatch")
+				}
+				if !bytes.Equal(credential.PublicKey, a.coseKey()) {
+					t.Error("credential public key mismatch")
+				}
+				if !bytes.Equal(credential.AAGUID, a.aaguid) {
+					t.Error("aaguid mismatch")
+				}
+				if credential.SignCount != 3 {
+					t.Errorf("got sign count %d, want 3", credential.SignCount)
+				}
+
+				signCount := credential.SignCount
+				for i := 0; i < 2; i++ {
+					challenge := newTestChallenge(t)
+					clientData, authData, signature := a.assert(t, defaultAssertion(challenge))
+
+					next, err := testConfig.VerifyAssertion(challenge, credential.PublicKey, signCount, clientData, authData, signature, true)
+					if err != nil {
+						t.Fatalf("assertion %d: %v", i, err)
+					}
+					if next != a.signCount {
+						t.Errorf("assertion %d: got sign count %d, want %d", i, next, a.signCount)
+					}
+					signCount = next
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	a := newTestAuthenticator(t, AlgES256)
+	challenge := newTestChallenge(t)
+
+	cases := []struct {
+		name   string
+		modify func(r *registration)
+		want   error
+	}{
+		{"bad challenge", func(r *registration) { r.challenge = newTestChallenge(t) }, ErrChallengeMismatch},
+		{"bad origin", func(r *registration) { r.origin = "https://evil.example.com" }, ErrOriginMismatch},
+		{"bad rpIdHash", func(r *registration) { r.rpID = "evil.example.com" }, ErrRPIDMismatch},
+		{"missing UP", func(r *registration) { r.flags &^= FlagUserPresent }, ErrUserNotPresent},
+		{"missing UV", func(r *registration) { r.flags &^= FlagUserVerified }, ErrUserNotVerified},
+		{"wrong ceremony", func(r *registration) { r.ceremony = CeremonyGet }, nil},
+		{"missing attested data", func(r *registration) { r.flags &^= FlagAttestedCredentialData }, nil},
+		{"unknown format", func(r *registration) { r.format = "fido-u2f" }, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := defaultRegistration(challenge, "packed")
+			c.modify(&r)
+			clientData, attestation := a.register(t, r)
+
+			_, err := testConfig.VerifyRegistration(challenge, clientData, attestation, true)
+			if err == nil {
+				t.Fatal("registration accepted")
+			}
+			if c.want != nil && err != c.want {
+				t.Errorf("got %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationUserVerificationOptional(t *testing.T) {
+	a := newTestAuthenticator(t, AlgEdDSA)
+	challenge := newTestChallenge(t)
+
+	r := defaultRegistration(challenge, "none")
+	r.flags &^= FlagUserVerified
+	clientData, attestation := a.register(t, r)
+
+	if _, err := testConfig.VerifyRegistration(challenge, clientData, attestation, false); err != nil {
+		t.Fatalf("registration without UV rejected: %v", err)
+	}
+}
+
+func TestVerifyRegistrationAttestationStatement(t *testing.T) {
+	a := newTestAuthenticator(t, AlgES256)
+	challenge := newTestChallenge(t)
+	clientData := clientDataJSON(t, CeremonyCreate, challenge, testConfig.Origin)
+	authData := a.authenticatorData(testConfig.RPID, FlagUserPresent|FlagAttestedCredentialData, true)
+	clientDataHash := sha256.Sum256(clientData)
+	signature := a.sign(t, append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	other := newTestAuthenticator(t, AlgES256)
+	otherSignature := other.sign(t, append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	cases := []struct {
+		name      string
+		format    string
+		statement cborMap
+	}{
+		{"none with statement", "none", cborMap{{"alg", int64(AlgES256)}}},
+		{"packed alg mismatch", "packed", cborMap{{"alg", int64(AlgRS256)}, {"sig", signature}}},
+		{"packed missing alg", "packed", cborMap{{"sig", signature}}},
+		{"packed missing sig", "packed", cborMap{{"alg", int64(AlgES256)}}},
+		{"packed wrong key", "packed", cborMap{{"alg", int64(AlgES256)}, {"sig", otherSignature}}},
+		{"packed x5c", "packed", cborMap{{"alg", int64(AlgES256)}, {"sig", signature}, {"x5c", []interface{}{[]byte{0x30}}}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			attestation := attestationObject(c.format, c.statement, authData)
+			if _, err := testConfig.VerifyRegistration(challenge, clientData, attestation, false); err == nil {
+				t.Fatal("registration accepted")
+			}
+		})
+	}
+
+	// the same statement with the right signature goes through
+	attestation := attestationObject("packed", cborMap{{"alg", int64(AlgES256)}, {"sig", signature}}, authData)
+	if _, err := testConfig.VerifyRegistration(challenge, clientData, attestation, false); err != nil {
+		t.Fatalf("valid packed attestation rejected: %v", err)
+	}
+}
+
+func TestVerifyRegistrationMalformedAttestation(t *testing.T) {
+	a := newTestAuthenticator(t, AlgES256)
+	challenge := newTestChallenge(t)
+	clientData, attestation := a.register(t, defaultRegistration(challenge, "none"))
+
+	// every truncation of a valid attestation object has to be rejected
+	for i := 0; i < len(attestation); i++ {
+		if _, err := testConfig.VerifyRegistration(challenge, clientData, attestation[:i], true); err == nil {
+			t.Fatalf("attestation truncated to %d bytes accepted", i)
+		}
+	}
+
+	authData := a.authenticatorData(testConfig.RPID, FlagUserPresent|FlagAttestedCredentialData, true)
+	cases := map[string][]byte{
+		"trailing bytes":        append(append([]byte{}, attestation...), 0x00),
+		"not a map":             encodeCBOR([]interface{}{"none"}),
+		"indefinite length map": {0xbf, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e', 0xff},
+		"float":                 {0xfb, 0, 0, 0, 0, 0, 0, 0, 0},
+		"tagged item":           {0xc0, 0x60},
+		"byte string too long":  {0x5a, 0xff, 0xff, 0xff, 0xff, 0x00},
+		"array too long":        {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"map with array key":    encodeCBOR(cborMap{{[]interface{}{}, "none"}}),
+		"auth data not bytes":   encodeCBOR(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", "text"}}),
+		"auth data truncated":   attestationObject("none", cborMap{}, authData[:len(authData)-1]),
+		"auth data trailing":    attestationObject("none", cborMap{}, append(append([]byte{}, authData...), 0x00)),
+		"auth data too short":   attestationObject("none", cborMap{}, authData[:36]),
+		"credential id cut off": attestationObject("none", cborMap{}, authData[:37+18+4]),
+	}
+
+	for name, raw := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := testConfig.VerifyRegistration(challenge, clientData, raw, false); err == nil {
+				t.Fatal("registration accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationMalformedClientData(t *testing.T) {
+	a := newTestAuthenticator(t, AlgES256)
+	challenge := newTestChallenge(t)
+	_, attestation := a.register(t, defaultRegistration(challenge, "none"))
+
+	for _, raw := range [][]byte{nil, []byte("{"), []byte(`{"type":1}`)} {
+		if _, err := testConfig.VerifyRegistration(challenge, raw, attestation, true); err == nil {
+			t.Errorf("client data %q accepted", raw)
+		}
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	a := newTestAuthenticator(t, AlgES256)
+	credential := registerCredential(t, a)
+	challenge := newTestChallenge(t)
+
+	cases := []struct {
+		name   string
+		modify func(r *assertion)
+		want   error
+	}{
+		{"bad challenge", func(r *assertion) { r.challenge = newTestChallenge(t) }, ErrChallengeMismatch},
+		{"bad origin", func(r *assertion) { r.origin = "http://example.com" }, ErrOriginMismatch},
+		{"bad rpIdHash", func(r *assertion) { r.rpID = "example.org" }, ErrRPIDMismatch},
+		{"missing UP", func(r *assertion) { r.flags &^= FlagUserPresent }, ErrUserNotPresent},
+		{"missing UV", func(r *assertion) { r.flags &^= FlagUserVerified }, ErrUserNotVerified},
+		{"wrong ceremony", func(r *assertion) { r.ceremony = CeremonyCreate }, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := defaultAssertion(challenge)
+			c.modify(&r)
+			clientData, authData, signature := a.assert(t, r)
+
+			_, err := testConfig.VerifyAssertion(challenge, credential.PublicKey, 0, clientData, authData, signature, true)
+			if err == nil {
+				t.Fatal("assertion accepted")
+			}
+			if c.want != nil && err != c.want {
+				t.Errorf("got %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionUserVerificationOptional(t *testing.T) {
+	a := newTestAuthenticator(t, AlgRS256)
+	credential := registerCredential(t, a)
+	challenge := newTestChallenge(t)
+
+	r := defaultAssertion(challenge)
+	r.flags &^= FlagUserVerified
+	clientData, authData, signature := a.assert(t, r)
+
+	if _, err := testConfig.VerifyAssertion(challenge, credential.PublicKey, 0, clientData, authData, signature, false); err != nil {
+		t.Fatalf("assertion without UV rejected: %v", err)
+	}
+}
+
+func TestVerifyAssertionSignature(t *testing.T) {
+	for _, alg := range testAlgorithms {
+		t.Run(alg.name, func(t *testing.T) {
+			a := newTestAuthenticator(t, alg.alg)
+			credential := registerCredential(t, a)
+			challenge := newTestChallenge(t)
+			clientData, authData, signature := a.assert(t, defaultAssertion(challenge))
+
+			tampered := append([]byte{}, signature...)
+			tampered[len(tampered)-1] ^= 0xff
+			if _, err := testConfig.VerifyAssertion(challenge, credential.PublicKey, 0, clientData, authData, tampered, true); err == nil {
+				t.Error("tampered signature accepted")
+			}
+
+			// the signature covers the authenticator data
+			changed := append([]byte{}, authData...)
+			changed[len(changed)-1]++
+			if _, err := testConfig.VerifyAssertion(challenge, credential.PublicKey, 0, clientData, changed, signature, true); err == nil {
+				t.Error("modified authenticator data accepted")
+			}
+
+			// and the client data, through its hash
+			var parsed ClientData
+			if err := json.Unmarshal(clientData, &parsed); err != nil {
+				t.Fatal(err)
+			}
+			parsed.CrossOrigin = true
+			otherClientData, _ := json.Marshal(parsed)
+			if _, err := testConfig.VerifyAssertion(challenge, credential.PublicKey, 0, otherClientData, authData, signature, true); err == nil {
+				t.Error("modified client data accepted")
+			}
+
+			other := newTestAuthenticator(t, AlgEdDSA)
+			if _, err := testConfig.VerifyAssertion(challenge, other.coseKey(), 0, clientData, authData, signature, true); err == nil {
+				t.Error("signature accepted for another key")
+			}
+
+			if _, err := testConfig.VerifyAssertion(challenge, credential.PublicKey, 0, clientData, authData, signature, true); err != nil {
+				t.Errorf("valid assertion rejected: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionSignCount(t *testing.T) {
+	a := newTestAuthenticator(t, AlgEdDSA)
+	credential := registerCredential(t, a)
+
+	assertAt := func(count, stored uint32) (uint32, error) {
+		challenge := newTestChallenge(t)
+		a.signCount = count - 1
+		clientData, authData, signature := a.assert(t, defaultAssertion(challenge))
+		return testConfig.VerifyAssertion(challenge, credential.PublicKey, stored, clientData, authData, signature, true)
+	}
+
+	if next, err := assertAt(10, 9); err != nil || next != 10 {
+		t.Errorf("increasing counter: got %d, %v", next, err)
+	}
+
+	if _, err := assertAt(10, 10); err != ErrSignCount {
+		t.Errorf("repeated counter: got %v, want %v", err, ErrSignCount)
+	}
+
+	if _, err := assertAt(5, 10); err != ErrSignCount {
+		t.Errorf("counter regression: got %v, want %v", err, ErrSignCount)
+	}
+
+	// authenticators without a counter always report zero
+	if next, err := assertAt(0, 0); err != nil || next != 0 {
+		t.Errorf("zero counter: got %d, %v", next, err)
+	}
+
+	// but one that had a counter can't drop back to zero
+	if _, err := assertAt(0, 7); err != ErrSignCount {
+		t.Errorf("counter reset: got %v, want %v", err, ErrSignCount)
+	}
+}
+
+func TestParsePublicKeyRejects(t *testing.T) {
+	ec := newTestAuthenticator(t, AlgES256).key.Public().(*ecdsa.PublicKey)
+	x, y := pad32(ec.X.Bytes()), pad32(ec.Y.Bytes())
+	ed := newTestAuthenticator(t, AlgEdDSA).key.Public().(ed25519.PublicKey)
+
+	cases := map[string][]byte{
+		"unsupported alg":      encodeCBOR(cborMap{{int64(coseKeyType), int64(coseKeyTypeEC2)}, {int64(coseAlgorithm), int64(-35)}, {int64(coseCurve), int64(coseCurveP256)}, {int64(coseX), x}, {int64(coseY), y}}),
+		"unsupported kty":      encodeCBOR(cborMap{{int64(coseKeyType), int64(4)}, {int64(coseAlgorithm), int64(AlgES256)}, {int64(coseCurve), int64(coseCurveP256)}, {int64(coseX), x}, {int64(coseY), y}}),
+		"kty and alg mismatch": encodeCBOR(cborMap{{int64(coseKeyType), int64(coseKeyTypeOKP)}, {int64(coseAlgorithm), int64(AlgES256)}, {int64(coseCurve), int64(coseCurveEd25519)}, {int64(coseX), []byte(ed)}}),
+		"missing kty":          encodeCBOR(cborMap{{int64(coseAlgorithm), int64(AlgEdDSA)}, {int64(coseCurve), int64(coseCurveEd25519)}, {int64(coseX), []byte(ed)}}),
+		"wrong curve":          encodeCBOR(cborMap{{int64(coseKeyType), int64(coseKeyTypeEC2)}, {int64(coseAlgorithm), int64(AlgES256)}, {int64(coseCurve), int64(2)}, {int64(coseX), x}, {int64(coseY), y}}),
+		"short coordinate":     encodeCBOR(cborMap{{int64(coseKeyType), int64(coseKeyTypeEC2)}, {int64(coseAlgorithm), int64(AlgES256)}, {int64(coseCurve), int64(coseCurveP256)}, {int64(coseX), x[1:]}, {int64(coseY), y}}),
+		"point not on curve":   encodeCBOR(cborMap{{int64(coseKeyType), int64(coseKeyTypeEC2)}, {int64(coseAlgorithm), int64(AlgES256)}, {int64(coseCurve), int64(coseCurveP256)}, {int64(coseX), x}, {int64(coseY), x}}),
+		"short Ed25519 key":    encodeCBOR(cborMap{{int64(coseKeyType), int64(coseKeyTypeOKP)}, {int64(coseAlgorithm), int64(AlgEdDSA)}, {int64(coseCurve), int64(coseCurveEd25519)}, {int64(coseX), []byte(ed)[1:]}}),
+		"small RSA modulus":    encodeCBOR(cborMap{{int64(coseKeyType), int64(coseKeyTypeRSA)}, {int64(coseAlgorithm), int64(AlgRS256)}, {int64(coseRSAN), make([]byte, 128)}, {int64(coseRSAE), []byte{1, 0, 1}}}),
+		"missing RSA exponent": encodeCBOR(cborMap{{int64(coseKeyType), int64(coseKeyTypeRSA)}, {int64(coseAlgorithm), int64(AlgRS256)}, {int64(coseRSAN), make([]byte, 256)}}),
+		"not a map":            encodeCBOR([]interface{}{int64(1)}),
+		"trailing data":        append(encodeCBOR(cborMap{{int64(coseKeyType), int64(coseKeyTypeOKP)}, {int64(coseAlgorithm), int64(AlgEdDSA)}, {int64(coseCurve), int64(coseCurveEd25519)}, {int64(coseX), []byte(ed)}}), 0x00),
+		"empty":                nil,
+	}
+
+	for name, raw := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParsePublicKey(raw); err == nil {
+				t.Fatal("key accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationUnsupportedKey(t *testing.T) {
+	a := newTestAuthenticator(t, AlgES256)
+	challenge := newTestChallenge(t)
+	clientData := clientDataJSON(t, CeremonyCreate, challenge, testConfig.Origin)
+
+	ec := a.key.Public().(*ecdsa.PublicKey)
+	coseKey := encodeCBOR(cborMap{
+		{int64(coseKeyType), int64(coseKeyTypeEC2)},
+		{int64(coseAlgorithm), int64(-36)},
+		{int64(coseCurve), int64(3)},
+		{int64(coseX), pad32(ec.X.Bytes())},
+		{int64(coseY), pad32(ec.Y.Bytes())},
+	})
+
+	rpIDHash := sha256.Sum256([]byte(testConfig.RPID))
+	authData := append([]byte{}, rpIDHash[:]...)
+	authData = append(authData, FlagUserPresent|FlagAttestedCredentialData, 0, 0, 0, 0)
+	authData = append(authData, a.aaguid...)
+	authData = append(authData, 0, byte(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, coseKey...)
+
+	if _, err := testConfig.VerifyRegistration(challenge, clientData, attestationObject("none", cborMap{}, authData), false); err == nil {
+		t.Fatal("registration with an unsupported key accepted")
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	valid := encodeCBOR(cborMap{
+		{int64(1), int64(-1)},
+		{"bytes", []byte{1, 2, 3}},
+		{"list", []interface{}{true, false, nil, int64(1000), int64(-70000), "text"}},
+		{"big", int64(1) << 40},
+	})
+
+	decoded, n, err := decodeCBOR(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(valid) {
+		t.Errorf("consumed %d of %d bytes", n, len(valid))
+	}
+
+	m := decoded.(map[interface{}]interface{})
+	if m[int64(1)] != int64(-1) || m["big"] != int64(1)<<40 || !bytes.Equal(m["bytes"].([]byte), []byte{1, 2, 3}) {
+		t.Errorf("unexpected decoded map %v", m)
+	}
+
+	list := m["list"].([]interface{})
+	if len(list) != 6 || list[0] != true || list[1] != false || list[2] != nil || list[3] != int64(1000) || list[4] != int64(-70000) || list[5] != "text" {
+		t.Errorf("unexpected decoded list %v", list)
+	}
+
+	for i := 0; i < len(valid); i++ {
+		if _, _, err := decodeCBOR(valid[:i]); err == nil {
+			t.Errorf("truncation to %d bytes accepted", i)
+		}
+	}
+
+	nested := []byte{}
+	for i := 0; i < 20; i++ {
+		nested = append(nested, 0x81)
+	}
+	nested = append(nested, 0x00)
+
+	malformed := map[string][]byte{
+		"indefinite bytes":    {0x5f, 0x41, 0x00, 0xff},
+		"reserved info":       {0x1c},
+		"simple value":        {0xe0},
+		"integer overflow":    {0x1b, 0x80, 0, 0, 0, 0, 0, 0, 0},
+		"negative overflow":   {0x3b, 0x80, 0, 0, 0, 0, 0, 0, 0},
+		"huge text string":    {0x7b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"map with bytes key":  {0xa1, 0x41, 0x00, 0x00},
+		"nesting too deep":    nested,
+		"truncated argument":  {0x19, 0x01},
+		"truncated map value": {0xa1, 0x01},
+	}
+
+	for name, raw := range malformed {
+		if _, _, err := decodeCBOR(raw); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}